@@ -0,0 +1,343 @@
+package tinymfa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// AccountStatus represents the lifecycle state of an Account.
+type AccountStatus uint8
+
+const (
+	// AccountPending marks an account whose enrollment has not been confirmed yet.
+	AccountPending AccountStatus = iota
+	// AccountActive marks an account that may be used for authentication.
+	AccountActive
+	// AccountDisabled marks an account that must not be used for authentication.
+	AccountDisabled
+)
+
+// ErrAccountDisabled is returned when a disabled account is used for validation.
+var ErrAccountDisabled = errors.New("account is disabled")
+
+// String returns the lower case name of the AccountStatus.
+func (status AccountStatus) String() string {
+	switch status {
+	case AccountPending:
+		return "pending"
+	case AccountActive:
+		return "active"
+	case AccountDisabled:
+		return "disabled"
+	default:
+		return fmt.Sprintf("AccountStatus(%d)", uint8(status))
+	}
+}
+
+// MarshalText encodes the AccountStatus as its name.
+func (status AccountStatus) MarshalText() ([]byte, error) {
+	switch status {
+	case AccountPending, AccountActive, AccountDisabled:
+		return []byte(status.String()), nil
+	default:
+		return nil, fmt.Errorf("invalid account status: %d", uint8(status))
+	}
+}
+
+// UnmarshalText decodes an AccountStatus from its name.
+func (status *AccountStatus) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "pending":
+		*status = AccountPending
+	case "active":
+		*status = AccountActive
+	case "disabled":
+		*status = AccountDisabled
+	default:
+		return fmt.Errorf("invalid account status: %s", text)
+	}
+	return nil
+}
+
+// DefaultLookAhead is the number of HOTP counter values beyond the expected
+// one that are accepted to resynchronize with a token (RFC 4226 Section 7.4).
+const DefaultLookAhead uint64 = 10
+
+// Account bundles a secret key with the TOTP parameters it is used with, so
+// that callers do not have to track them separately. Label usually holds the
+// user name or e-mail address shown in the authenticator app. Counter based
+// accounts of Type HOTP use Counter instead of Period and T0.
+type Account struct {
+	Label      string
+	Issuer     string
+	Type       OtpType
+	Secret     []byte
+	Algorithm  HashAlgorithm
+	Digits     uint8
+	Period     int64
+	T0         int64
+	Counter    uint64
+	Status     AccountStatus
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+
+	// PreviousSecret holds the secret that was replaced by RotateSecret. It
+	// stays valid until PreviousSecretExpiresAt or until the first successful
+	// validation with the new secret, whatever happens first.
+	PreviousSecret          []byte
+	PreviousSecretExpiresAt time.Time
+}
+
+// NewAccount returns a pending account with the default TOTP parameters of
+// RFC 6238 (SHA-1, 6 digits, 30 second time step, T0 = 0).
+func NewAccount(issuer, label string, secret []byte) *Account {
+	return &Account{
+		Label:     label,
+		Issuer:    issuer,
+		Secret:    secret,
+		Algorithm: SHA1,
+		Digits:    6,
+		Period:    DefaultTimeStep,
+		T0:        DefaultT0,
+		Status:    AccountPending,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Generate returns the token of the account for the given unix timestamp.
+// HOTP accounts ignore the timestamp and return the token of their Counter.
+func (account *Account) Generate(timestamp int64) (int, error) {
+	if account.Type == HOTP {
+		return NewTinyMfa().GenerateCounterToken(account.Counter, &account.Secret, account.Digits, account.Algorithm)
+	}
+	return NewTinyMfa().GenerateToken(timestamp, &account.Secret, Present, account.Digits, account.Algorithm, account.Period, account.T0)
+}
+
+// Validate checks a token against the present, past and future time steps of
+// the given unix timestamp. While a secret rotation is in its grace period,
+// tokens of the previous secret are accepted as well. A successful validation
+// updates LastUsedAt. Disabled accounts never validate and yield ErrAccountDisabled.
+func (account *Account) Validate(token int, timestamp int64) (bool, error) {
+	match, err := account.ValidateWithMatch(token, timestamp)
+	return match != MatchNone, err
+}
+
+// matchTimeCounter checks the token against the present, past and future time
+// steps of the given unix timestamp and returns the time counter of the step
+// that matched. HOTP accounts check their look-ahead window instead and
+// return the matching counter value.
+func (account *Account) matchTimeCounter(token int, timestamp int64) (int64, bool, error) {
+	return account.matchSecret(account.Secret, token, timestamp)
+}
+
+// matchSecret works like matchTimeCounter, but uses the given secret instead
+// of the current secret of the account.
+func (account *Account) matchSecret(secret []byte, token int, timestamp int64) (int64, bool, error) {
+	if account.Type == HOTP {
+		next, valid, err := NewTinyMfa().ValidateCounterToken(token, &secret, account.Counter, DefaultLookAhead, account.Digits, account.Algorithm)
+		return int64(next - 1), valid, err
+	}
+	return account.matchSecretTimeCounter(secret, token, timestamp)
+}
+
+// accept records a successful validation. HOTP accounts advance their counter
+// past the matched value, so that the token cannot be used again.
+func (account *Account) accept(counter int64) {
+	if account.Type == HOTP {
+		account.Counter = uint64(counter) + 1
+	}
+	account.LastUsedAt = time.Now().UTC()
+}
+
+// matchSecretTimeCounter works like matchTimeCounter, but uses the given
+// secret instead of the current secret of the account.
+func (account *Account) matchSecretTimeCounter(secret []byte, token int, timestamp int64) (int64, bool, error) {
+	tmfa := NewTinyMfa()
+	for _, offsetType := range []uint8{Present, Past, Future} {
+		generated, err := tmfa.GenerateToken(timestamp, &secret, offsetType, account.Digits, account.Algorithm, account.Period, account.T0)
+		if err != nil {
+			return 0, false, err
+		}
+		if generated == token {
+			counter, err := tmfa.GenerateMessage(timestamp, offsetType, account.Period, account.T0)
+			return counter, err == nil, err
+		}
+	}
+
+	return 0, false, nil
+}
+
+// EncodedSecret returns the secret of the account as unpadded base32 string,
+// the format expected by authenticator apps.
+func (account *Account) EncodedSecret() string {
+	return utils.EncodeBase32Secret(account.Secret, false)
+}
+
+// BuildPayload builds the otpauth:// URL of the account.
+func (account *Account) BuildPayload() string {
+	secret := account.EncodedSecret()
+	if account.Type == HOTP {
+		formatString := "otpauth://hotp/%s:%s@%s?algorithm=%s&counter=%d&digits=%d&issuer=%s&secret=%s"
+		return fmt.Sprintf(formatString, account.Issuer, account.Label, account.Issuer, account.Algorithm, account.Counter, account.Digits, account.Issuer, secret)
+	}
+	return NewTinyMfa().BuildPayload(account.Issuer, account.Label, &secret, account.Digits, account.Algorithm, account.Period)
+}
+
+// accountJSON is the JSON representation of an Account.
+type accountJSON struct {
+	Label      string        `json:"label"`
+	Issuer     string        `json:"issuer"`
+	Type       OtpType       `json:"type"`
+	Secret     []byte        `json:"secret,omitempty"`
+	Algorithm  string        `json:"algorithm"`
+	Digits     uint8         `json:"digits"`
+	Period     int64         `json:"period"`
+	T0         int64         `json:"t0"`
+	Counter    uint64        `json:"counter,omitempty"`
+	Status     AccountStatus `json:"status"`
+	CreatedAt  time.Time     `json:"created-at"`
+	LastUsedAt time.Time     `json:"last-used-at"`
+
+	PreviousSecret          []byte     `json:"previous-secret,omitempty"`
+	PreviousSecretExpiresAt *time.Time `json:"previous-secret-expires-at,omitempty"`
+
+	KeyWrap               string `json:"key-wrap,omitempty"`
+	WrappedSecret         []byte `json:"wrapped-secret,omitempty"`
+	WrappedPreviousSecret []byte `json:"wrapped-previous-secret,omitempty"`
+}
+
+func (account *Account) toJSON(includeSecret bool) accountJSON {
+	document := accountJSON{
+		Label:      account.Label,
+		Issuer:     account.Issuer,
+		Type:       account.Type,
+		Algorithm:  account.Algorithm.String(),
+		Digits:     account.Digits,
+		Period:     account.Period,
+		T0:         account.T0,
+		Counter:    account.Counter,
+		Status:     account.Status,
+		CreatedAt:  account.CreatedAt,
+		LastUsedAt: account.LastUsedAt,
+	}
+	if len(account.PreviousSecret) > 0 {
+		expiresAt := account.PreviousSecretExpiresAt
+		document.PreviousSecretExpiresAt = &expiresAt
+	}
+	if includeSecret {
+		document.Secret = account.Secret
+		document.PreviousSecret = account.PreviousSecret
+	}
+	return document
+}
+
+// MarshalJSON encodes the account without its secret. Use
+// MarshalJSONWithSecret to explicitly include the secret.
+func (account Account) MarshalJSON() ([]byte, error) {
+	return json.Marshal(account.toJSON(false))
+}
+
+// MarshalJSONWithSecret encodes the account including its raw secret.
+// The result must be treated as sensitive as the secret itself.
+func (account *Account) MarshalJSONWithSecret() ([]byte, error) {
+	return json.Marshal(account.toJSON(true))
+}
+
+// KeyWrapAESKWP identifies secrets wrapped with AES Key Wrap with Padding
+// (RFC 5649) in account exports.
+const KeyWrapAESKWP = "aes-kwp"
+
+// WrapSecret returns the secret of the account wrapped with the key
+// encryption key kek (16, 24 or 32 bytes) using AES Key Wrap with Padding
+// (RFC 5649), the format HSMs import as CKM_AES_KEY_WRAP_PAD.
+func (account *Account) WrapSecret(kek []byte) ([]byte, error) {
+	return utils.WrapKeyWithPadding(kek, account.Secret)
+}
+
+// MarshalJSONWithWrappedSecret encodes the account including its secret and
+// previous secret, wrapped with kek using AES Key Wrap with Padding. The
+// result can be stored or transferred without exposing the secret to anyone
+// who does not hold kek.
+func (account *Account) MarshalJSONWithWrappedSecret(kek []byte) ([]byte, error) {
+	document := account.toJSON(false)
+	document.KeyWrap = KeyWrapAESKWP
+
+	var err error
+	if document.WrappedSecret, err = utils.WrapKeyWithPadding(kek, account.Secret); err != nil {
+		return nil, err
+	}
+	if len(account.PreviousSecret) > 0 {
+		if document.WrappedPreviousSecret, err = utils.WrapKeyWithPadding(kek, account.PreviousSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(document)
+}
+
+// UnmarshalJSONWithWrappedSecret decodes an account that was encoded with
+// MarshalJSONWithWrappedSecret and unwraps its secrets with kek. A wrong kek
+// or modified wrapped secrets yield utils.ErrKeyUnwrap.
+func (account *Account) UnmarshalJSONWithWrappedSecret(data []byte, kek []byte) error {
+	var document accountJSON
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	if document.KeyWrap != KeyWrapAESKWP {
+		return fmt.Errorf("unsupported key wrap: %q", document.KeyWrap)
+	}
+
+	var err error
+	if document.Secret, err = utils.UnwrapKeyWithPadding(kek, document.WrappedSecret); err != nil {
+		return err
+	}
+	if len(document.WrappedPreviousSecret) > 0 {
+		if document.PreviousSecret, err = utils.UnwrapKeyWithPadding(kek, document.WrappedPreviousSecret); err != nil {
+			return err
+		}
+	}
+
+	return account.fromJSON(document)
+}
+
+// UnmarshalJSON decodes an account. The secret is restored if present.
+func (account *Account) UnmarshalJSON(data []byte) error {
+	var document accountJSON
+	if err := json.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	return account.fromJSON(document)
+}
+
+func (account *Account) fromJSON(document accountJSON) error {
+	algorithm, err := ParseHashAlgorithm(document.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	*account = Account{
+		Label:      document.Label,
+		Issuer:     document.Issuer,
+		Type:       document.Type,
+		Secret:     document.Secret,
+		Algorithm:  algorithm,
+		Digits:     document.Digits,
+		Period:     document.Period,
+		T0:         document.T0,
+		Counter:    document.Counter,
+		Status:     document.Status,
+		CreatedAt:  document.CreatedAt,
+		LastUsedAt: document.LastUsedAt,
+
+		PreviousSecret: document.PreviousSecret,
+	}
+	if document.PreviousSecretExpiresAt != nil {
+		account.PreviousSecretExpiresAt = *document.PreviousSecretExpiresAt
+	}
+
+	return nil
+}
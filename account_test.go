@@ -0,0 +1,159 @@
+package tinymfa_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestNewAccount(t *testing.T) {
+	account := tinymfa.NewAccount("ACME", "alice", keySHA1)
+	if account.Status != tinymfa.AccountPending {
+		t.Errorf("expected pending account, got %s", account.Status)
+	}
+	if account.Algorithm != tinymfa.SHA1 || account.Digits != 6 || account.Period != tinymfa.DefaultTimeStep {
+		t.Errorf("expected default parameters, got %+v", account)
+	}
+	if account.CreatedAt.IsZero() {
+		t.Error("expected creation time to be set")
+	}
+}
+
+func TestAccountGenerateAndValidate(t *testing.T) {
+	account := tinymfa.NewAccount("ACME", "alice", keySHA256)
+	account.Algorithm = tinymfa.SHA256
+	account.Digits = 8
+
+	for i, ts := range rfcTestTimes {
+		token, err := account.Generate(ts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != rfcExpectedSHA256[i] {
+			t.Errorf("timestamp %d: expected %d, got %d", ts, rfcExpectedSHA256[i], token)
+		}
+	}
+
+	valid, err := account.Validate(rfcExpectedSHA256[3], rfcTestTimes[3])
+	if err != nil || !valid {
+		t.Fatalf("expected token to be valid, got %v, %v", valid, err)
+	}
+	if account.LastUsedAt.IsZero() {
+		t.Error("expected last used time to be set after validation")
+	}
+
+	valid, _ = account.Validate(12345678, rfcTestTimes[3])
+	if valid {
+		t.Error("expected invalid token to be rejected")
+	}
+
+	account.Status = tinymfa.AccountDisabled
+	if _, err := account.Validate(rfcExpectedSHA256[3], rfcTestTimes[3]); !errors.Is(err, tinymfa.ErrAccountDisabled) {
+		t.Errorf("expected ErrAccountDisabled, got %v", err)
+	}
+}
+
+func TestAccountBuildPayload(t *testing.T) {
+	account := tinymfa.NewAccount("ACME", "alice", keySHA1)
+	account.Algorithm = tinymfa.SHA512
+	account.Period = 60
+
+	descriptions, err := tmfa.ParsePayload(account.BuildPayload())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(descriptions[0].Secret, keySHA1) || descriptions[0].Algorithm != tinymfa.SHA512 || descriptions[0].Period != 60 {
+		t.Errorf("unexpected payload description %+v", descriptions[0])
+	}
+}
+
+func TestAccountJSON(t *testing.T) {
+	account := tinymfa.NewAccount("ACME", "alice", keySHA1)
+	account.Status = tinymfa.AccountActive
+
+	encoded, err := json.Marshal(account)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(encoded), "secret") {
+		t.Errorf("expected secret to be omitted, got %s", encoded)
+	}
+	if !strings.Contains(string(encoded), `"status":"active"`) || !strings.Contains(string(encoded), `"algorithm":"SHA1"`) {
+		t.Errorf("expected readable status and algorithm, got %s", encoded)
+	}
+
+	// marshalling by value must not leak the secret either
+	encoded, _ = json.Marshal(*account)
+	if strings.Contains(string(encoded), "secret") {
+		t.Errorf("expected secret to be omitted, got %s", encoded)
+	}
+
+	withSecret, err := account.MarshalJSONWithSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded tinymfa.Account
+	if err := json.Unmarshal(withSecret, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(decoded.Secret, keySHA1) || decoded.Status != tinymfa.AccountActive || decoded.Label != "alice" {
+		t.Errorf("expected account to round trip, got %+v", decoded)
+	}
+	if !decoded.CreatedAt.Equal(account.CreatedAt) {
+		t.Errorf("expected creation time to round trip")
+	}
+
+	if err := json.Unmarshal([]byte(`{"algorithm":"MD5"}`), &decoded); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+	if err := json.Unmarshal([]byte(`{"algorithm":"SHA1","status":"unknown"}`), &decoded); err == nil {
+		t.Error("expected error for unknown status")
+	}
+}
+
+func TestAccountJSONWithWrappedSecret(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x42}, 32)
+	account := tinymfa.NewAccount("ACME", "alice", keySHA1)
+	account.PreviousSecret = keySHA256
+
+	encoded, err := account.MarshalJSONWithWrappedSecret(kek)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"key-wrap":"aes-kwp"`) || strings.Contains(string(encoded), `"secret"`) {
+		t.Errorf("expected only wrapped secrets, got %s", encoded)
+	}
+
+	var decoded tinymfa.Account
+	if err := decoded.UnmarshalJSONWithWrappedSecret(encoded, kek); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(decoded.Secret, keySHA1) || !bytes.Equal(decoded.PreviousSecret, keySHA256) || decoded.Label != "alice" {
+		t.Errorf("expected account to round trip, got %+v", decoded)
+	}
+
+	wrongKek := bytes.Repeat([]byte{0x43}, 32)
+	if err := decoded.UnmarshalJSONWithWrappedSecret(encoded, wrongKek); !errors.Is(err, utils.ErrKeyUnwrap) {
+		t.Errorf("expected ErrKeyUnwrap, got %v", err)
+	}
+	withSecret, _ := account.MarshalJSONWithSecret()
+	if err := decoded.UnmarshalJSONWithWrappedSecret(withSecret, kek); err == nil {
+		t.Error("expected error for an export without wrapped secret")
+	}
+
+	// the wrapped secret is plain RFC 5649 output, as imported by HSMs
+	wrapped, err := account.WrapSecret(kek)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unwrapped, err := utils.UnwrapKeyWithPadding(kek, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, keySHA1) {
+		t.Errorf("expected secret to unwrap, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package tinymfa
+
+import (
+	"crypto/hkdf"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// MinMasterKeySize is the minimum size of a master key used for secret derivation.
+const MinMasterKeySize = 32
+
+var (
+	// ErrMasterKeyTooShort is returned when a master key is shorter than MinMasterKeySize.
+	ErrMasterKeyTooShort = fmt.Errorf("master key must be at least %d bytes", MinMasterKeySize)
+
+	// ErrUnknownDerivationVersion is returned when no master key is registered for a version.
+	ErrUnknownDerivationVersion = errors.New("unknown derivation version")
+)
+
+// DeriveSecretKeyForAlgorithm derives a per-user secret key from a master key
+// and a stable user identifier with HKDF (RFC 5869), using the HMAC of the
+// given algorithm. The derived key has the size recommended for the algorithm
+// by RFC 6238 Section 4, just like the keys of GenerateSecretKeyForAlgorithm.
+// The version is part of the derivation context, so that a new master key
+// can be introduced under a new version without changing existing secrets.
+func (tinymfa *TinyMfa) DeriveSecretKeyForAlgorithm(masterKey []byte, userID string, algorithm HashAlgorithm, version uint32) (*[]byte, error) {
+	if len(masterKey) < MinMasterKeySize {
+		return nil, ErrMasterKeyTooShort
+	}
+	if userID == "" {
+		return nil, errors.New("user id must not be empty")
+	}
+
+	hashFunc, err := hashFuncForAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := keySizeForAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(hashFunc, masterKey, nil, derivationContext(userID, algorithm, version), int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// derivationContext builds the HKDF info parameter. The user id comes last,
+// so that no two combinations of version, algorithm and user id collide.
+func derivationContext(userID string, algorithm HashAlgorithm, version uint32) string {
+	return fmt.Sprintf("go-tiny-mfa/secret/v%d/%s/%s", version, algorithm, userID)
+}
+
+// SecretDeriver derives per-user secrets from a set of versioned master keys.
+// New secrets are derived with the current version, while secrets derived
+// with older versions can still be reproduced until their master key is
+// removed. This allows stateless validators that only need the master keys
+// and the derivation version of a user. It is safe for concurrent use.
+type SecretDeriver struct {
+	mutex      sync.RWMutex
+	tmfa       TinyMfaInterface
+	masterKeys map[uint32][]byte
+	current    uint32
+}
+
+// NewSecretDeriver returns a SecretDeriver that uses the given master key as
+// its current version.
+func NewSecretDeriver(tmfa TinyMfaInterface, version uint32, masterKey []byte) (*SecretDeriver, error) {
+	deriver := &SecretDeriver{
+		tmfa:       tmfa,
+		masterKeys: make(map[uint32][]byte),
+	}
+	if err := deriver.AddMasterKey(version, masterKey); err != nil {
+		return nil, err
+	}
+	deriver.current = version
+
+	return deriver, nil
+}
+
+// AddMasterKey registers a master key under the given version.
+func (deriver *SecretDeriver) AddMasterKey(version uint32, masterKey []byte) error {
+	if len(masterKey) < MinMasterKeySize {
+		return ErrMasterKeyTooShort
+	}
+
+	deriver.mutex.Lock()
+	defer deriver.mutex.Unlock()
+	deriver.masterKeys[version] = append([]byte(nil), masterKey...)
+
+	return nil
+}
+
+// RemoveMasterKey removes the master key of a version. Secrets derived with
+// it can no longer be reproduced. The current version cannot be removed.
+func (deriver *SecretDeriver) RemoveMasterKey(version uint32) error {
+	deriver.mutex.Lock()
+	defer deriver.mutex.Unlock()
+
+	if version == deriver.current {
+		return errors.New("the current derivation version cannot be removed")
+	}
+	masterKey, found := deriver.masterKeys[version]
+	if !found {
+		return ErrUnknownDerivationVersion
+	}
+	clear(masterKey)
+	delete(deriver.masterKeys, version)
+
+	return nil
+}
+
+// SetCurrentVersion selects the master key that is used for new secrets.
+func (deriver *SecretDeriver) SetCurrentVersion(version uint32) error {
+	deriver.mutex.Lock()
+	defer deriver.mutex.Unlock()
+
+	if _, found := deriver.masterKeys[version]; !found {
+		return ErrUnknownDerivationVersion
+	}
+	deriver.current = version
+
+	return nil
+}
+
+// CurrentVersion returns the version that is used for new secrets.
+func (deriver *SecretDeriver) CurrentVersion() uint32 {
+	deriver.mutex.RLock()
+	defer deriver.mutex.RUnlock()
+
+	return deriver.current
+}
+
+// DeriveSecretKey derives the secret of a user with the current master key and
+// returns it together with the version that has to be kept for the user.
+func (deriver *SecretDeriver) DeriveSecretKey(userID string, algorithm HashAlgorithm) (*[]byte, uint32, error) {
+	version := deriver.CurrentVersion()
+	key, err := deriver.DeriveSecretKeyVersion(userID, algorithm, version)
+	return key, version, err
+}
+
+// DeriveSecretKeyVersion derives the secret of a user with the master key of
+// the given version. The read lock is held during the derivation, so that
+// RemoveMasterKey cannot zero the master key while it is in use.
+func (deriver *SecretDeriver) DeriveSecretKeyVersion(userID string, algorithm HashAlgorithm, version uint32) (*[]byte, error) {
+	deriver.mutex.RLock()
+	defer deriver.mutex.RUnlock()
+
+	masterKey, found := deriver.masterKeys[version]
+	if !found {
+		return nil, ErrUnknownDerivationVersion
+	}
+
+	return deriver.tmfa.DeriveSecretKeyForAlgorithm(masterKey, userID, algorithm, version)
+}
@@ -0,0 +1,136 @@
+package tinymfa_test
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+var masterKeyV1 = []byte("0123456789abcdef0123456789abcdef")
+var masterKeyV2 = []byte("fedcba9876543210fedcba9876543210")
+
+func TestDeriveSecretKeyForAlgorithm(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm tinymfa.HashAlgorithm
+		expected  int
+	}{
+		{"SHA1", tinymfa.SHA1, int(tinymfa.KeySizeSHA1)},
+		{"SHA256", tinymfa.SHA256, int(tinymfa.KeySizeSHA256)},
+		{"SHA512", tinymfa.SHA512, int(tinymfa.KeySizeSHA512)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tmfa.DeriveSecretKeyForAlgorithm(masterKeyV1, "alice", tt.algorithm, 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(*key) != tt.expected {
+				t.Errorf("expected key length %d, got %d", tt.expected, len(*key))
+			}
+
+			again, _ := tmfa.DeriveSecretKeyForAlgorithm(masterKeyV1, "alice", tt.algorithm, 1)
+			if !bytes.Equal(*key, *again) {
+				t.Error("expected derivation to be deterministic")
+			}
+
+			other, _ := tmfa.DeriveSecretKeyForAlgorithm(masterKeyV1, "bob", tt.algorithm, 1)
+			if bytes.Equal(*key, *other) {
+				t.Error("expected different users to get different secrets")
+			}
+
+			nextVersion, _ := tmfa.DeriveSecretKeyForAlgorithm(masterKeyV1, "alice", tt.algorithm, 2)
+			if bytes.Equal(*key, *nextVersion) {
+				t.Error("expected different versions to yield different secrets")
+			}
+		})
+	}
+
+	if _, err := tmfa.DeriveSecretKeyForAlgorithm([]byte("short"), "alice", tinymfa.SHA1, 1); !errors.Is(err, tinymfa.ErrMasterKeyTooShort) {
+		t.Errorf("expected ErrMasterKeyTooShort, got %v", err)
+	}
+	if _, err := tmfa.DeriveSecretKeyForAlgorithm(masterKeyV1, "", tinymfa.SHA1, 1); err == nil {
+		t.Error("expected error for empty user id")
+	}
+	if _, err := tmfa.DeriveSecretKeyForAlgorithm(masterKeyV1, "alice", 99, 1); err == nil {
+		t.Error("expected error for invalid algorithm")
+	}
+}
+
+func TestSecretDeriverRotation(t *testing.T) {
+	deriver, err := tinymfa.NewSecretDeriver(tmfa, 1, masterKeyV1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oldKey, version, err := deriver.DeriveSecretKey("alice", tinymfa.SHA256)
+	if err != nil || version != 1 {
+		t.Fatalf("expected version 1, got %d, %v", version, err)
+	}
+
+	if err := deriver.AddMasterKey(2, masterKeyV2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := deriver.SetCurrentVersion(2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newKey, version, err := deriver.DeriveSecretKey("alice", tinymfa.SHA256)
+	if err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %d, %v", version, err)
+	}
+	if bytes.Equal(*oldKey, *newKey) {
+		t.Error("expected a new master key to yield a new secret")
+	}
+
+	// users that have not been migrated yet still validate with version 1
+	reproduced, err := deriver.DeriveSecretKeyVersion("alice", tinymfa.SHA256, 1)
+	if err != nil || !bytes.Equal(*reproduced, *oldKey) {
+		t.Errorf("expected version 1 secret to be reproducible, got %v", err)
+	}
+
+	if err := deriver.RemoveMasterKey(2); err == nil {
+		t.Error("expected error when removing the current version")
+	}
+	if err := deriver.RemoveMasterKey(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := deriver.DeriveSecretKeyVersion("alice", tinymfa.SHA256, 1); !errors.Is(err, tinymfa.ErrUnknownDerivationVersion) {
+		t.Errorf("expected ErrUnknownDerivationVersion, got %v", err)
+	}
+	if err := deriver.SetCurrentVersion(3); !errors.Is(err, tinymfa.ErrUnknownDerivationVersion) {
+		t.Errorf("expected ErrUnknownDerivationVersion, got %v", err)
+	}
+	if _, err := tinymfa.NewSecretDeriver(tmfa, 1, []byte("short")); !errors.Is(err, tinymfa.ErrMasterKeyTooShort) {
+		t.Errorf("expected ErrMasterKeyTooShort, got %v", err)
+	}
+}
+
+func TestSecretDeriverConcurrentRemoval(t *testing.T) {
+	for range 20 {
+		deriver, _ := tinymfa.NewSecretDeriver(tmfa, 2, masterKeyV2)
+		deriver.AddMasterKey(1, masterKeyV1)
+		expected, _ := deriver.DeriveSecretKeyVersion("alice", tinymfa.SHA256, 1)
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// a derivation either sees the whole master key or none at all
+				key, err := deriver.DeriveSecretKeyVersion("alice", tinymfa.SHA256, 1)
+				if err == nil && !bytes.Equal(*key, *expected) {
+					t.Error("expected the derivation to use the intact master key")
+				} else if err != nil && !errors.Is(err, tinymfa.ErrUnknownDerivationVersion) {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+		}
+		deriver.RemoveMasterKey(1)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,143 @@
+package tinymfa
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrDeviceNotFound is returned when a user has no device of the given name.
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// ErrDeviceExists is returned when a user already has a device of the given name.
+	ErrDeviceExists = errors.New("device already exists")
+
+	// ErrNoCredential is returned when a device is added without a credential.
+	ErrNoCredential = errors.New("device requires a credential")
+)
+
+// Device is a named authenticator of a user, such as a phone app or a
+// hardware token. Its credential is a TOTP or HOTP Account.
+type Device struct {
+	Name       string    `json:"name"`
+	Credential *Account  `json:"credential"`
+	AddedAt    time.Time `json:"added-at"`
+	RevokedAt  time.Time `json:"revoked-at,omitzero"`
+}
+
+// Active reports whether the device may be used for authentication.
+func (device *Device) Active() bool {
+	return device.Credential != nil && device.Credential.Status == AccountActive
+}
+
+// DeviceValidation is the result of validating a token against the devices of a user.
+type DeviceValidation struct {
+	// Device is the name of the device whose credential matched, if any.
+	Device  string
+	Match   SecretMatch
+	Success bool
+	Error   error
+}
+
+// UserDevices holds all authenticator devices registered for a single user.
+type UserDevices struct {
+	Issuer  string    `json:"issuer"`
+	User    string    `json:"user"`
+	Devices []*Device `json:"devices"`
+}
+
+// NewUserDevices returns an empty device list for the given issuer and user.
+func NewUserDevices(issuer, user string) *UserDevices {
+	return &UserDevices{Issuer: issuer, User: user}
+}
+
+// AddDevice registers a credential under the given device name. The device
+// takes part in validation as soon as its credential is active.
+func (devices *UserDevices) AddDevice(name string, credential *Account) (*Device, error) {
+	if credential == nil {
+		return nil, ErrNoCredential
+	}
+	if _, err := devices.Device(name); err == nil {
+		return nil, ErrDeviceExists
+	}
+
+	device := &Device{
+		Name:       name,
+		Credential: credential,
+		AddedAt:    time.Now().UTC(),
+	}
+	devices.Devices = append(devices.Devices, device)
+
+	return device, nil
+}
+
+// Device returns the device with the given name.
+func (devices *UserDevices) Device(name string) (*Device, error) {
+	for _, device := range devices.Devices {
+		if device.Name == name {
+			return device, nil
+		}
+	}
+	return nil, ErrDeviceNotFound
+}
+
+// ActiveDevices returns all devices that may be used for authentication.
+func (devices *UserDevices) ActiveDevices() []*Device {
+	var active []*Device
+	for _, device := range devices.Devices {
+		if device.Active() {
+			active = append(active, device)
+		}
+	}
+	return active
+}
+
+// RevokeDevice disables the credential of a single device. The device is
+// kept, so that it still shows up in the device list of the user.
+func (devices *UserDevices) RevokeDevice(name string) error {
+	device, err := devices.Device(name)
+	if err != nil {
+		return err
+	}
+
+	// a device without a credential, e.g. from a decoded device list, is
+	// never active, but is still marked as revoked
+	if device.Credential != nil {
+		device.Credential.Status = AccountDisabled
+	}
+	device.RevokedAt = time.Now().UTC()
+
+	return nil
+}
+
+// RemoveDevice deletes a device from the device list.
+func (devices *UserDevices) RemoveDevice(name string) error {
+	for index, device := range devices.Devices {
+		if device.Name == name {
+			devices.Devices = append(devices.Devices[:index], devices.Devices[index+1:]...)
+			return nil
+		}
+	}
+	return ErrDeviceNotFound
+}
+
+// Validate checks the token against the credentials of all active devices at
+// the given unix timestamp and reports the first device that matched. HOTP
+// credentials that match are advanced, so the token cannot be used again.
+func (devices *UserDevices) Validate(token int, timestamp int64) DeviceValidation {
+	var firstErr error
+	for _, device := range devices.ActiveDevices() {
+		match, err := device.Credential.ValidateWithMatch(token, timestamp)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if match != MatchNone {
+			return DeviceValidation{Device: device.Name, Match: match, Success: true}
+		}
+	}
+
+	return DeviceValidation{Error: firstErr}
+}
@@ -0,0 +1,121 @@
+package tinymfa_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+func newDevices(t *testing.T) *tinymfa.UserDevices {
+	t.Helper()
+
+	phone := tinymfa.NewAccount("ACME", "alice", keySHA256)
+	phone.Algorithm = tinymfa.SHA256
+	phone.Status = tinymfa.AccountActive
+
+	token := tinymfa.NewAccount("ACME", "alice", keySHA1)
+	token.Type = tinymfa.HOTP
+	token.Status = tinymfa.AccountActive
+
+	devices := tinymfa.NewUserDevices("ACME", "alice")
+	if _, err := devices.AddDevice("phone", phone); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := devices.AddDevice("yubikey", token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return devices
+}
+
+func TestUserDevicesValidate(t *testing.T) {
+	devices := newDevices(t)
+	now := time.Now().Unix()
+
+	phone, _ := devices.Device("phone")
+	token, _ := phone.Credential.Generate(now)
+	result := devices.Validate(token, now)
+	if !result.Success || result.Device != "phone" || result.Match != tinymfa.MatchCurrent {
+		t.Errorf("expected phone to match, got %+v", result)
+	}
+
+	// HOTP tokens are matched within the look-ahead window and then consumed
+	result = devices.Validate(rfcExpectedHOTP[2], now)
+	if !result.Success || result.Device != "yubikey" {
+		t.Errorf("expected yubikey to match, got %+v", result)
+	}
+	yubikey, _ := devices.Device("yubikey")
+	if yubikey.Credential.Counter != 3 {
+		t.Errorf("expected HOTP counter to advance to 3, got %d", yubikey.Credential.Counter)
+	}
+	if result := devices.Validate(rfcExpectedHOTP[2], now); result.Success {
+		t.Errorf("expected consumed HOTP token to be rejected, got %+v", result)
+	}
+
+	if result := devices.Validate(-1, now); result.Success || result.Error != nil {
+		t.Errorf("expected invalid token to be rejected without error, got %+v", result)
+	}
+}
+
+func TestUserDevicesRevoke(t *testing.T) {
+	devices := newDevices(t)
+	now := time.Now().Unix()
+
+	if _, err := devices.AddDevice("phone", tinymfa.NewAccount("ACME", "alice", keySHA1)); !errors.Is(err, tinymfa.ErrDeviceExists) {
+		t.Errorf("expected ErrDeviceExists, got %v", err)
+	}
+
+	if err := devices.RevokeDevice("phone"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	phone, _ := devices.Device("phone")
+	if phone.Active() || phone.RevokedAt.IsZero() {
+		t.Error("expected revoked device to be inactive")
+	}
+	if len(devices.ActiveDevices()) != 1 {
+		t.Errorf("expected 1 active device, got %d", len(devices.ActiveDevices()))
+	}
+
+	token, _ := phone.Credential.Generate(now)
+	if result := devices.Validate(token, now); result.Success {
+		t.Errorf("expected token of revoked device to be rejected, got %+v", result)
+	}
+
+	if _, err := devices.AddDevice("tablet", nil); !errors.Is(err, tinymfa.ErrNoCredential) {
+		t.Errorf("expected ErrNoCredential, got %v", err)
+	}
+	devices.Devices = append(devices.Devices, &tinymfa.Device{Name: "legacy"})
+	if err := devices.RevokeDevice("legacy"); err != nil {
+		t.Errorf("unexpected error for a device without credential: %v", err)
+	}
+
+	if err := devices.RevokeDevice("tablet"); !errors.Is(err, tinymfa.ErrDeviceNotFound) {
+		t.Errorf("expected ErrDeviceNotFound, got %v", err)
+	}
+	if err := devices.RemoveDevice("phone"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := devices.Device("phone"); !errors.Is(err, tinymfa.ErrDeviceNotFound) {
+		t.Errorf("expected removed device to be gone, got %v", err)
+	}
+}
+
+func TestAccountHOTPPayload(t *testing.T) {
+	account := tinymfa.NewAccount("ACME", "alice", keySHA1)
+	account.Type = tinymfa.HOTP
+	account.Counter = 5
+
+	descriptions, err := tmfa.ParsePayload(account.BuildPayload())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if descriptions[0].Type != tinymfa.HOTP || descriptions[0].Counter != 5 {
+		t.Errorf("expected HOTP payload with counter 5, got %+v", descriptions[0])
+	}
+
+	token, err := account.Generate(0)
+	if err != nil || token != rfcExpectedHOTP[5] {
+		t.Errorf("expected HOTP token %d, got %d, %v", rfcExpectedHOTP[5], token, err)
+	}
+}
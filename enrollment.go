@@ -0,0 +1,222 @@
+package tinymfa
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrEnrollmentNotFound is returned when no pending enrollment exists for an issuer and label.
+	ErrEnrollmentNotFound = errors.New("enrollment not found")
+
+	// ErrEnrollmentExpired is returned when a pending enrollment was not confirmed in time.
+	ErrEnrollmentExpired = errors.New("enrollment has expired")
+
+	// ErrInvalidConfirmationCode is returned when a confirmation code does not match the pending secret.
+	ErrInvalidConfirmationCode = errors.New("invalid confirmation code")
+)
+
+// DefaultEnrollmentTTL is the default time a pending enrollment stays valid.
+const DefaultEnrollmentTTL = 10 * time.Minute
+
+// DefaultGarbageCollectionInterval is the interval RunGarbageCollector uses
+// when it is given no positive interval.
+const DefaultGarbageCollectionInterval = time.Minute
+
+// Enrollment is a pending account that waits for the user to prove that the
+// secret has been stored in an authenticator app.
+type Enrollment struct {
+	Account   *Account
+	Payload   string
+	QrCode    []byte
+	ExpiresAt time.Time
+
+	// confirmed and confirmedCounter track the first accepted code when
+	// two consecutive codes are required.
+	confirmed        bool
+	confirmedCounter int64
+}
+
+// EnrollmentManager implements a two-phase enrollment: Begin creates a pending
+// account and returns its QR code, Confirm activates the account once the user
+// submitted a valid code. Pending enrollments that are never confirmed expire
+// and are removed by PurgeExpired. It is safe for concurrent use.
+type EnrollmentManager struct {
+	mutex              sync.Mutex
+	tmfa               TinyMfaInterface
+	ttl                time.Duration
+	requireConsecutive bool
+	pending            map[string]*Enrollment
+}
+
+// NewEnrollmentManager returns an EnrollmentManager whose pending enrollments
+// expire after ttl. If requireConsecutive is set, an account is only activated
+// after the user submitted the codes of two consecutive time steps, which
+// proves that the authenticator clock and parameters match.
+func NewEnrollmentManager(tmfa TinyMfaInterface, ttl time.Duration, requireConsecutive bool) *EnrollmentManager {
+	if ttl <= 0 {
+		ttl = DefaultEnrollmentTTL
+	}
+	return &EnrollmentManager{
+		tmfa:               tmfa,
+		ttl:                ttl,
+		requireConsecutive: requireConsecutive,
+		pending:            make(map[string]*Enrollment),
+	}
+}
+
+// enrollmentKey builds the map key of a pending enrollment.
+func enrollmentKey(issuer, label string) string {
+	return issuer + "\x00" + label
+}
+
+// Begin generates a new secret for the given algorithm and creates a pending
+// account with the default 6 digits and 30 second time step. A previous,
+// unconfirmed enrollment of the same issuer and label is replaced.
+func (manager *EnrollmentManager) Begin(issuer, label string, algorithm HashAlgorithm) (*Enrollment, error) {
+	secret, err := manager.tmfa.GenerateSecretKeyForAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	account := NewAccount(issuer, label, *secret)
+	account.Algorithm = algorithm
+
+	return manager.BeginWithAccount(account)
+}
+
+// BeginWithAccount starts the enrollment of a prepared account, for example
+// one with custom digits or time step. The account is reset to pending.
+func (manager *EnrollmentManager) BeginWithAccount(account *Account) (*Enrollment, error) {
+	account.Status = AccountPending
+
+	payload := account.BuildPayload()
+	qrcode, err := manager.tmfa.GenerateQrCodeFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment := &Enrollment{
+		Account:   account,
+		Payload:   payload,
+		QrCode:    qrcode,
+		ExpiresAt: time.Now().Add(manager.ttl),
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.pending[enrollmentKey(account.Issuer, account.Label)] = enrollment
+
+	return enrollment, nil
+}
+
+// Get returns the pending enrollment of the given issuer and label, for
+// example to show the QR code again.
+func (manager *EnrollmentManager) Get(issuer, label string) (*Enrollment, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	enrollment, found := manager.pending[enrollmentKey(issuer, label)]
+	if !found {
+		return nil, ErrEnrollmentNotFound
+	}
+
+	return enrollment, nil
+}
+
+// Confirm checks a code submitted by the user at the given unix timestamp.
+// Once enough codes have been confirmed, the account is activated, removed
+// from the manager and returned with status AccountActive. If a second,
+// consecutive code is still required, the account is returned with status
+// AccountPending.
+func (manager *EnrollmentManager) Confirm(issuer, label string, token int, timestamp int64) (*Account, error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	key := enrollmentKey(issuer, label)
+	enrollment, found := manager.pending[key]
+	if !found {
+		return nil, ErrEnrollmentNotFound
+	}
+	if !time.Unix(timestamp, 0).Before(enrollment.ExpiresAt) {
+		delete(manager.pending, key)
+		return nil, ErrEnrollmentExpired
+	}
+
+	counter, valid, err := enrollment.Account.matchTimeCounter(token, timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidConfirmationCode
+	}
+
+	if manager.requireConsecutive && (!enrollment.confirmed || counter != enrollment.confirmedCounter+1) {
+		// first code, or a code that does not follow the previous one
+		enrollment.confirmed = true
+		enrollment.confirmedCounter = counter
+		if enrollment.Account.Type == HOTP {
+			enrollment.Account.Counter = uint64(counter) + 1
+		}
+		return enrollment.Account, nil
+	}
+
+	delete(manager.pending, key)
+	enrollment.Account.Status = AccountActive
+	enrollment.Account.accept(counter)
+
+	return enrollment.Account, nil
+}
+
+// Cancel removes the pending enrollment of the given issuer and label.
+func (manager *EnrollmentManager) Cancel(issuer, label string) error {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	key := enrollmentKey(issuer, label)
+	if _, found := manager.pending[key]; !found {
+		return ErrEnrollmentNotFound
+	}
+	delete(manager.pending, key)
+
+	return nil
+}
+
+// PurgeExpired removes all pending enrollments that expired before the given
+// unix timestamp and returns how many were removed.
+func (manager *EnrollmentManager) PurgeExpired(timestamp int64) int {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	now := time.Unix(timestamp, 0)
+	purged := 0
+	for key, enrollment := range manager.pending {
+		if !now.Before(enrollment.ExpiresAt) {
+			delete(manager.pending, key)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// RunGarbageCollector calls PurgeExpired every interval until the context is
+// cancelled. It blocks, so it is usually started in its own goroutine.
+func (manager *EnrollmentManager) RunGarbageCollector(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultGarbageCollectionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			manager.PurgeExpired(now.Unix())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package tinymfa_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+func TestEnrollmentConfirm(t *testing.T) {
+	manager := tinymfa.NewEnrollmentManager(tinymfa.NewTinyMfa(), time.Minute, false)
+	enrollment, err := manager.Begin("ACME", "alice", tinymfa.SHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if enrollment.Account.Status != tinymfa.AccountPending {
+		t.Errorf("expected pending account, got %s", enrollment.Account.Status)
+	}
+	if len(enrollment.Account.Secret) != int(tinymfa.KeySizeSHA256) {
+		t.Errorf("expected secret sized for SHA256, got %d bytes", len(enrollment.Account.Secret))
+	}
+	if len(enrollment.QrCode) == 0 || enrollment.Payload != enrollment.Account.BuildPayload() {
+		t.Error("expected qr code and payload to be returned")
+	}
+
+	now := time.Now().Unix()
+	token, _ := enrollment.Account.Generate(now)
+	if _, err := manager.Confirm("ACME", "alice", (token+1)%1000000, now); !errors.Is(err, tinymfa.ErrInvalidConfirmationCode) {
+		t.Errorf("expected ErrInvalidConfirmationCode, got %v", err)
+	}
+
+	account, err := manager.Confirm("ACME", "alice", token, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if account.Status != tinymfa.AccountActive {
+		t.Errorf("expected active account, got %s", account.Status)
+	}
+	if _, err := manager.Get("ACME", "alice"); !errors.Is(err, tinymfa.ErrEnrollmentNotFound) {
+		t.Errorf("expected confirmed enrollment to be removed, got %v", err)
+	}
+}
+
+func TestEnrollmentConsecutiveCodes(t *testing.T) {
+	manager := tinymfa.NewEnrollmentManager(tinymfa.NewTinyMfa(), time.Minute, true)
+	enrollment, err := manager.Begin("ACME", "alice", tinymfa.SHA1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now().Unix()
+	first, _ := enrollment.Account.Generate(now)
+	second, _ := enrollment.Account.Generate(now + tinymfa.DefaultTimeStep)
+
+	account, err := manager.Confirm("ACME", "alice", first, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if account.Status != tinymfa.AccountPending {
+		t.Errorf("expected account to stay pending after the first code")
+	}
+
+	// repeating the first code does not count as a second code
+	account, _ = manager.Confirm("ACME", "alice", first, now)
+	if account.Status != tinymfa.AccountPending {
+		t.Errorf("expected account to stay pending after repeating a code")
+	}
+
+	account, err = manager.Confirm("ACME", "alice", second, now+tinymfa.DefaultTimeStep)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if account.Status != tinymfa.AccountActive {
+		t.Errorf("expected account to be active after two consecutive codes")
+	}
+}
+
+func TestEnrollmentExpiry(t *testing.T) {
+	manager := tinymfa.NewEnrollmentManager(tinymfa.NewTinyMfa(), time.Minute, false)
+	enrollment, _ := manager.Begin("ACME", "alice", tinymfa.SHA1)
+	if _, err := manager.Begin("ACME", "bob", tinymfa.SHA1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	later := time.Now().Add(2 * time.Minute).Unix()
+	token, _ := enrollment.Account.Generate(later)
+	if _, err := manager.Confirm("ACME", "alice", token, later); !errors.Is(err, tinymfa.ErrEnrollmentExpired) {
+		t.Errorf("expected ErrEnrollmentExpired, got %v", err)
+	}
+
+	if purged := manager.PurgeExpired(time.Now().Unix()); purged != 0 {
+		t.Errorf("expected no enrollment to be purged yet, got %d", purged)
+	}
+	if purged := manager.PurgeExpired(later); purged != 1 {
+		t.Errorf("expected 1 enrollment to be purged, got %d", purged)
+	}
+	if _, err := manager.Get("ACME", "bob"); !errors.Is(err, tinymfa.ErrEnrollmentNotFound) {
+		t.Errorf("expected purged enrollment to be gone, got %v", err)
+	}
+}
+
+func TestEnrollmentGarbageCollector(t *testing.T) {
+	manager := tinymfa.NewEnrollmentManager(tinymfa.NewTinyMfa(), time.Millisecond, false)
+	if _, err := manager.Begin("ACME", "alice", tinymfa.SHA1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.RunGarbageCollector(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := manager.Get("ACME", "alice"); errors.Is(err, tinymfa.ErrEnrollmentNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected garbage collector to remove the expired enrollment")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	// a non-positive interval falls back to the default instead of panicking
+	manager.RunGarbageCollector(ctx, 0)
+
+	if err := manager.Cancel("ACME", "alice"); !errors.Is(err, tinymfa.ErrEnrollmentNotFound) {
+		t.Errorf("expected ErrEnrollmentNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,419 @@
+// Package googleauth reads, writes and validates the secrets files of the
+// pam_google_authenticator module (~/.google_authenticator), so that hosts
+// can be migrated between the PAM module and this library one at a time.
+//
+// A secrets file holds the base32 encoded secret on its first line, followed
+// by option lines starting with `" ` and by 8 digit scratch codes:
+//
+//	JBSWY3DPEHPK3PXP
+//	" RATE_LIMIT 3 30 1700000000
+//	" WINDOW_SIZE 17
+//	" DISALLOW_REUSE 56666666
+//	" TOTP_AUTH
+//	12345678
+//	87654321
+package googleauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+const (
+	// DefaultWindowSize is the number of codes accepted without a
+	// WINDOW_SIZE option: the current one and its neighbours.
+	DefaultWindowSize = 3
+
+	// DefaultStepSize is the time step in seconds without a STEP_SIZE option.
+	DefaultStepSize int64 = 30
+
+	// DefaultScratchCodes is the number of scratch codes NewFile generates.
+	DefaultScratchCodes = 5
+
+	// CodeDigits is the length of verification codes, which the PAM module
+	// always computes with HMAC-SHA-1.
+	CodeDigits = 6
+
+	// ScratchCodeDigits is the length of scratch codes.
+	ScratchCodeDigits = 8
+
+	// FilePermissions are the permissions WriteFile uses, as the
+	// google-authenticator tool does.
+	FilePermissions os.FileMode = 0400
+
+	// maxFileSize is the size limit of the PAM module for secrets files.
+	maxFileSize = 64 * 1024
+)
+
+var (
+	// ErrInvalidFile is returned for secrets files that cannot be parsed.
+	ErrInvalidFile = errors.New("invalid google authenticator file")
+
+	// ErrInsecurePermissions is returned by ReadFile for secrets files that
+	// are accessible by group or others, which the PAM module refuses.
+	ErrInsecurePermissions = errors.New("google authenticator file is accessible by group or others")
+)
+
+// RateLimit allows at most Attempts logins within Interval seconds.
+type RateLimit struct {
+	Attempts int
+	Interval int64
+	// Timestamps are the unix timestamps of the recent attempts.
+	Timestamps []int64
+}
+
+// File is the content of a secrets file. Options that are not known are
+// kept in Options and written back unchanged.
+type File struct {
+	Secret []byte
+
+	// RateLimit is nil if the RATE_LIMIT option is not set.
+	RateLimit *RateLimit
+	// WindowSize is the number of accepted codes, DefaultWindowSize if zero.
+	WindowSize int
+	// DisallowReuse rejects time based codes that were used before.
+	// UsedTimeSteps holds the time steps of the codes that were used.
+	DisallowReuse bool
+	UsedTimeSteps []int64
+	// TOTP selects time based codes and HOTP counter based codes, of which
+	// HOTPCounter is the next counter value. If neither is set, only scratch
+	// codes are accepted, as by the PAM module.
+	TOTP        bool
+	HOTP        bool
+	HOTPCounter uint64
+	// StepSize is the time step in seconds, DefaultStepSize if zero.
+	StepSize int64
+	// TimeSkew is added to the time step, in steps.
+	TimeSkew int64
+
+	// ScratchCodes are the remaining one-time scratch codes.
+	ScratchCodes []string
+	// Options holds unknown option lines without the leading `" `.
+	Options []string
+}
+
+// NewFile returns a file for time based codes with the settings that the
+// google-authenticator tool recommends: three logins every 30 seconds,
+// reuse of codes disallowed and DefaultScratchCodes scratch codes.
+func NewFile(secret []byte) (*File, error) {
+	if err := tinymfa.DefaultKeyPolicy().ValidateKey(secret); err != nil {
+		return nil, err
+	}
+	file := &File{
+		Secret:        secret,
+		RateLimit:     &RateLimit{Attempts: 3, Interval: 30},
+		DisallowReuse: true,
+		TOTP:          true,
+	}
+	if err := file.GenerateScratchCodes(DefaultScratchCodes); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// GenerateScratchCodes appends count random scratch codes.
+func (file *File) GenerateScratchCodes(count int) error {
+	lowest := big.NewInt(10_000_000)
+	span := big.NewInt(90_000_000)
+	for range count {
+		number, err := rand.Int(rand.Reader, span)
+		if err != nil {
+			return err
+		}
+		file.ScratchCodes = append(file.ScratchCodes, number.Add(number, lowest).String())
+	}
+	return nil
+}
+
+// Parse parses the content of a secrets file. The secret is checked against
+// tinymfa.DefaultKeyPolicy.
+func Parse(data []byte) (*File, error) {
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidFile, maxFileSize)
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	secret, err := utils.DecodeBase32Secret(lines[0])
+	if err != nil || len(secret) == 0 {
+		return nil, fmt.Errorf("%w: line 1: invalid secret", ErrInvalidFile)
+	}
+	if err := tinymfa.DefaultKeyPolicy().ValidateKey(secret); err != nil {
+		return nil, fmt.Errorf("%w: line 1: %w", ErrInvalidFile, err)
+	}
+	file := &File{Secret: secret}
+
+	for i, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, `"`):
+			if err := file.parseOption(strings.Fields(line[1:])); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, i+2, err)
+			}
+		case isScratchCode(line):
+			file.ScratchCodes = append(file.ScratchCodes, line)
+		default:
+			return nil, fmt.Errorf("%w: line %d: invalid scratch code", ErrInvalidFile, i+2)
+		}
+	}
+	if file.TOTP && file.HOTP {
+		return nil, fmt.Errorf("%w: both TOTP_AUTH and HOTP_COUNTER are set", ErrInvalidFile)
+	}
+
+	return file, nil
+}
+
+// parseOption parses the fields of an option line.
+func (file *File) parseOption(fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var err error
+	switch name, args := fields[0], fields[1:]; name {
+	case "RATE_LIMIT":
+		if len(args) < 2 {
+			return errors.New("RATE_LIMIT needs attempts and interval")
+		}
+		rateLimit := &RateLimit{}
+		if rateLimit.Attempts, err = parseRange(args[0], 1, 100); err != nil {
+			return fmt.Errorf("RATE_LIMIT attempts: %w", err)
+		}
+		interval, err := parseRange(args[1], 1, 3600)
+		if err != nil {
+			return fmt.Errorf("RATE_LIMIT interval: %w", err)
+		}
+		rateLimit.Interval = int64(interval)
+		if rateLimit.Timestamps, err = parseInts(args[2:]); err != nil {
+			return fmt.Errorf("RATE_LIMIT timestamps: %w", err)
+		}
+		file.RateLimit = rateLimit
+	case "WINDOW_SIZE":
+		if len(args) != 1 {
+			return errors.New("WINDOW_SIZE needs a value")
+		}
+		if file.WindowSize, err = parseRange(args[0], 1, 100); err != nil {
+			return fmt.Errorf("WINDOW_SIZE: %w", err)
+		}
+	case "DISALLOW_REUSE":
+		file.DisallowReuse = true
+		if file.UsedTimeSteps, err = parseInts(args); err != nil {
+			return fmt.Errorf("DISALLOW_REUSE: %w", err)
+		}
+	case "TOTP_AUTH":
+		file.TOTP = true
+	case "HOTP_COUNTER":
+		if len(args) != 1 {
+			return errors.New("HOTP_COUNTER needs a value")
+		}
+		file.HOTP = true
+		if file.HOTPCounter, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+			return fmt.Errorf("HOTP_COUNTER: %w", err)
+		}
+	case "STEP_SIZE":
+		if len(args) != 1 {
+			return errors.New("STEP_SIZE needs a value")
+		}
+		stepSize, err := parseRange(args[0], 1, 60)
+		if err != nil {
+			return fmt.Errorf("STEP_SIZE: %w", err)
+		}
+		file.StepSize = int64(stepSize)
+	case "TIME_SKEW":
+		if len(args) != 1 {
+			return errors.New("TIME_SKEW needs a value")
+		}
+		if file.TimeSkew, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+			return fmt.Errorf("TIME_SKEW: %w", err)
+		}
+	default:
+		file.Options = append(file.Options, strings.Join(fields, " "))
+	}
+
+	return nil
+}
+
+// parseRange parses a decimal number between lowest and highest.
+func parseRange(value string, lowest, highest int) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if number < lowest || number > highest {
+		return 0, fmt.Errorf("%d is not between %d and %d", number, lowest, highest)
+	}
+	return number, nil
+}
+
+// parseInts parses a list of decimal numbers.
+func parseInts(values []string) ([]int64, error) {
+	var numbers []int64
+	for _, value := range values {
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+// isScratchCode reports whether value is an 8 digit scratch code. The PAM
+// module only accepts codes without leading zeros.
+func isScratchCode(value string) bool {
+	return len(value) == ScratchCodeDigits && value[0] != '0' && isDigits(value)
+}
+
+// isDigits reports whether value consists of decimal digits only.
+func isDigits(value string) bool {
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return value != ""
+}
+
+// Marshal returns the content of the secrets file.
+func (file *File) Marshal() ([]byte, error) {
+	if len(file.Secret) == 0 {
+		return nil, fmt.Errorf("%w: empty secret", ErrInvalidFile)
+	}
+	if file.TOTP && file.HOTP {
+		return nil, fmt.Errorf("%w: both TOTP_AUTH and HOTP_COUNTER are set", ErrInvalidFile)
+	}
+	for _, code := range file.ScratchCodes {
+		if !isScratchCode(code) {
+			return nil, fmt.Errorf("%w: invalid scratch code", ErrInvalidFile)
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(utils.EncodeBase32Secret(file.Secret, false) + "\n")
+	if file.RateLimit != nil {
+		fmt.Fprintf(&buffer, "\" RATE_LIMIT %d %d%s\n", file.RateLimit.Attempts, file.RateLimit.Interval, formatInts(file.RateLimit.Timestamps))
+	}
+	if file.WindowSize != 0 {
+		fmt.Fprintf(&buffer, "\" WINDOW_SIZE %d\n", file.WindowSize)
+	}
+	if file.DisallowReuse {
+		fmt.Fprintf(&buffer, "\" DISALLOW_REUSE%s\n", formatInts(file.UsedTimeSteps))
+	}
+	if file.HOTP {
+		fmt.Fprintf(&buffer, "\" HOTP_COUNTER %d\n", file.HOTPCounter)
+	}
+	if file.TOTP {
+		buffer.WriteString("\" TOTP_AUTH\n")
+	}
+	if file.StepSize != 0 {
+		fmt.Fprintf(&buffer, "\" STEP_SIZE %d\n", file.StepSize)
+	}
+	if file.TimeSkew != 0 {
+		fmt.Fprintf(&buffer, "\" TIME_SKEW %d\n", file.TimeSkew)
+	}
+	for _, option := range file.Options {
+		fmt.Fprintf(&buffer, "\" %s\n", option)
+	}
+	for _, code := range file.ScratchCodes {
+		buffer.WriteString(code + "\n")
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// formatInts formats numbers as a space separated list with a leading space.
+func formatInts(numbers []int64) string {
+	var builder strings.Builder
+	for _, number := range numbers {
+		builder.WriteByte(' ')
+		builder.WriteString(strconv.FormatInt(number, 10))
+	}
+	return builder.String()
+}
+
+// ReadFile reads and parses the secrets file at path. Like the PAM module,
+// it refuses files that are accessible by group or others.
+func ReadFile(path string) (*File, error) {
+	handle, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	info, err := handle.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermissions(info); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(handle, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// checkPermissions returns ErrInsecurePermissions for files that are not
+// private to their owner.
+func checkPermissions(info os.FileInfo) error {
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%w: %v", ErrInsecurePermissions, info.Mode())
+	}
+	return nil
+}
+
+// WriteFile writes the secrets file to path with FilePermissions, replacing
+// it atomically. The file is owned by the calling user.
+func (file *File) WriteFile(path string) error {
+	data, err := file.Marshal()
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, data, FilePermissions)
+}
+
+// Account converts the file into an account for this library. The account
+// is disabled if the file accepts neither time nor counter based codes.
+func (file *File) Account(issuer, label string) *tinymfa.Account {
+	account := tinymfa.NewAccount(issuer, label, bytes.Clone(file.Secret))
+	account.Status = tinymfa.AccountDisabled
+	if file.TOTP || file.HOTP {
+		account.Status = tinymfa.AccountActive
+	}
+	account.Period = file.stepSize()
+	account.T0 = -file.TimeSkew * account.Period
+	if file.HOTP {
+		account.Type = tinymfa.HOTP
+		account.Counter = file.HOTPCounter
+	}
+	return account
+}
+
+// stepSize returns the time step in seconds.
+func (file *File) stepSize() int64 {
+	if file.StepSize == 0 {
+		return DefaultStepSize
+	}
+	return file.StepSize
+}
+
+// windowSize returns the number of accepted codes.
+func (file *File) windowSize() int64 {
+	if file.WindowSize == 0 {
+		return DefaultWindowSize
+	}
+	return int64(file.WindowSize)
+}
@@ -0,0 +1,341 @@
+package googleauth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+// testFile is a file as written by the google-authenticator tool, with the
+// secret "12345678901234567890" of RFC 4226.
+const testFile = `GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ
+" RATE_LIMIT 3 30 1111111105
+" WINDOW_SIZE 3
+" DISALLOW_REUSE 37037036
+" TOTP_AUTH
+" SOME_FUTURE_OPTION on
+12345678
+87654321
+`
+
+// testTimestamp lies in time step 37037037, whose RFC 6238 SHA-1 code
+// truncated to 6 digits is 050471.
+const testTimestamp int64 = 1111111111
+
+func code(t *testing.T, file *File, counter int64) string {
+	t.Helper()
+	token, err := tinymfa.NewTinyMfa().GenerateCounterToken(uint64(counter), &file.Secret, CodeDigits, tinymfa.SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.Itoa(token + 1_000_000)[1:]
+}
+
+func TestParse(t *testing.T) {
+	file, err := Parse([]byte(testFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if string(file.Secret) != "12345678901234567890" {
+		t.Errorf("unexpected secret %q", file.Secret)
+	}
+	if file.RateLimit == nil || file.RateLimit.Attempts != 3 || file.RateLimit.Interval != 30 || !slices.Equal(file.RateLimit.Timestamps, []int64{1111111105}) {
+		t.Errorf("unexpected rate limit %+v", file.RateLimit)
+	}
+	if file.WindowSize != 3 || !file.DisallowReuse || !slices.Equal(file.UsedTimeSteps, []int64{37037036}) || !file.TOTP || file.HOTP {
+		t.Errorf("unexpected options %+v", file)
+	}
+	if !slices.Equal(file.ScratchCodes, []string{"12345678", "87654321"}) {
+		t.Errorf("unexpected scratch codes %v", file.ScratchCodes)
+	}
+	if !slices.Equal(file.Options, []string{"SOME_FUTURE_OPTION on"}) {
+		t.Errorf("unexpected unknown options %v", file.Options)
+	}
+
+	marshaled, err := file.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(marshaled) != testFile {
+		t.Errorf("expected the file to be written unchanged, got:\n%s", marshaled)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"not base32!\n",
+		"GEZDGNBV\n",
+		"GEZDGNBVGY3TQOJQ\n\" RATE_LIMIT 3\n",
+		"GEZDGNBVGY3TQOJQ\n\" RATE_LIMIT 0 30\n",
+		"GEZDGNBVGY3TQOJQ\n\" RATE_LIMIT 3 30 yesterday\n",
+		"GEZDGNBVGY3TQOJQ\n\" WINDOW_SIZE 101\n",
+		"GEZDGNBVGY3TQOJQ\n\" STEP_SIZE 61\n",
+		"GEZDGNBVGY3TQOJQ\n\" HOTP_COUNTER -1\n",
+		"GEZDGNBVGY3TQOJQ\n\" TOTP_AUTH\n\" HOTP_COUNTER 1\n",
+		"GEZDGNBVGY3TQOJQ\n1234567\n",
+		"GEZDGNBVGY3TQOJQ\n01234567\n",
+	}
+	for _, data := range tests {
+		if _, err := Parse([]byte(data)); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("Parse(%q) = %v, want ErrInvalidFile", data, err)
+		}
+	}
+	if _, err := Parse([]byte("GEZDGNBV\n")); !errors.Is(err, tinymfa.ErrKeyTooShort) {
+		t.Errorf("expected ErrKeyTooShort for a 5 byte secret, got %v", err)
+	}
+}
+
+func TestNewFile(t *testing.T) {
+	if _, err := NewFile([]byte("12345")); !errors.Is(err, tinymfa.ErrKeyTooShort) {
+		t.Errorf("expected ErrKeyTooShort, got %v", err)
+	}
+
+	file, err := NewFile([]byte("12345678901234567890"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(file.ScratchCodes) != DefaultScratchCodes {
+		t.Errorf("expected %d scratch codes, got %v", DefaultScratchCodes, file.ScratchCodes)
+	}
+	for _, code := range file.ScratchCodes {
+		if !isScratchCode(code) {
+			t.Errorf("invalid scratch code %q", code)
+		}
+	}
+
+	data, err := file.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.RateLimit == nil || parsed.RateLimit.Attempts != 3 || !parsed.DisallowReuse || !parsed.TOTP || parsed.HOTP || !slices.Equal(parsed.ScratchCodes, file.ScratchCodes) {
+		t.Errorf("unexpected round trip %+v", parsed)
+	}
+}
+
+func TestVerifyScratchCodesOnly(t *testing.T) {
+	data := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n12345678\n87654321\n"
+	file, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file.TOTP || file.HOTP {
+		t.Errorf("expected neither time nor counter based codes, got %+v", file)
+	}
+
+	// like the PAM module, only scratch codes are accepted without TOTP_AUTH
+	if err := file.Verify("050471", testTimestamp); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode for a time based code, got %v", err)
+	}
+	if err := file.Verify("12345678", testTimestamp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if account := file.Account("Example", "alice"); account.Status != tinymfa.AccountDisabled {
+		t.Errorf("expected a disabled account, got %v", account.Status)
+	}
+
+	marshaled, err := file.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(marshaled) != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n87654321\n" {
+		t.Errorf("expected no TOTP_AUTH to be added, got:\n%s", marshaled)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	file, _ := Parse([]byte(testFile))
+	file.RateLimit = nil
+
+	if current := code(t, file, 37037037); current != "050471" {
+		t.Fatalf("unexpected code %s", current)
+	}
+	if err := file.Verify("050471", testTimestamp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(file.UsedTimeSteps, []int64{37037036, 37037037}) {
+		t.Errorf("unexpected used time steps %v", file.UsedTimeSteps)
+	}
+	if err := file.Verify("050471", testTimestamp+5); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("expected ErrCodeReused, got %v", err)
+	}
+	// the previous step was used before, the next one was not
+	if err := file.Verify(code(t, file, 37037036), testTimestamp); !errors.Is(err, ErrCodeReused) {
+		t.Errorf("expected ErrCodeReused, got %v", err)
+	}
+	if err := file.Verify(code(t, file, 37037038), testTimestamp); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := file.Verify(code(t, file, 37037039), testTimestamp); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode outside of the window, got %v", err)
+	}
+
+	// steps out of reach of the window are dropped
+	if err := file.Verify(code(t, file, 37037100), 37037100*30); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !slices.Equal(file.UsedTimeSteps, []int64{37037100}) {
+		t.Errorf("unexpected used time steps %v", file.UsedTimeSteps)
+	}
+
+	file.DisallowReuse = false
+	for range 2 {
+		if err := file.Verify("050471", testTimestamp); err != nil {
+			t.Errorf("unexpected error without DISALLOW_REUSE: %v", err)
+		}
+	}
+}
+
+func TestVerifyWindowAndStepSize(t *testing.T) {
+	file, _ := Parse([]byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n\" WINDOW_SIZE 17\n\" STEP_SIZE 60\n\" TIME_SKEW 2\n\" TOTP_AUTH\n"))
+	step := testTimestamp/60 + 2
+
+	for _, offset := range []int64{-8, 0, 8} {
+		if err := file.Verify(code(t, file, step+offset), testTimestamp); err != nil {
+			t.Errorf("offset %d: unexpected error: %v", offset, err)
+		}
+	}
+	for _, offset := range []int64{-9, 9} {
+		if err := file.Verify(code(t, file, step+offset), testTimestamp); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("offset %d: expected ErrInvalidCode, got %v", offset, err)
+		}
+	}
+
+	account := file.Account("Example", "alice")
+	valid, err := account.Validate(mustAtoi(t, code(t, file, step)), testTimestamp)
+	if err != nil || !valid || account.Period != 60 {
+		t.Errorf("expected the account to accept the code, got %v, %v", valid, err)
+	}
+}
+
+func mustAtoi(t *testing.T, value string) int {
+	t.Helper()
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return number
+}
+
+func TestVerifyHOTP(t *testing.T) {
+	file, _ := Parse([]byte("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n\" HOTP_COUNTER 1\n"))
+
+	// RFC 4226 Appendix D: counter 2 yields 359152
+	if err := file.Verify("359152", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if file.HOTPCounter != 3 {
+		t.Errorf("expected counter 3, got %d", file.HOTPCounter)
+	}
+	if err := file.Verify("359152", 0); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode, got %v", err)
+	}
+	if file.HOTPCounter != 4 {
+		t.Errorf("expected a failed attempt to advance the counter to 4, got %d", file.HOTPCounter)
+	}
+
+	data, _ := file.Marshal()
+	if string(data) != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n\" HOTP_COUNTER 4\n" {
+		t.Errorf("unexpected file:\n%s", data)
+	}
+	if account := file.Account("Example", "alice"); account.Type != tinymfa.HOTP || account.Counter != 4 {
+		t.Errorf("unexpected account %+v", account)
+	}
+}
+
+func TestVerifyScratchCodes(t *testing.T) {
+	file, _ := Parse([]byte(testFile))
+	file.RateLimit = nil
+
+	if err := file.Verify("87654321", testTimestamp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(file.ScratchCodes, []string{"12345678"}) {
+		t.Errorf("expected the scratch code to be consumed, got %v", file.ScratchCodes)
+	}
+	if err := file.Verify("87654321", testTimestamp); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected ErrInvalidCode, got %v", err)
+	}
+	for _, invalid := range []string{"", "abcdef", "1234567", "123456789"} {
+		if err := file.Verify(invalid, testTimestamp); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidCode", invalid, err)
+		}
+	}
+}
+
+func TestVerifyRateLimit(t *testing.T) {
+	file, _ := Parse([]byte(testFile))
+
+	// 1111111105 is within the interval, so two more attempts are allowed
+	for i := range 2 {
+		if err := file.Verify("000000", testTimestamp+int64(i)); !errors.Is(err, ErrInvalidCode) {
+			t.Errorf("attempt %d: expected ErrInvalidCode, got %v", i, err)
+		}
+	}
+	if err := file.Verify("050471", testTimestamp+2); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	if !slices.Equal(file.RateLimit.Timestamps, []int64{testTimestamp, testTimestamp + 1, testTimestamp + 2}) {
+		t.Errorf("expected the latest 3 timestamps, got %v", file.RateLimit.Timestamps)
+	}
+
+	// old attempts expire after the interval
+	if err := file.Verify(code(t, file, (testTimestamp+60)/30), testTimestamp+60); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !slices.Equal(file.RateLimit.Timestamps, []int64{testTimestamp + 60}) {
+		t.Errorf("unexpected timestamps %v", file.RateLimit.Timestamps)
+	}
+}
+
+func TestReadWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".google_authenticator")
+	if err := os.WriteFile(path, []byte(testFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); !errors.Is(err, ErrInsecurePermissions) {
+		t.Errorf("expected ErrInsecurePermissions, got %v", err)
+	}
+	if err := VerifyFile(path, "12345678", testTimestamp); !errors.Is(err, ErrInsecurePermissions) {
+		t.Errorf("expected ErrInsecurePermissions, got %v", err)
+	}
+
+	file, _ := Parse([]byte(testFile))
+	if err := file.WriteFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != FilePermissions {
+		t.Errorf("expected %v permissions, got %v", FilePermissions, info.Mode().Perm())
+	}
+
+	if err := VerifyFile(path, "12345678", testTimestamp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := VerifyFile(path, "12345678", testTimestamp+1); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected the used scratch code to be rejected, got %v", err)
+	}
+
+	file, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(file.ScratchCodes, []string{"87654321"}) {
+		t.Errorf("unexpected scratch codes %v", file.ScratchCodes)
+	}
+	if !slices.Equal(file.RateLimit.Timestamps, []int64{1111111105, testTimestamp, testTimestamp + 1}) {
+		t.Errorf("unexpected timestamps %v", file.RateLimit.Timestamps)
+	}
+	if err := VerifyFile(path, "050471", testTimestamp+2); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
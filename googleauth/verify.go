@@ -0,0 +1,198 @@
+package googleauth
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+var (
+	// ErrInvalidCode is returned when a code matches neither a scratch code
+	// nor a verification code of the window.
+	ErrInvalidCode = errors.New("invalid verification code")
+
+	// ErrCodeReused is returned for a time based code that was used before
+	// while DISALLOW_REUSE is set.
+	ErrCodeReused = errors.New("verification code was already used")
+
+	// ErrRateLimited is returned when the attempt exceeds the RATE_LIMIT.
+	ErrRateLimited = errors.New("too many login attempts")
+)
+
+// Verify checks a code at the given unix timestamp with the semantics of
+// the PAM module and updates the file accordingly:
+//
+//   - every attempt is recorded for RATE_LIMIT, and attempts beyond the
+//     limit fail before the code is checked
+//   - 8 digit codes are checked against the scratch codes, which are
+//     removed once used; without TOTP_AUTH or HOTP_COUNTER, no other codes
+//     are accepted
+//   - HOTP codes are accepted for WINDOW_SIZE counter values; the counter
+//     moves past the matching one, or by one after a failed attempt
+//   - TOTP codes are accepted for WINDOW_SIZE time steps around the current
+//     one; with DISALLOW_REUSE, the step is recorded and cannot be used again
+//
+// Since failed attempts change the file as well, it must be written back
+// whatever Verify returns. VerifyFile does so.
+func (file *File) Verify(code string, timestamp int64) error {
+	if err := file.rateLimit(timestamp); err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	if !isDigits(code) {
+		return ErrInvalidCode
+	}
+	if len(code) == ScratchCodeDigits {
+		return file.useScratchCode(code)
+	}
+	if len(code) > CodeDigits || !file.TOTP && !file.HOTP {
+		return ErrInvalidCode
+	}
+	token, err := strconv.Atoi(code)
+	if err != nil {
+		return ErrInvalidCode
+	}
+
+	if file.HOTP {
+		return file.verifyCounterCode(token)
+	}
+	return file.verifyTimeCode(token, timestamp)
+}
+
+// rateLimit records the attempt and fails if there were more than Attempts
+// within Interval seconds. Only the latest Attempts timestamps are kept.
+func (file *File) rateLimit(timestamp int64) error {
+	rateLimit := file.RateLimit
+	if rateLimit == nil {
+		return nil
+	}
+
+	timestamps := []int64{timestamp}
+	for _, previous := range rateLimit.Timestamps {
+		if previous <= timestamp && timestamp-previous < rateLimit.Interval {
+			timestamps = append(timestamps, previous)
+		}
+	}
+	slices.Sort(timestamps)
+
+	exceeded := len(timestamps) > rateLimit.Attempts
+	if exceeded {
+		timestamps = timestamps[len(timestamps)-rateLimit.Attempts:]
+	}
+	rateLimit.Timestamps = timestamps
+
+	if exceeded {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// useScratchCode removes code from the scratch codes.
+func (file *File) useScratchCode(code string) error {
+	index := slices.Index(file.ScratchCodes, code)
+	if index < 0 {
+		return ErrInvalidCode
+	}
+	file.ScratchCodes = slices.Delete(file.ScratchCodes, index, index+1)
+	return nil
+}
+
+// verifyCounterCode checks the codes of the next WINDOW_SIZE counter values.
+func (file *File) verifyCounterCode(token int) error {
+	tmfa := tinymfa.NewTinyMfa()
+	for i := range uint64(file.windowSize()) {
+		generated, err := tmfa.GenerateCounterToken(file.HOTPCounter+i, &file.Secret, CodeDigits, tinymfa.SHA1)
+		if err != nil {
+			return err
+		}
+		if generated == token {
+			file.HOTPCounter += i + 1
+			return nil
+		}
+	}
+
+	// like the PAM module, skip a counter value after a failed attempt
+	file.HOTPCounter++
+	return ErrInvalidCode
+}
+
+// verifyTimeCode checks the codes of the time steps around the current one.
+func (file *File) verifyTimeCode(token int, timestamp int64) error {
+	tmfa := tinymfa.NewTinyMfa()
+	window := file.windowSize()
+	step := floorDiv(timestamp, file.stepSize()) + file.TimeSkew
+
+	for i := -(window - 1) / 2; i <= window/2; i++ {
+		generated, err := tmfa.GenerateCounterToken(uint64(step+i), &file.Secret, CodeDigits, tinymfa.SHA1)
+		if err != nil {
+			return err
+		}
+		if generated == token {
+			return file.invalidateTimeStep(step + i)
+		}
+	}
+
+	return ErrInvalidCode
+}
+
+// invalidateTimeStep records a used time step if DISALLOW_REUSE is set, and
+// drops the recorded steps that are out of reach of the window.
+func (file *File) invalidateTimeStep(step int64) error {
+	if !file.DisallowReuse {
+		return nil
+	}
+
+	window := file.windowSize()
+	file.UsedTimeSteps = slices.DeleteFunc(file.UsedTimeSteps, func(used int64) bool {
+		return used-step >= window || step-used >= window
+	})
+	if slices.Contains(file.UsedTimeSteps, step) {
+		return ErrCodeReused
+	}
+	file.UsedTimeSteps = append(file.UsedTimeSteps, step)
+	return nil
+}
+
+// floorDiv divides rounding towards negative infinity.
+func floorDiv(dividend, divisor int64) int64 {
+	quotient := dividend / divisor
+	if dividend%divisor != 0 && (dividend < 0) != (divisor < 0) {
+		quotient--
+	}
+	return quotient
+}
+
+// VerifyFile verifies a code against the secrets file at path, which must
+// be private to its owner as for ReadFile, and writes the updated file back
+// while holding its lock, so that concurrent logins cannot use a scratch
+// code or time step twice. The permissions are checked on the file that is
+// read under the lock.
+//
+// The lock is held on the separate file path + utils.LockFileSuffix, which
+// pam_google_authenticator does not know about. Verifying codes of the same
+// file with VerifyFile and with the PAM module on one host is therefore not
+// safe: a code may be accepted twice, and one of the updates may be lost.
+func VerifyFile(path, code string, timestamp int64) error {
+	var verifyErr error
+	err := utils.UpdateFileAtomic(path, func(data []byte, info os.FileInfo) ([]byte, error) {
+		if err := checkPermissions(info); err != nil {
+			return nil, err
+		}
+		file, err := Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		verifyErr = file.Verify(code, timestamp)
+		return file.Marshal()
+	})
+	if err != nil {
+		return err
+	}
+	return verifyErr
+}
@@ -0,0 +1,198 @@
+package importer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// aegisSlotPassword is the type of the key slots of an Aegis vault that
+// hold the master key encrypted with a password derived key.
+const aegisSlotPassword = 1
+
+// The limits of the scrypt parameters that a vault may demand. Aegis itself
+// uses N = 2^15, r = 8 and p = 1. The memory scrypt needs, 128 * N * r * p
+// bytes, is limited as well.
+const (
+	aegisMaxScryptN      = 1 << 20
+	aegisMaxScryptR      = 32
+	aegisMaxScryptP      = 16
+	aegisMaxScryptMemory = 256 << 20
+)
+
+type aegisVault struct {
+	Version int `json:"version"`
+	Header  struct {
+		Slots  []aegisSlot  `json:"slots"`
+		Params *aegisParams `json:"params"`
+	} `json:"header"`
+	DB json.RawMessage `json:"db"`
+}
+
+type aegisSlot struct {
+	Type      int         `json:"type"`
+	Key       string      `json:"key"`
+	KeyParams aegisParams `json:"key_params"`
+	N         int         `json:"n"`
+	R         int         `json:"r"`
+	P         int         `json:"p"`
+	Salt      string      `json:"salt"`
+}
+
+type aegisParams struct {
+	Nonce string `json:"nonce"`
+	Tag   string `json:"tag"`
+}
+
+type aegisDB struct {
+	Version int          `json:"version"`
+	Entries []aegisEntry `json:"entries"`
+}
+
+type aegisEntry struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Issuer string `json:"issuer"`
+	Info   struct {
+		Secret  string `json:"secret"`
+		Algo    string `json:"algo"`
+		Digits  int    `json:"digits"`
+		Period  int64  `json:"period"`
+		Counter uint64 `json:"counter"`
+	} `json:"info"`
+}
+
+// importAegis imports a plain or encrypted Aegis vault. Encrypted vaults
+// are opened with the first password slot that the password unlocks.
+func (importer *Importer) importAegis(data []byte, result *Result) error {
+	var vault aegisVault
+	if err := json.Unmarshal(data, &vault); err != nil {
+		return err
+	}
+
+	plain := vault.DB
+	var encoded string
+	if json.Unmarshal(vault.DB, &encoded) == nil {
+		var err error
+		if plain, err = importer.decryptAegis(&vault, encoded); err != nil {
+			return err
+		}
+	}
+
+	var db aegisDB
+	if err := json.Unmarshal(plain, &db); err != nil {
+		return fmt.Errorf("invalid vault: %w", err)
+	}
+	for index, vaultEntry := range db.Entries {
+		importer.addEntry(result, index, entry{
+			otpType:   vaultEntry.Type,
+			issuer:    vaultEntry.Issuer,
+			account:   vaultEntry.Name,
+			base32:    vaultEntry.Info.Secret,
+			algorithm: vaultEntry.Info.Algo,
+			digits:    vaultEntry.Info.Digits,
+			period:    vaultEntry.Info.Period,
+			counter:   vaultEntry.Info.Counter,
+		})
+	}
+
+	return nil
+}
+
+// checkAegisScrypt rejects scrypt parameters beyond the limits, before any
+// memory is allocated for them.
+func checkAegisScrypt(slot aegisSlot) error {
+	if slot.N <= 1 || slot.N > aegisMaxScryptN {
+		return fmt.Errorf("unsupported scrypt cost %d", slot.N)
+	}
+	if slot.R < 1 || slot.R > aegisMaxScryptR {
+		return fmt.Errorf("unsupported scrypt block size %d", slot.R)
+	}
+	if slot.P < 1 || slot.P > aegisMaxScryptP {
+		return fmt.Errorf("unsupported scrypt parallelization %d", slot.P)
+	}
+	if 128*slot.N*slot.R*slot.P > aegisMaxScryptMemory {
+		return fmt.Errorf("scrypt parameters N=%d, r=%d, p=%d exceed the memory limit", slot.N, slot.R, slot.P)
+	}
+	return nil
+}
+
+// decryptAegis decrypts the database of an encrypted vault: scrypt derives
+// a key from the password that unlocks the master key of a slot, which in
+// turn decrypts the database, both with AES-256-GCM.
+func (importer *Importer) decryptAegis(vault *aegisVault, encoded string) ([]byte, error) {
+	if len(importer.Password) == 0 {
+		return nil, ErrPasswordRequired
+	}
+	if vault.Header.Params == nil {
+		return nil, errors.New("encrypted vault without parameters")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault: %w", err)
+	}
+
+	for _, slot := range vault.Header.Slots {
+		if slot.Type != aegisSlotPassword {
+			continue
+		}
+		if err := checkAegisScrypt(slot); err != nil {
+			return nil, err
+		}
+		salt, err := hex.DecodeString(slot.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot salt: %w", err)
+		}
+		wrappedKey, err := hex.DecodeString(slot.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slot key: %w", err)
+		}
+		key, err := scrypt.Key(importer.Password, salt, slot.N, slot.R, slot.P, 32)
+		if err != nil {
+			return nil, err
+		}
+		masterKey, err := openAegis(key, slot.KeyParams, wrappedKey)
+		clear(key)
+		if err != nil {
+			continue
+		}
+
+		plain, err := openAegis(masterKey, *vault.Header.Params, ciphertext)
+		clear(masterKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vault: %w", err)
+		}
+		return plain, nil
+	}
+
+	return nil, ErrWrongPassword
+}
+
+// openAegis decrypts ciphertext with AES-256-GCM and the hex encoded nonce
+// and tag of params.
+func openAegis(key []byte, params aegisParams, ciphertext []byte) ([]byte, error) {
+	nonce, err := hex.DecodeString(params.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := hex.DecodeString(params.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, append(ciphertext[:len(ciphertext):len(ciphertext)], tag...), nil)
+}
@@ -0,0 +1,141 @@
+package importer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const aegisTestDB = `{"version":2,"entries":[
+	{"type":"totp","uuid":"1","name":"alice","issuer":"Example","info":{"secret":"` + testSecret + `","algo":"SHA1","digits":6,"period":30}},
+	{"type":"hotp","uuid":"2","name":"bob","issuer":"Counter","info":{"secret":"` + testSecret + `","algo":"SHA256","digits":8,"counter":12}},
+	{"type":"steam","uuid":"3","name":"carol","issuer":"Steam","info":{"secret":"` + testSecret + `","algo":"SHA1","digits":5,"period":30}},
+	{"type":"yandex","uuid":"4","name":"dave","issuer":"Yandex","info":{"secret":"` + testSecret + `","algo":"SHA256","digits":8,"period":30}}
+]}`
+
+// sealAegis encrypts plaintext like Aegis does and returns the ciphertext
+// and the hex encoded nonce and tag.
+func sealAegis(t *testing.T, key, plaintext []byte) ([]byte, aegisParams) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	nonce[0] = byte(len(plaintext))
+	sealed := gcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext, tag := sealed[:len(plaintext)], sealed[len(plaintext):]
+	return ciphertext, aegisParams{Nonce: hex.EncodeToString(nonce), Tag: hex.EncodeToString(tag)}
+}
+
+// encryptedAegisVault builds an encrypted vault with a single password slot.
+func encryptedAegisVault(t *testing.T, password string) []byte {
+	masterKey := make([]byte, 32)
+	for i := range masterKey {
+		masterKey[i] = byte(i)
+	}
+	salt := make([]byte, 32)
+	n, r, p := 1<<10, 8, 1
+	slotKey, err := scrypt.Key([]byte(password), salt, n, r, p, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrappedKey, keyParams := sealAegis(t, slotKey, masterKey)
+	db, params := sealAegis(t, masterKey, []byte(aegisTestDB))
+
+	vault := map[string]any{
+		"version": 1,
+		"header": map[string]any{
+			"slots": []any{
+				map[string]any{"type": 2, "uuid": "biometric", "key": "00", "key_params": keyParams},
+				map[string]any{
+					"type": aegisSlotPassword, "uuid": "password", "key": hex.EncodeToString(wrappedKey), "key_params": keyParams,
+					"n": n, "r": r, "p": p, "salt": hex.EncodeToString(salt),
+				},
+			},
+			"params": params,
+		},
+		"db": base64.StdEncoding.EncodeToString(db),
+	}
+	data, err := json.Marshal(vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkAegisResult(t *testing.T, result *Result) {
+	t.Helper()
+	if result.Format != FormatAegis {
+		t.Errorf("format %s, want aegis", result.Format)
+	}
+	if len(result.Keys) != 2 || len(result.Warnings) != 2 {
+		t.Fatalf("got %d keys and %d warnings, want 2 and 2", len(result.Keys), len(result.Warnings))
+	}
+	if key := result.Keys[1]; key.Issuer != "Counter" || key.Account != "bob" || key.Counter != 12 || key.Digits != 8 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	for _, warning := range result.Warnings {
+		if !errors.Is(warning.Err, ErrUnsupportedType) {
+			t.Errorf("unexpected warning: %s", warning)
+		}
+	}
+}
+
+func TestImportAegisPlain(t *testing.T) {
+	data := `{"version":1,"header":{"slots":null,"params":null},"db":` + aegisTestDB + `}`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkAegisResult(t, result)
+}
+
+func TestImportAegisEncrypted(t *testing.T) {
+	data := encryptedAegisVault(t, "correct horse")
+
+	importer := NewImporter()
+	importer.Password = []byte("correct horse")
+	result, err := importer.Import(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkAegisResult(t, result)
+
+	if _, err := NewImporter().Import(data); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("without password: got %v, want ErrPasswordRequired", err)
+	}
+	importer.Password = []byte("wrong")
+	if _, err := importer.Import(data); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("wrong password: got %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestImportAegisScryptLimits(t *testing.T) {
+	data := encryptedAegisVault(t, "correct horse")
+
+	for _, params := range []string{`"n":1048576,"p":1,"r":8`, `"n":1024,"p":1,"r":1048576`, `"n":1024,"p":1048576,"r":8`, `"n":1048576,"p":16,"r":32`} {
+		vault := bytes.Replace(data, []byte(`"n":1024,"p":1,"r":8`), []byte(params), 1)
+		if bytes.Equal(vault, data) {
+			t.Fatal("scrypt parameters not found in vault")
+		}
+
+		importer := NewImporter()
+		importer.Password = []byte("correct horse")
+		if _, err := importer.Import(vault); err == nil || errors.Is(err, ErrWrongPassword) {
+			t.Errorf("%s: got %v, want a scrypt parameter error", params, err)
+		}
+	}
+}
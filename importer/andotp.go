@@ -0,0 +1,48 @@
+package importer
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type andOTPEntry struct {
+	Secret    string `json:"secret"`
+	Issuer    string `json:"issuer"`
+	Label     string `json:"label"`
+	Digits    int    `json:"digits"`
+	Type      string `json:"type"`
+	Algorithm string `json:"algorithm"`
+	Period    int64  `json:"period"`
+	Counter   uint64 `json:"counter"`
+}
+
+// importAndOTP imports the plain JSON backup of andOTP. Older versions did
+// not have an issuer field and stored "issuer - account" as label.
+func (importer *Importer) importAndOTP(data []byte, result *Result) error {
+	var entries []andOTPEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	for index, backupEntry := range entries {
+		issuer, account := backupEntry.Issuer, backupEntry.Label
+		if issuer == "" {
+			if before, after, found := strings.Cut(account, " - "); found {
+				issuer, account = before, after
+			}
+		}
+
+		importer.addEntry(result, index, entry{
+			otpType:   backupEntry.Type,
+			issuer:    issuer,
+			account:   account,
+			base32:    backupEntry.Secret,
+			algorithm: backupEntry.Algorithm,
+			digits:    backupEntry.Digits,
+			period:    backupEntry.Period,
+			counter:   backupEntry.Counter,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package importer
+
+import (
+	"encoding/json"
+	"strings"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+type bitwardenExport struct {
+	Encrypted bool            `json:"encrypted"`
+	Items     []bitwardenItem `json:"items"`
+}
+
+type bitwardenItem struct {
+	Name  string `json:"name"`
+	Login *struct {
+		Username string `json:"username"`
+		TOTP     string `json:"totp"`
+	} `json:"login"`
+}
+
+// importBitwarden imports the unencrypted JSON export of a Bitwarden vault.
+// The totp field of a login holds an otpauth:// URL, a steam:// secret or a
+// bare base32 secret. Items without it are ignored.
+func (importer *Importer) importBitwarden(data []byte, result *Result) error {
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return err
+	}
+	if export.Encrypted {
+		return ErrUnsupportedEncryption
+	}
+
+	for index, item := range export.Items {
+		if item.Login == nil || strings.TrimSpace(item.Login.TOTP) == "" {
+			continue
+		}
+		name := item.Name + ":" + item.Login.Username
+
+		description, err := parseOTPValue(item.Login.TOTP)
+		if err == nil {
+			if description.Issuer == "" {
+				description.Issuer = item.Name
+			}
+			if description.Account == "" {
+				description.Account = item.Login.Username
+			}
+		}
+		importer.add(result, index, name, description, err)
+	}
+
+	return nil
+}
+
+// parseOTPValue parses the otp field of a password manager entry: an
+// otpauth:// URL, a steam:// secret, a KeeOtp style key=...&step=... string
+// or a bare base32 secret.
+func parseOTPValue(value string) (tinymfa.KeyDescription, error) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+
+	switch {
+	case strings.HasPrefix(lower, "steam://"):
+		return tinymfa.KeyDescription{}, ErrUnsupportedType
+	case strings.HasPrefix(lower, "otpauth://"):
+		return parseOtpAuthURL(value)
+	case strings.Contains(value, "key="):
+		return parseKeeOtp(value)
+	default:
+		return entry{base32: value}.describe()
+	}
+}
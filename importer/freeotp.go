@@ -0,0 +1,64 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type freeOTPBackup struct {
+	Tokens []freeOTPToken `json:"tokens"`
+}
+
+type freeOTPToken struct {
+	Algo      string `json:"algo"`
+	Counter   uint64 `json:"counter"`
+	Digits    int    `json:"digits"`
+	IssuerExt string `json:"issuerExt"`
+	IssuerInt string `json:"issuerInt"`
+	Label     string `json:"label"`
+	Period    int64  `json:"period"`
+	Secret    []int  `json:"secret"`
+	Type      string `json:"type"`
+}
+
+// importFreeOTPPlus imports the JSON export of FreeOTP+, which stores the
+// secret as an array of signed bytes as serialized by Java.
+func (importer *Importer) importFreeOTPPlus(data []byte, result *Result) error {
+	var backup freeOTPBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return err
+	}
+
+	for index, token := range backup.Tokens {
+		issuer := token.IssuerExt
+		if issuer == "" {
+			issuer = token.IssuerInt
+		}
+		tokenEntry := entry{
+			otpType:   token.Type,
+			issuer:    issuer,
+			account:   token.Label,
+			secret:    make([]byte, len(token.Secret)),
+			algorithm: token.Algo,
+			digits:    token.Digits,
+			period:    token.Period,
+			counter:   token.Counter,
+		}
+
+		valid := len(token.Secret) > 0
+		for i, value := range token.Secret {
+			if value < -128 || value > 255 {
+				valid = false
+			}
+			tokenEntry.secret[i] = byte(value)
+		}
+		if !valid {
+			result.Warnings = append(result.Warnings, Warning{Index: index, Name: tokenEntry.name(), Err: errors.New("invalid secret")})
+			continue
+		}
+
+		importer.addEntry(result, index, tokenEntry)
+	}
+
+	return nil
+}
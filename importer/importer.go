@@ -0,0 +1,291 @@
+// Package importer converts the backups of popular authenticator apps into
+// key descriptions. Entries that cannot be imported, such as Steam or mOTP
+// tokens, are reported as warnings instead of failing the whole backup.
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// Format identifies a backup format.
+type Format uint8
+
+const (
+	// FormatUnknown is returned by DetectFormat for unrecognized data.
+	FormatUnknown Format = iota
+	// FormatAegis is the JSON vault of Aegis Authenticator, plain or encrypted.
+	FormatAegis
+	// FormatAndOTP is the plain JSON backup of andOTP.
+	FormatAndOTP
+	// Format2FAS is the .2fas JSON backup of 2FAS Authenticator.
+	Format2FAS
+	// FormatFreeOTPPlus is the JSON export of FreeOTP+.
+	FormatFreeOTPPlus
+	// FormatBitwarden is the unencrypted JSON export of a Bitwarden vault.
+	FormatBitwarden
+	// FormatKeePassXC is the CSV export of KeePassXC, whose TOTP column holds
+	// the otp attribute of each entry.
+	FormatKeePassXC
+)
+
+// String returns the name of the Format.
+func (format Format) String() string {
+	switch format {
+	case FormatUnknown:
+		return "unknown"
+	case FormatAegis:
+		return "aegis"
+	case FormatAndOTP:
+		return "andotp"
+	case Format2FAS:
+		return "2fas"
+	case FormatFreeOTPPlus:
+		return "freeotp+"
+	case FormatBitwarden:
+		return "bitwarden"
+	case FormatKeePassXC:
+		return "keepassxc"
+	default:
+		return fmt.Sprintf("Format(%d)", uint8(format))
+	}
+}
+
+var (
+	// ErrUnknownFormat is returned when the format of a backup cannot be detected.
+	ErrUnknownFormat = errors.New("unknown backup format")
+
+	// ErrPasswordRequired is returned for encrypted backups when no password is set.
+	ErrPasswordRequired = errors.New("backup is encrypted: password required")
+
+	// ErrWrongPassword is returned when an encrypted backup cannot be decrypted
+	// with the given password.
+	ErrWrongPassword = errors.New("backup could not be decrypted: wrong password")
+
+	// ErrUnsupportedEncryption is returned for encrypted backups whose
+	// encryption is not supported, such as encrypted 2FAS or Bitwarden exports.
+	ErrUnsupportedEncryption = errors.New("backup encryption is not supported")
+
+	// ErrUnsupportedType is reported in warnings for entries of OTP types
+	// other than TOTP and HOTP, such as Steam, mOTP or Yandex.
+	ErrUnsupportedType = errors.New("unsupported otp type")
+)
+
+// Warning reports an entry of a backup that was skipped.
+type Warning struct {
+	// Index is the 0-based position of the entry in the backup.
+	Index int
+	// Name describes the entry, usually as issuer:account.
+	Name string
+	// Err is the reason the entry was skipped.
+	Err error
+}
+
+// String returns a human readable description of the warning.
+func (warning Warning) String() string {
+	return fmt.Sprintf("entry %d (%s): %v", warning.Index, warning.Name, warning.Err)
+}
+
+// Result holds the keys of an imported backup and the entries that were skipped.
+type Result struct {
+	Format   Format
+	Keys     []tinymfa.KeyDescription
+	Warnings []Warning
+}
+
+// Importer imports authenticator backups.
+type Importer struct {
+	// Password decrypts encrypted backups.
+	Password []byte
+	// KeyPolicy is checked for every key; keys that violate it are skipped
+	// with a warning.
+	KeyPolicy tinymfa.KeyPolicy
+}
+
+// NewImporter returns an Importer with the default key policy, which accepts
+// the 10 byte secrets that many apps still use.
+func NewImporter() *Importer {
+	return &Importer{
+		KeyPolicy: tinymfa.DefaultKeyPolicy(),
+	}
+}
+
+// Import detects the format of a backup and imports it.
+func (importer *Importer) Import(data []byte) (*Result, error) {
+	format, err := DetectFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	return importer.ImportFormat(data, format)
+}
+
+// ImportFormat imports a backup of the given format.
+func (importer *Importer) ImportFormat(data []byte, format Format) (*Result, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	result := &Result{Format: format}
+
+	var err error
+	switch format {
+	case FormatAegis:
+		err = importer.importAegis(data, result)
+	case FormatAndOTP:
+		err = importer.importAndOTP(data, result)
+	case Format2FAS:
+		err = importer.import2FAS(data, result)
+	case FormatFreeOTPPlus:
+		err = importer.importFreeOTPPlus(data, result)
+	case FormatBitwarden:
+		err = importer.importBitwarden(data, result)
+	case FormatKeePassXC:
+		err = importer.importKeePassXC(data, result)
+	default:
+		err = ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", format, err)
+	}
+
+	return result, nil
+}
+
+// DetectFormat recognizes a backup by its structure.
+func DetectFormat(data []byte) (Format, error) {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+
+	switch {
+	case bytes.HasPrefix(data, []byte("[")):
+		var entries []map[string]json.RawMessage
+		if json.Unmarshal(data, &entries) == nil && (len(entries) == 0 || hasFields(entries[0], "secret", "type")) {
+			return FormatAndOTP, nil
+		}
+	case bytes.HasPrefix(data, []byte("{")):
+		var document map[string]json.RawMessage
+		if json.Unmarshal(data, &document) != nil {
+			break
+		}
+		switch {
+		case hasFields(document, "header", "db"):
+			return FormatAegis, nil
+		case hasFields(document, "schemaVersion") && (hasFields(document, "services") || hasFields(document, "servicesEncrypted")):
+			return Format2FAS, nil
+		case hasFields(document, "tokens"):
+			return FormatFreeOTPPlus, nil
+		case hasFields(document, "items", "encrypted"):
+			return FormatBitwarden, nil
+		}
+	default:
+		header, _, _ := bytes.Cut(data, []byte("\n"))
+		if bytes.Contains(header, []byte(`"Title"`)) && bytes.Contains(header, []byte(`"TOTP"`)) {
+			return FormatKeePassXC, nil
+		}
+	}
+
+	return FormatUnknown, ErrUnknownFormat
+}
+
+// hasFields reports whether a JSON object has all of the given fields.
+func hasFields(object map[string]json.RawMessage, names ...string) bool {
+	for _, name := range names {
+		if _, found := object[name]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// entry is the common form of the keys of the JSON formats.
+type entry struct {
+	otpType   string
+	issuer    string
+	account   string
+	secret    []byte
+	base32    string
+	algorithm string
+	digits    int
+	period    int64
+	counter   uint64
+}
+
+// name describes the entry in warnings.
+func (entry entry) name() string {
+	if entry.issuer == "" {
+		return entry.account
+	}
+	return entry.issuer + ":" + entry.account
+}
+
+// describe converts the entry into a key description. Missing parameters
+// get the defaults of the Key Uri Format.
+func (entry entry) describe() (tinymfa.KeyDescription, error) {
+	description := tinymfa.KeyDescription{
+		Issuer:    strings.TrimSpace(entry.issuer),
+		Account:   strings.TrimSpace(entry.account),
+		Secret:    entry.secret,
+		Algorithm: tinymfa.SHA1,
+		Digits:    6,
+		Counter:   entry.counter,
+	}
+
+	switch strings.ToLower(entry.otpType) {
+	case "totp", "":
+		description.Type = tinymfa.TOTP
+		description.Period = tinymfa.DefaultTimeStep
+		if entry.period > 0 {
+			description.Period = entry.period
+		}
+	case "hotp":
+		description.Type = tinymfa.HOTP
+	default:
+		return description, fmt.Errorf("%w: %s", ErrUnsupportedType, strings.ToLower(entry.otpType))
+	}
+
+	if entry.algorithm != "" {
+		algorithm, err := tinymfa.ParseHashAlgorithm(entry.algorithm)
+		if err != nil {
+			return description, err
+		}
+		description.Algorithm = algorithm
+	}
+	if entry.digits != 0 {
+		// tokens can only be generated with 5 to 8 digits
+		if entry.digits < 5 || entry.digits > 8 {
+			return description, fmt.Errorf("unsupported digits: %d", entry.digits)
+		}
+		description.Digits = uint8(entry.digits)
+	}
+
+	if description.Secret == nil {
+		secret, err := utils.DecodeBase32Secret(entry.base32)
+		if err != nil {
+			return description, fmt.Errorf("invalid secret: %w", err)
+		}
+		description.Secret = secret
+	}
+
+	return description, nil
+}
+
+// add appends a key to the result, or a warning if it could not be converted
+// or violates the key policy.
+func (importer *Importer) add(result *Result, index int, name string, description tinymfa.KeyDescription, err error) {
+	if err == nil {
+		err = importer.KeyPolicy.Validate(description.Secret, description.Algorithm)
+	}
+	if err != nil {
+		result.Warnings = append(result.Warnings, Warning{Index: index, Name: name, Err: err})
+		return
+	}
+	result.Keys = append(result.Keys, description)
+}
+
+// addEntry converts an entry and adds it to the result.
+func (importer *Importer) addEntry(result *Result, index int, entry entry) {
+	description, err := entry.describe()
+	importer.add(result, index, entry.name(), description, err)
+}
@@ -0,0 +1,264 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// testSecret is the base32 encoded "12345678901234567890" of RFC 4226.
+const testSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var testSecretBytes = []byte("12345678901234567890")
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format Format
+	}{
+		{"aegis", `{"version":1,"header":{"slots":null,"params":null},"db":{"entries":[]}}`, FormatAegis},
+		{"andotp", `[{"secret":"` + testSecret + `","type":"TOTP"}]`, FormatAndOTP},
+		{"andotp empty", `[]`, FormatAndOTP},
+		{"2fas", `{"schemaVersion":4,"services":[]}`, Format2FAS},
+		{"2fas encrypted", `{"schemaVersion":4,"services":[],"servicesEncrypted":"abc"}`, Format2FAS},
+		{"freeotp+", `{"tokens":[],"tokenOrder":[]}`, FormatFreeOTPPlus},
+		{"bitwarden", "\xef\xbb\xbf" + `{"encrypted":false,"items":[]}`, FormatBitwarden},
+		{"keepassxc", "\"Group\",\"Title\",\"Username\",\"Password\",\"URL\",\"Notes\",\"TOTP\"\n", FormatKeePassXC},
+	}
+	for _, test := range tests {
+		format, err := DetectFormat([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if format != test.format {
+			t.Errorf("%s: detected %s, want %s", test.name, format, test.format)
+		}
+	}
+
+	for _, data := range []string{"", "{}", `[{"name":"x"}]`, "not a backup", `{"items":[]}`} {
+		if _, err := DetectFormat([]byte(data)); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("DetectFormat(%q) = %v, want ErrUnknownFormat", data, err)
+		}
+	}
+}
+
+func TestImportAndOTP(t *testing.T) {
+	data := `[
+		{"secret":"` + testSecret + `","issuer":"Example","label":"alice","digits":8,"type":"TOTP","algorithm":"SHA256","period":60},
+		{"secret":"` + testSecret + `","label":"Legacy - bob","digits":6,"type":"HOTP","algorithm":"SHA1","counter":7},
+		{"secret":"` + testSecret + `","issuer":"Steam","label":"carol","digits":5,"type":"STEAM","algorithm":"SHA1","period":30},
+		{"secret":"` + testSecret + `","issuer":"Old","label":"dave","digits":6,"type":"MOTP","algorithm":"MD5","period":10}
+	]`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Format != FormatAndOTP {
+		t.Errorf("format %s, want andotp", result.Format)
+	}
+	if len(result.Keys) != 2 {
+		t.Fatalf("imported %d keys, want 2", len(result.Keys))
+	}
+
+	totp := result.Keys[0]
+	if totp.Type != tinymfa.TOTP || totp.Issuer != "Example" || totp.Account != "alice" || totp.Digits != 8 || totp.Algorithm != tinymfa.SHA256 || totp.Period != 60 {
+		t.Errorf("unexpected totp key: %+v", totp)
+	}
+	if !bytes.Equal(totp.Secret, testSecretBytes) {
+		t.Errorf("unexpected secret %x", totp.Secret)
+	}
+	hotp := result.Keys[1]
+	if hotp.Type != tinymfa.HOTP || hotp.Issuer != "Legacy" || hotp.Account != "bob" || hotp.Counter != 7 {
+		t.Errorf("unexpected hotp key: %+v", hotp)
+	}
+
+	if len(result.Warnings) != 2 {
+		t.Fatalf("got %d warnings, want 2", len(result.Warnings))
+	}
+	for i, warning := range result.Warnings {
+		if !errors.Is(warning.Err, ErrUnsupportedType) {
+			t.Errorf("warning %d: %v, want ErrUnsupportedType", i, warning.Err)
+		}
+	}
+	if warning := result.Warnings[0]; warning.Index != 2 || warning.Name != "Steam:carol" {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+}
+
+func TestImportUnsupportedDigits(t *testing.T) {
+	data := `[
+		{"secret":"` + testSecret + `","issuer":"Example","label":"alice","digits":8,"type":"TOTP","algorithm":"SHA1","period":30},
+		{"secret":"` + testSecret + `","issuer":"Example","label":"bob","digits":4,"type":"TOTP","algorithm":"SHA1","period":30},
+		{"secret":"` + testSecret + `","issuer":"Example","label":"carol","digits":10,"type":"TOTP","algorithm":"SHA1","period":30}
+	]`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Keys) != 1 || result.Keys[0].Account != "alice" {
+		t.Fatalf("unexpected keys: %+v", result.Keys)
+	}
+	if len(result.Warnings) != 2 || result.Warnings[0].Index != 1 || result.Warnings[1].Index != 2 {
+		t.Errorf("unexpected warnings: %v", result.Warnings)
+	}
+}
+
+func TestImport2FAS(t *testing.T) {
+	data := `{"schemaVersion":4,"appVersionCode":5000000,"services":[
+		{"name":"Example","secret":"` + testSecret + `","otp":{"account":"alice","digits":6,"period":30,"algorithm":"SHA1","tokenType":"TOTP"}},
+		{"name":"Counter","secret":"` + testSecret + `","otp":{"account":"bob","issuer":"Counter Inc","digits":6,"algorithm":"SHA512","counter":3,"tokenType":"HOTP"}},
+		{"name":"Steam","secret":"` + testSecret + `","otp":{"account":"carol","digits":5,"period":30,"tokenType":"STEAM"}}
+	]}`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Keys) != 2 || len(result.Warnings) != 1 {
+		t.Fatalf("got %d keys and %d warnings, want 2 and 1", len(result.Keys), len(result.Warnings))
+	}
+	if key := result.Keys[0]; key.Issuer != "Example" || key.Account != "alice" || key.Type != tinymfa.TOTP {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if key := result.Keys[1]; key.Issuer != "Counter Inc" || key.Type != tinymfa.HOTP || key.Counter != 3 || key.Algorithm != tinymfa.SHA512 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+
+	_, err = NewImporter().Import([]byte(`{"schemaVersion":4,"services":[],"servicesEncrypted":"abc:def:ghi"}`))
+	if !errors.Is(err, ErrUnsupportedEncryption) {
+		t.Errorf("encrypted backup: got %v, want ErrUnsupportedEncryption", err)
+	}
+}
+
+func TestImportFreeOTPPlus(t *testing.T) {
+	// the secret as signed bytes, the last one being 0xf0
+	data := `{"tokenOrder":["Example:alice"],"tokens":[
+		{"algo":"SHA1","counter":0,"digits":6,"issuerExt":"Example","label":"alice","period":30,"secret":[49,50,51,52,53,54,55,56,57,48,49,50,51,52,53,54,55,56,57,-16],"type":"TOTP"},
+		{"algo":"SHA1","counter":0,"digits":6,"issuerExt":"Broken","label":"bob","period":30,"secret":[],"type":"TOTP"}
+	]}`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Keys) != 1 || len(result.Warnings) != 1 {
+		t.Fatalf("got %d keys and %d warnings, want 1 and 1", len(result.Keys), len(result.Warnings))
+	}
+	expected := append([]byte("1234567890123456789"), 0xf0)
+	if key := result.Keys[0]; !bytes.Equal(key.Secret, expected) || key.Issuer != "Example" || key.Account != "alice" {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if result.Warnings[0].Name != "Broken:bob" {
+		t.Errorf("unexpected warning: %s", result.Warnings[0])
+	}
+}
+
+func TestImportBitwarden(t *testing.T) {
+	data := `{"encrypted":false,"folders":[],"items":[
+		{"type":1,"name":"Example","login":{"username":"alice","password":"x","totp":"otpauth://totp/Example:alice?secret=` + testSecret + `&issuer=Example&digits=8"}},
+		{"type":1,"name":"Plain","login":{"username":"bob","totp":"` + testSecret + `"}},
+		{"type":1,"name":"Steam","login":{"username":"carol","totp":"steam://` + testSecret + `"}},
+		{"type":1,"name":"No OTP","login":{"username":"dave","password":"x","totp":null}},
+		{"type":2,"name":"Note"}
+	]}`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Keys) != 2 || len(result.Warnings) != 1 {
+		t.Fatalf("got %d keys and %d warnings, want 2 and 1", len(result.Keys), len(result.Warnings))
+	}
+	if key := result.Keys[0]; key.Issuer != "Example" || key.Account != "alice" || key.Digits != 8 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if key := result.Keys[1]; key.Issuer != "Plain" || key.Account != "bob" || key.Type != tinymfa.TOTP || !bytes.Equal(key.Secret, testSecretBytes) {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if warning := result.Warnings[0]; !errors.Is(warning.Err, ErrUnsupportedType) || warning.Index != 2 {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+
+	_, err = NewImporter().Import([]byte(`{"encrypted":true,"encKeyValidation_DO_NOT_EDIT":"x","items":[]}`))
+	if !errors.Is(err, ErrUnsupportedEncryption) {
+		t.Errorf("encrypted export: got %v, want ErrUnsupportedEncryption", err)
+	}
+}
+
+func TestImportKeePassXC(t *testing.T) {
+	data := "\"Group\",\"Title\",\"Username\",\"Password\",\"URL\",\"Notes\",\"TOTP\",\"Icon\",\"Last Modified\",\"Created\"\n" +
+		"\"Root\",\"Example\",\"alice\",\"x\",\"\",\"\",\"otpauth://totp/Example:alice?secret=" + testSecret + "&period=30&digits=6&issuer=Example\",\"0\",\"\",\"\"\n" +
+		"\"Root\",\"Legacy\",\"bob\",\"x\",\"\",\"\",\"key=" + testSecret + "&step=60&size=8&otpHashMode=SHA256\",\"0\",\"\",\"\"\n" +
+		"\"Root\",\"Steam\",\"carol\",\"x\",\"\",\"\",\"otpauth://totp/Steam:carol?secret=" + testSecret + "&period=30&digits=5&issuer=Steam&encoder=steam\",\"0\",\"\",\"\"\n" +
+		"\"Root\",\"No OTP\",\"dave\",\"x\",\"\",\"\",\"\",\"0\",\"\",\"\"\n"
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Format != FormatKeePassXC {
+		t.Errorf("format %s, want keepassxc", result.Format)
+	}
+	if len(result.Keys) != 2 || len(result.Warnings) != 1 {
+		t.Fatalf("got %d keys and %d warnings, want 2 and 1", len(result.Keys), len(result.Warnings))
+	}
+	if key := result.Keys[0]; key.Issuer != "Example" || key.Account != "alice" || key.Period != 30 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if key := result.Keys[1]; key.Issuer != "Legacy" || key.Account != "bob" || key.Period != 60 || key.Digits != 8 || key.Algorithm != tinymfa.SHA256 {
+		t.Errorf("unexpected key: %+v", key)
+	}
+	if warning := result.Warnings[0]; !errors.Is(warning.Err, ErrUnsupportedType) || warning.Name != "Steam:carol" {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+}
+
+func TestParseKeePassXCOTP(t *testing.T) {
+	if _, err := ParseKeePassXCOTP("key=" + testSecret + "&size=S"); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("steam size: got %v, want ErrUnsupportedType", err)
+	}
+	if _, err := ParseKeePassXCOTP("key=" + testSecret + "&step=-1"); err == nil {
+		t.Error("expected error for negative step")
+	}
+	if _, err := ParseKeePassXCOTP("key=" + testSecret + "&encoding=hex"); err == nil {
+		t.Error("expected error for hex encoding")
+	}
+	if _, err := ParseKeePassXCOTP("key=" + testSecret + "&otpHashMode=MD5"); err == nil {
+		t.Error("expected error for unsupported hash")
+	}
+}
+
+func TestImportKeyPolicy(t *testing.T) {
+	short := utils.EncodeBase32Secret([]byte("12345678"), false)
+	data := `[{"secret":"` + short + `","issuer":"Short","label":"alice","type":"TOTP"}]`
+
+	result, err := NewImporter().Import([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Keys) != 0 || len(result.Warnings) != 1 || !errors.Is(result.Warnings[0].Err, tinymfa.ErrKeyTooShort) {
+		t.Errorf("expected a key policy warning, got %+v", result)
+	}
+
+	importer := NewImporter()
+	importer.KeyPolicy = tinymfa.KeyPolicy{MinLength: 8}
+	if result, err = importer.Import([]byte(data)); err != nil || len(result.Keys) != 1 {
+		t.Errorf("relaxed policy: got %v, %+v", err, result)
+	}
+}
+
+func TestImportFormatMismatch(t *testing.T) {
+	if _, err := NewImporter().ImportFormat([]byte("{}"), FormatUnknown); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("got %v, want ErrUnknownFormat", err)
+	}
+	if _, err := NewImporter().ImportFormat([]byte("{}"), FormatAndOTP); err == nil {
+		t.Error("expected error importing an object as andOTP")
+	}
+}
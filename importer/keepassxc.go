@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+// importKeePassXC imports the CSV export of KeePassXC. Its TOTP column holds
+// the otp attribute of each entry, which is usually an otpauth:// URL.
+// Entries without it are ignored.
+func (importer *Importer) importKeePassXC(data []byte, result *Result) error {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("empty csv export")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	otpColumn, found := columns["TOTP"]
+	if !found {
+		return errors.New("csv export has no TOTP column")
+	}
+	field := func(record []string, name string) string {
+		if i, found := columns[name]; found && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	for index, record := range records[1:] {
+		value := field(record, "TOTP")
+		if otpColumn >= len(record) || strings.TrimSpace(value) == "" {
+			continue
+		}
+		title, username := field(record, "Title"), field(record, "Username")
+
+		description, err := ParseKeePassXCOTP(value)
+		if err == nil {
+			if description.Issuer == "" {
+				description.Issuer = title
+			}
+			if description.Account == "" {
+				description.Account = username
+			}
+		}
+		importer.add(result, index, title+":"+username, description, err)
+	}
+
+	return nil
+}
+
+// ParseKeePassXCOTP parses the otp attribute of a KeePassXC entry: an
+// otpauth:// URL, or the key=...&step=...&size=... form of the KeeOtp
+// plugin that older entries use. Steam tokens are reported as
+// ErrUnsupportedType.
+func ParseKeePassXCOTP(value string) (tinymfa.KeyDescription, error) {
+	return parseOTPValue(value)
+}
+
+// parseOtpAuthURL parses an otpauth:// URL without applying a key policy,
+// which is left to the Importer. Steam tokens are marked by an encoder
+// parameter.
+func parseOtpAuthURL(value string) (tinymfa.KeyDescription, error) {
+	if parsed, err := url.Parse(value); err == nil && strings.EqualFold(parsed.Query().Get("encoder"), "steam") {
+		return tinymfa.KeyDescription{}, fmt.Errorf("%w: steam", ErrUnsupportedType)
+	}
+
+	parser := tinymfa.NewTinyMfa()
+	parser.SetKeyPolicy(tinymfa.KeyPolicy{})
+	descriptions, err := parser.ParsePayload(value)
+	if err != nil {
+		return tinymfa.KeyDescription{}, err
+	}
+	if len(descriptions) != 1 {
+		return tinymfa.KeyDescription{}, errors.New("expected a single otpauth:// url")
+	}
+	return descriptions[0], nil
+}
+
+// parseKeeOtp parses the key=...&step=...&size=...&otpHashMode=... form.
+func parseKeeOtp(value string) (tinymfa.KeyDescription, error) {
+	query, err := url.ParseQuery(value)
+	if err != nil {
+		return tinymfa.KeyDescription{}, err
+	}
+
+	keeOtpEntry := entry{
+		base32:    query.Get("key"),
+		algorithm: query.Get("otpHashMode"),
+	}
+	if step := query.Get("step"); step != "" {
+		if keeOtpEntry.period, err = strconv.ParseInt(step, 10, 64); err != nil || keeOtpEntry.period <= 0 {
+			return tinymfa.KeyDescription{}, fmt.Errorf("invalid step: %s", step)
+		}
+	}
+	if size := query.Get("size"); size != "" {
+		if strings.EqualFold(size, "S") {
+			return tinymfa.KeyDescription{}, fmt.Errorf("%w: steam", ErrUnsupportedType)
+		}
+		if keeOtpEntry.digits, err = strconv.Atoi(size); err != nil {
+			return tinymfa.KeyDescription{}, fmt.Errorf("invalid size: %s", size)
+		}
+	}
+	if encoding := query.Get("encoding"); encoding != "" && !strings.EqualFold(encoding, "base32") {
+		return tinymfa.KeyDescription{}, fmt.Errorf("unsupported key encoding: %s", encoding)
+	}
+
+	return keeOtpEntry.describe()
+}
@@ -0,0 +1,57 @@
+package importer
+
+import (
+	"encoding/json"
+)
+
+type twoFASBackup struct {
+	SchemaVersion     int             `json:"schemaVersion"`
+	Services          []twoFASService `json:"services"`
+	ServicesEncrypted string          `json:"servicesEncrypted"`
+}
+
+type twoFASService struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+	OTP    struct {
+		Account   string `json:"account"`
+		Issuer    string `json:"issuer"`
+		Digits    int    `json:"digits"`
+		Period    int64  `json:"period"`
+		Algorithm string `json:"algorithm"`
+		Counter   uint64 `json:"counter"`
+		TokenType string `json:"tokenType"`
+	} `json:"otp"`
+}
+
+// import2FAS imports an unencrypted .2fas backup. The issuer falls back to
+// the service name, which 2FAS shows in its list.
+func (importer *Importer) import2FAS(data []byte, result *Result) error {
+	var backup twoFASBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return err
+	}
+	if backup.ServicesEncrypted != "" {
+		return ErrUnsupportedEncryption
+	}
+
+	for index, service := range backup.Services {
+		issuer := service.OTP.Issuer
+		if issuer == "" {
+			issuer = service.Name
+		}
+
+		importer.addEntry(result, index, entry{
+			otpType:   service.OTP.TokenType,
+			issuer:    issuer,
+			account:   service.OTP.Account,
+			base32:    service.Secret,
+			algorithm: service.OTP.Algorithm,
+			digits:    service.OTP.Digits,
+			period:    service.OTP.Period,
+			counter:   service.OTP.Counter,
+		})
+	}
+
+	return nil
+}
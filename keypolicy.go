@@ -0,0 +1,139 @@
+package tinymfa
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrKeyTooShort is returned when a secret key is shorter than the policy allows.
+	ErrKeyTooShort = errors.New("secret key is too short")
+
+	// ErrKeyAlgorithmMismatch is returned when a secret key is shorter than the
+	// recommended size of the hash algorithm it is used with.
+	ErrKeyAlgorithmMismatch = errors.New("secret key size does not match hash algorithm")
+
+	// ErrLowEntropyKey is returned when a secret key is obviously not random,
+	// for example when all bytes are equal or follow a simple pattern.
+	ErrLowEntropyKey = errors.New("secret key has low entropy")
+)
+
+// KeyPolicy describes which secret keys are acceptable. It is enforced when
+// keys are generated and when keys are imported from otpauth:// payloads.
+type KeyPolicy struct {
+	// MinLength is the minimum key length in bytes.
+	MinLength int
+	// RequireAlgorithmKeySize rejects keys that are shorter than the size
+	// recommended for their hash algorithm by RFC 6238 Section 4.
+	RequireAlgorithmKeySize bool
+	// RejectLowEntropy rejects keys that consist of repeated bytes, repeated
+	// patterns, simple sequences or only a few distinct byte values.
+	RejectLowEntropy bool
+}
+
+// DefaultKeyPolicy returns the policy used by NewTinyMfa. It accepts the
+// 10 byte secrets still found in many legacy authenticator exports.
+func DefaultKeyPolicy() KeyPolicy {
+	return KeyPolicy{MinLength: 10}
+}
+
+// StrictKeyPolicy returns a policy that requires at least 128 bit keys as
+// demanded by RFC 4226 Section 4, keys matching their hash algorithm and
+// rejects keys with obviously low entropy.
+func StrictKeyPolicy() KeyPolicy {
+	return KeyPolicy{
+		MinLength:               16,
+		RequireAlgorithmKeySize: true,
+		RejectLowEntropy:        true,
+	}
+}
+
+// ValidateKey checks the length and entropy of a key without considering
+// the hash algorithm it is used with.
+func (policy KeyPolicy) ValidateKey(key []byte) error {
+	if len(key) == 0 || len(key) < policy.MinLength {
+		return fmt.Errorf("%w: %d bytes (minimum: %d)", ErrKeyTooShort, len(key), policy.MinLength)
+	}
+	if policy.RejectLowEntropy && isLowEntropy(key) {
+		return ErrLowEntropyKey
+	}
+	return nil
+}
+
+// Validate checks a key that is used with the given hash algorithm.
+func (policy KeyPolicy) Validate(key []byte, algorithm HashAlgorithm) error {
+	if err := policy.ValidateKey(key); err != nil {
+		return err
+	}
+	if !policy.RequireAlgorithmKeySize {
+		return nil
+	}
+
+	size, err := keySizeForAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	if len(key) < int(size) {
+		return fmt.Errorf("%w: %d bytes for %s (recommended: %d)", ErrKeyAlgorithmMismatch, len(key), algorithm, size)
+	}
+	return nil
+}
+
+// keySizeForAlgorithm returns the recommended key size of a hash algorithm.
+func keySizeForAlgorithm(algorithm HashAlgorithm) (int8, error) {
+	switch algorithm {
+	case SHA1:
+		return KeySizeSHA1, nil
+	case SHA256:
+		return KeySizeSHA256, nil
+	case SHA512:
+		return KeySizeSHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported hash algorithm: %d", algorithm)
+	}
+}
+
+// isLowEntropy detects keys that are clearly not the output of a random
+// number generator. Random keys of 10 bytes or more practically never
+// trigger any of these checks.
+func isLowEntropy(key []byte) bool {
+	// a pattern that repeats at least twice, including a single repeated byte
+	for period := 1; period <= len(key)/2; period++ {
+		repeating := true
+		for index := period; index < len(key); index++ {
+			if key[index] != key[index-period] {
+				repeating = false
+				break
+			}
+		}
+		if repeating {
+			return true
+		}
+	}
+
+	// an arithmetic sequence such as 0x00, 0x01, 0x02, ...
+	if len(key) > 2 {
+		sequence := true
+		step := key[1] - key[0]
+		for index := 2; index < len(key); index++ {
+			if key[index]-key[index-1] != step {
+				sequence = false
+				break
+			}
+		}
+		if sequence {
+			return true
+		}
+	}
+
+	// only a few distinct byte values, such as ASCII digits
+	var seen [256]bool
+	distinct := 0
+	for _, value := range key {
+		if !seen[value] {
+			seen[value] = true
+			distinct++
+		}
+	}
+	return distinct < len(key)/2
+}
@@ -0,0 +1,112 @@
+package tinymfa_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+func TestSetEntropySourceDeterministic(t *testing.T) {
+	first := tinymfa.NewTinyMfa()
+	first.SetEntropySource(rand.New(rand.NewSource(42)))
+	second := tinymfa.NewTinyMfa()
+	second.SetEntropySource(rand.New(rand.NewSource(42)))
+
+	key1, err := first.GenerateSecretKeyForAlgorithm(tinymfa.SHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key2, err := second.GenerateSecretKeyForAlgorithm(tinymfa.SHA256)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(*key1, *key2) {
+		t.Error("expected the same seed to yield the same key")
+	}
+
+	first.SetEntropySource(strings.NewReader("too short"))
+	if _, err := first.GenerateStandardSecretKey(); err == nil {
+		t.Error("expected error for exhausted entropy source")
+	}
+
+	first.SetEntropySource(nil)
+	if _, err := first.GenerateStandardSecretKey(); err != nil {
+		t.Errorf("expected crypto/rand fallback, got %v", err)
+	}
+}
+
+func TestGenerateSecretKeyRejectsLowEntropy(t *testing.T) {
+	strict := tinymfa.NewTinyMfa()
+	strict.SetKeyPolicy(tinymfa.StrictKeyPolicy())
+	strict.SetEntropySource(bytes.NewReader(make([]byte, 64)))
+
+	if _, err := strict.GenerateSecretKeyForAlgorithm(tinymfa.SHA1); !errors.Is(err, tinymfa.ErrLowEntropyKey) {
+		t.Errorf("expected ErrLowEntropyKey, got %v", err)
+	}
+}
+
+func TestKeyPolicyValidate(t *testing.T) {
+	strict := tinymfa.StrictKeyPolicy()
+	random := []byte{
+		0x3a, 0x91, 0x0c, 0xe7, 0x55, 0x28, 0xbf, 0x64, 0x1d, 0xf2,
+		0x87, 0x4e, 0xa3, 0x16, 0xd9, 0x70, 0x2b, 0xc5, 0x98, 0x0f,
+		0x61, 0xee, 0x34, 0xab, 0x7d, 0x02, 0xb6, 0x49, 0xfa, 0x13,
+		0x8c, 0x57,
+	}
+
+	tests := []struct {
+		name      string
+		policy    tinymfa.KeyPolicy
+		key       []byte
+		algorithm tinymfa.HashAlgorithm
+		expected  error
+	}{
+		{"random SHA1 key", strict, random[:20], tinymfa.SHA1, nil},
+		{"random SHA256 key", strict, random, tinymfa.SHA256, nil},
+		{"legacy 10 byte key", tinymfa.DefaultKeyPolicy(), random[:10], tinymfa.SHA1, nil},
+		{"legacy 10 byte key strict", strict, random[:10], tinymfa.SHA1, tinymfa.ErrKeyTooShort},
+		{"empty key", tinymfa.DefaultKeyPolicy(), nil, tinymfa.SHA1, tinymfa.ErrKeyTooShort},
+		{"SHA1 sized key for SHA512", strict, random[:20], tinymfa.SHA512, tinymfa.ErrKeyAlgorithmMismatch},
+		{"repeated byte", strict, bytes.Repeat([]byte{0x41}, 20), tinymfa.SHA1, tinymfa.ErrLowEntropyKey},
+		{"repeated pattern", strict, []byte("1234567890123456789012345678901234567890"), tinymfa.SHA1, tinymfa.ErrLowEntropyKey},
+		{"sequence", strict, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19}, tinymfa.SHA1, tinymfa.ErrLowEntropyKey},
+		{"few distinct bytes", strict, []byte("01100101011101001011"), tinymfa.SHA1, tinymfa.ErrLowEntropyKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.key, tt.algorithm)
+			if tt.expected == nil && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestParsePayloadEnforcesKeyPolicy(t *testing.T) {
+	// JBSWY3DPEHPK3PXP decodes to a 10 byte secret
+	payload := "otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP"
+
+	permissive := tinymfa.NewTinyMfa()
+	if _, err := permissive.ParsePayload(payload); err != nil {
+		t.Fatalf("expected default policy to accept legacy key, got %v", err)
+	}
+
+	strict := tinymfa.NewTinyMfa()
+	strict.SetKeyPolicy(tinymfa.StrictKeyPolicy())
+	if _, err := strict.ParsePayload(payload); !errors.Is(err, tinymfa.ErrKeyTooShort) {
+		t.Errorf("expected ErrKeyTooShort, got %v", err)
+	}
+
+	key := []byte("short")
+	if err := strict.ValidateSecretKey(&key, tinymfa.SHA1); !errors.Is(err, tinymfa.ErrKeyTooShort) {
+		t.Errorf("expected ErrKeyTooShort, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package kms
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// FileKMS is a KMS whose master keys are kept in a local JSON file. Data keys
+// are wrapped with AES Key Wrap (RFC 3394). It suits development and single
+// host setups; the key file must be protected like any other secret.
+type FileKMS struct {
+	mutex    sync.RWMutex
+	filePath string
+	keys     map[string][]byte
+}
+
+// fileKMSDocument is the JSON document of a FileKMS key file.
+type fileKMSDocument struct {
+	Keys map[string][]byte `json:"keys"`
+}
+
+// NewFileKMS opens the key file at filePath. A missing file is created once
+// the first key is added with CreateKey.
+func NewFileKMS(filePath string) (*FileKMS, error) {
+	service := &FileKMS{filePath: filePath, keys: make(map[string][]byte)}
+
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return service, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	var document fileKMSDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid kms key file: %w", err)
+	}
+	for id, key := range document.Keys {
+		if len(key) != DataKeySize {
+			return nil, fmt.Errorf("kms key %s: %w", id, utils.ErrInvalidKeySize)
+		}
+		service.keys[id] = key
+	}
+
+	return service, nil
+}
+
+// CreateKey generates a new random master key and writes the key file
+// atomically with 0600 permissions.
+func (service *FileKMS) CreateKey(keyID string) error {
+	if keyID == "" {
+		return errors.New("kms key id must not be empty")
+	}
+
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	if _, found := service.keys[keyID]; found {
+		return fmt.Errorf("kms key %s already exists", keyID)
+	}
+	key := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+
+	service.keys[keyID] = key
+	data, err := json.Marshal(fileKMSDocument{Keys: service.keys})
+	if err != nil {
+		delete(service.keys, keyID)
+		return err
+	}
+	defer clear(data)
+
+	if err := utils.WriteFileAtomic(service.filePath, data, 0600); err != nil {
+		delete(service.keys, keyID)
+		return err
+	}
+	return nil
+}
+
+// GenerateDataKey implements KMS.
+func (service *FileKMS) GenerateDataKey(ctx context.Context, keyID string) (*DataKey, error) {
+	master, err := service.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		return nil, err
+	}
+	ciphertext, err := utils.WrapKey(master, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataKey{KeyID: keyID, Plaintext: plaintext, Ciphertext: ciphertext}, nil
+}
+
+// Decrypt implements KMS.
+func (service *FileKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	master, err := service.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return utils.UnwrapKey(master, ciphertext)
+}
+
+// key returns the master key keyID.
+func (service *FileKMS) key(keyID string) ([]byte, error) {
+	service.mutex.RLock()
+	defer service.mutex.RUnlock()
+
+	master, found := service.keys[keyID]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+	}
+	return master, nil
+}
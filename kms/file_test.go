@@ -0,0 +1,80 @@
+package kms_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/kms"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestFileKMS(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "kms.json")
+	service, err := kms.NewFileKMS(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := service.CreateKey("master"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := service.CreateKey("master"); err == nil {
+		t.Errorf("expected error for an existing key")
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	dataKey, err := service.GenerateDataKey(ctx, "master")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dataKey.KeyID != "master" || len(dataKey.Plaintext) != kms.DataKeySize {
+		t.Errorf("unexpected data key %+v", dataKey)
+	}
+
+	// the key file is reloaded
+	reopened, err := kms.NewFileKMS(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unwrapped, err := reopened.Decrypt(ctx, "master", dataKey.Ciphertext)
+	if err != nil || !bytes.Equal(unwrapped, dataKey.Plaintext) {
+		t.Errorf("expected data key to unwrap, got %v", err)
+	}
+
+	dataKey.Ciphertext[0] ^= 1
+	if _, err := reopened.Decrypt(ctx, "master", dataKey.Ciphertext); !errors.Is(err, utils.ErrKeyUnwrap) {
+		t.Errorf("expected ErrKeyUnwrap, got %v", err)
+	}
+	if _, err := reopened.GenerateDataKey(ctx, "unknown"); !errors.Is(err, kms.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestFileKMSInvalidFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "kms.json")
+
+	if err := os.WriteFile(filePath, []byte("not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kms.NewFileKMS(filePath); err == nil {
+		t.Errorf("expected error for an invalid key file")
+	}
+
+	if err := os.WriteFile(filePath, []byte(`{"keys":{"short":"AAAA"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kms.NewFileKMS(filePath); !errors.Is(err, utils.ErrInvalidKeySize) {
+		t.Errorf("expected ErrInvalidKeySize, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Package kms implements envelope encryption with data keys that are wrapped
+// by a key management service, so that the master keys never leave it.
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// DataKeySize is the size of the AES-256 data keys generated by a KMS.
+const DataKeySize = 32
+
+var (
+	// ErrKeyNotFound is returned when a KMS does not know the requested key.
+	ErrKeyNotFound = errors.New("kms key not found")
+
+	// ErrNotKMSEnvelope is returned when data is decrypted that was not
+	// encrypted with a KMS data key.
+	ErrNotKMSEnvelope = errors.New("envelope was not encrypted with a kms data key")
+)
+
+// DataKey is a fresh data key together with its wrapped form. Plaintext is
+// used for a single encryption and then discarded; Ciphertext is stored
+// alongside the data and can only be unwrapped by the KMS.
+type DataKey struct {
+	KeyID      string
+	Plaintext  []byte
+	Ciphertext []byte
+}
+
+// KMS is a key management service that holds master keys and wraps and
+// unwraps data keys with them.
+type KMS interface {
+	// GenerateDataKey returns a new data key, wrapped with the master key keyID.
+	GenerateDataKey(ctx context.Context, keyID string) (*DataKey, error)
+	// Decrypt unwraps a data key that was wrapped with the master key keyID.
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+}
+
+// Encrypter encrypts data into utils.Envelope containers with a new data key
+// per encryption. The envelope records the id of the KMS key and the wrapped
+// data key, so that it can be decrypted without further bookkeeping.
+type Encrypter struct {
+	service KMS
+	keyID   string
+}
+
+// NewEncrypter returns an Encrypter that wraps data keys with the master key
+// keyID of service.
+func NewEncrypter(service KMS, keyID string) (*Encrypter, error) {
+	if keyID == "" || len(keyID) > 255 {
+		return nil, fmt.Errorf("kms key id must have 1 to 255 bytes, got %d", len(keyID))
+	}
+	return &Encrypter{service: service, keyID: keyID}, nil
+}
+
+// KeyID returns the id of the master key that new data keys are wrapped with.
+func (encrypter *Encrypter) KeyID() string {
+	return encrypter.keyID
+}
+
+// Encrypt encrypts data with a new data key.
+func (encrypter *Encrypter) Encrypt(ctx context.Context, data []byte) ([]byte, error) {
+	return encrypter.EncryptWithAssociatedData(ctx, data, nil)
+}
+
+// EncryptWithAssociatedData works like Encrypt, but binds the ciphertext to
+// the associated data, which has to be passed again on decryption.
+func (encrypter *Encrypter) EncryptWithAssociatedData(ctx context.Context, data, associatedData []byte) ([]byte, error) {
+	dataKey, err := encrypter.service.GenerateDataKey(ctx, encrypter.keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey.Plaintext)
+
+	envelope := &utils.Envelope{
+		Version:    utils.EnvelopeVersion,
+		Suite:      utils.SuiteAESGCM,
+		KDF:        utils.KDFKMS,
+		KMSKeyID:   dataKey.KeyID,
+		WrappedKey: dataKey.Ciphertext,
+	}
+	return envelope.Seal(data, dataKey.Plaintext, associatedData)
+}
+
+// Decrypt unwraps the data key of an envelope with the KMS key recorded in
+// it and decrypts the data. Envelopes of other master keys of the same KMS
+// are decrypted as well, so that data stays readable after the key of the
+// Encrypter was changed.
+func (encrypter *Encrypter) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	return encrypter.DecryptWithAssociatedData(ctx, data, nil)
+}
+
+// DecryptWithAssociatedData decrypts data that was encrypted with
+// EncryptWithAssociatedData and the same associated data.
+func (encrypter *Encrypter) DecryptWithAssociatedData(ctx context.Context, data, associatedData []byte) ([]byte, error) {
+	envelope, err := utils.ParseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.KDF != utils.KDFKMS {
+		return nil, ErrNotKMSEnvelope
+	}
+
+	key, err := encrypter.service.Decrypt(ctx, envelope.KMSKeyID, envelope.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+
+	return envelope.Open(key, associatedData)
+}
+
+// KeyIDOf returns the id of the KMS key that wrapped the data key of an
+// envelope, for example to find data that should be rewrapped.
+func KeyIDOf(data []byte) (string, error) {
+	envelope, err := utils.ParseEnvelope(data)
+	if err != nil {
+		return "", err
+	}
+	if envelope.KDF != utils.KDFKMS {
+		return "", ErrNotKMSEnvelope
+	}
+	return envelope.KMSKeyID, nil
+}
@@ -0,0 +1,115 @@
+package kms_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/kms"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func newFileKMS(t *testing.T, keyIDs ...string) *kms.FileKMS {
+	t.Helper()
+	service, err := kms.NewFileKMS(filepath.Join(t.TempDir(), "kms.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, keyID := range keyIDs {
+		if err := service.CreateKey(keyID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return service
+}
+
+func TestEncrypter(t *testing.T) {
+	ctx := context.Background()
+	service := newFileKMS(t, "master-2024", "master-2025")
+	plaintext := []byte("secret seed material")
+
+	old, _ := kms.NewEncrypter(service, "master-2024")
+	encrypted, err := old.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(encrypted, plaintext) {
+		t.Errorf("expected data to be encrypted")
+	}
+
+	envelope, err := utils.ParseEnvelope(encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if envelope.KDF != utils.KDFKMS || envelope.KMSKeyID != "master-2024" || len(envelope.WrappedKey) != kms.DataKeySize+8 {
+		t.Errorf("expected the envelope to record the kms key, got %+v", envelope)
+	}
+	if keyID, err := kms.KeyIDOf(encrypted); err != nil || keyID != "master-2024" {
+		t.Errorf("expected master-2024, got %q, %v", keyID, err)
+	}
+
+	// the key recorded in the envelope is used, not the one of the encrypter
+	current, _ := kms.NewEncrypter(service, "master-2025")
+	decrypted, err := current.Decrypt(ctx, encrypted)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %q, got %q, %v", plaintext, decrypted, err)
+	}
+
+	// every encryption uses a new data key
+	again, _ := old.Encrypt(ctx, plaintext)
+	second, _ := utils.ParseEnvelope(again)
+	if bytes.Equal(envelope.WrappedKey, second.WrappedKey) {
+		t.Errorf("expected a new data key per encryption")
+	}
+}
+
+func TestEncrypterAssociatedData(t *testing.T) {
+	ctx := context.Background()
+	encrypter, _ := kms.NewEncrypter(newFileKMS(t, "master"), "master")
+
+	encrypted, err := encrypter.EncryptWithAssociatedData(ctx, []byte("seed"), []byte("alice"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := encrypter.DecryptWithAssociatedData(ctx, encrypted, []byte("bob")); !errors.Is(err, utils.ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+	decrypted, err := encrypter.DecryptWithAssociatedData(ctx, encrypted, []byte("alice"))
+	if err != nil || string(decrypted) != "seed" {
+		t.Errorf("expected seed, got %q, %v", decrypted, err)
+	}
+}
+
+func TestEncrypterRejectsOtherData(t *testing.T) {
+	ctx := context.Background()
+	encrypter, _ := kms.NewEncrypter(newFileKMS(t, "master"), "master")
+
+	key := bytes.Repeat([]byte{1}, 32)
+	plain := []byte("seed")
+	encrypted, _ := utils.NewTinyMfaUtil().Encrypt(&plain, &key)
+	if _, err := encrypter.Decrypt(ctx, *encrypted); !errors.Is(err, kms.ErrNotKMSEnvelope) {
+		t.Errorf("expected ErrNotKMSEnvelope, got %v", err)
+	}
+	if _, err := encrypter.Decrypt(ctx, []byte("garbage")); !errors.Is(err, utils.ErrNoEnvelope) {
+		t.Errorf("expected ErrNoEnvelope, got %v", err)
+	}
+
+	// KMS envelopes cannot be opened with a raw key
+	sealed, _ := encrypter.Encrypt(ctx, []byte("seed"))
+	if _, err := utils.NewTinyMfaUtil().Decrypt(&sealed, &key); !errors.Is(err, utils.ErrKMSEnvelope) {
+		t.Errorf("expected ErrKMSEnvelope for raw key decryption, got %v", err)
+	}
+	if _, err := utils.NewTinyMfaUtil().DecryptWithPassphrase(&sealed, &plain); !errors.Is(err, utils.ErrKMSEnvelope) {
+		t.Errorf("expected ErrKMSEnvelope for passphrase decryption, got %v", err)
+	}
+	keyring, _ := utils.NewKeyring("raw", key)
+	if _, err := keyring.Decrypt(&sealed); !errors.Is(err, utils.ErrKMSEnvelope) {
+		t.Errorf("expected ErrKMSEnvelope for keyring decryption, got %v", err)
+	}
+
+	if _, err := kms.NewEncrypter(newFileKMS(t), ""); err == nil {
+		t.Errorf("expected error for an empty key id")
+	}
+}
@@ -0,0 +1,160 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultVaultMount is the default mount path of the Vault transit engine.
+const DefaultVaultMount = "transit"
+
+// maxVaultResponseSize limits the size of Vault responses that are read.
+const maxVaultResponseSize = 1 << 20
+
+// VaultError is returned when the Vault API answers with an error status.
+type VaultError struct {
+	StatusCode int
+	Errors     []string
+}
+
+// Error implements the error interface.
+func (err *VaultError) Error() string {
+	if len(err.Errors) == 0 {
+		return fmt.Sprintf("vault responded with status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("vault responded with status %d: %s", err.StatusCode, strings.Join(err.Errors, "; "))
+}
+
+// Unwrap returns ErrKeyNotFound if Vault reported an unknown key.
+func (err *VaultError) Unwrap() error {
+	if err.StatusCode == http.StatusNotFound {
+		return ErrKeyNotFound
+	}
+	for _, message := range err.Errors {
+		if strings.Contains(message, "not found") {
+			return ErrKeyNotFound
+		}
+	}
+	return nil
+}
+
+// VaultKMS is a KMS backed by the transit secrets engine of HashiCorp Vault.
+// Key ids are the names of transit keys; the wrapped data keys are the
+// "vault:v1:..." ciphertexts returned by Vault.
+type VaultKMS struct {
+	// Address is the base URL of Vault, such as https://vault.example.com:8200.
+	Address string
+	// Token is sent as X-Vault-Token.
+	Token string
+	// Mount is the mount path of the transit engine, DefaultVaultMount if empty.
+	Mount string
+	// Namespace is sent as X-Vault-Namespace if set (Vault Enterprise).
+	Namespace string
+	// Client is used for requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// NewVaultKMS returns a VaultKMS for the transit engine at its default mount.
+func NewVaultKMS(address, token string) *VaultKMS {
+	return &VaultKMS{
+		Address: address,
+		Token:   token,
+		Mount:   DefaultVaultMount,
+	}
+}
+
+// vaultResponse is the part of a transit response that is used.
+type vaultResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// GenerateDataKey implements KMS with the datakey/plaintext endpoint.
+func (service *VaultKMS) GenerateDataKey(ctx context.Context, keyID string) (*DataKey, error) {
+	response, err := service.post(ctx, "datakey/plaintext", keyID, map[string]any{"bits": DataKeySize * 8})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key from vault: %w", err)
+	}
+	if len(plaintext) != DataKeySize || response.Data.Ciphertext == "" {
+		clear(plaintext)
+		return nil, fmt.Errorf("invalid data key from vault: got %d bytes", len(plaintext))
+	}
+
+	return &DataKey{KeyID: keyID, Plaintext: plaintext, Ciphertext: []byte(response.Data.Ciphertext)}, nil
+}
+
+// Decrypt implements KMS with the decrypt endpoint.
+func (service *VaultKMS) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	response, err := service.post(ctx, "decrypt", keyID, map[string]any{"ciphertext": string(ciphertext)})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key from vault: %w", err)
+	}
+	return plaintext, nil
+}
+
+// post sends a request to the transit endpoint for keyID and decodes the response.
+func (service *VaultKMS) post(ctx context.Context, endpoint, keyID string, body map[string]any) (*vaultResponse, error) {
+	if keyID == "" {
+		return nil, fmt.Errorf("%w: empty key id", ErrKeyNotFound)
+	}
+	mount := strings.Trim(service.Mount, "/")
+	if mount == "" {
+		mount = DefaultVaultMount
+	}
+	target := strings.TrimRight(service.Address, "/") + "/v1/" + mount + "/" + endpoint + "/" + url.PathEscape(keyID)
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Vault-Token", service.Token)
+	if service.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", service.Namespace)
+	}
+
+	client := service.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResponse, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	var response vaultResponse
+	decodeErr := json.NewDecoder(io.LimitReader(httpResponse.Body, maxVaultResponseSize)).Decode(&response)
+	if httpResponse.StatusCode/100 != 2 {
+		return nil, &VaultError{StatusCode: httpResponse.StatusCode, Errors: response.Errors}
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", decodeErr)
+	}
+
+	return &response, nil
+}
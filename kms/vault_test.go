@@ -0,0 +1,140 @@
+package kms_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/kms"
+)
+
+// fakeTransit is a stand-in for the Vault transit engine that knows a
+// single key and token.
+type fakeTransit struct {
+	key       []byte
+	requests  int
+	namespace string
+}
+
+func (transit *fakeTransit) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	transit.requests++
+	transit.namespace = request.Header.Get("X-Vault-Namespace")
+	respond := func(status int, body map[string]any) {
+		writer.WriteHeader(status)
+		json.NewEncoder(writer).Encode(body)
+	}
+	if request.Header.Get("X-Vault-Token") != "s.token" {
+		respond(http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+		return
+	}
+
+	var body map[string]any
+	json.NewDecoder(request.Body).Decode(&body)
+	block, _ := aes.NewCipher(transit.key)
+	gcm, _ := cipher.NewGCM(block)
+
+	switch request.URL.Path {
+	case "/v1/transit/datakey/plaintext/mfa":
+		if body["bits"] != float64(256) {
+			respond(http.StatusBadRequest, map[string]any{"errors": []string{"invalid bits"}})
+			return
+		}
+		plaintext := make([]byte, 32)
+		nonce := make([]byte, gcm.NonceSize())
+		rand.Read(plaintext)
+		rand.Read(nonce)
+		ciphertext := "vault:v1:" + base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil))
+		respond(http.StatusOK, map[string]any{"data": map[string]any{
+			"plaintext":  base64.StdEncoding.EncodeToString(plaintext),
+			"ciphertext": ciphertext,
+		}})
+	case "/v1/transit/decrypt/mfa":
+		ciphertext, _ := body["ciphertext"].(string)
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, "vault:v1:"))
+		if err != nil || len(sealed) < gcm.NonceSize() {
+			respond(http.StatusBadRequest, map[string]any{"errors": []string{"invalid ciphertext"}})
+			return
+		}
+		plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil {
+			respond(http.StatusBadRequest, map[string]any{"errors": []string{"cipher: message authentication failed"}})
+			return
+		}
+		respond(http.StatusOK, map[string]any{"data": map[string]any{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}})
+	default:
+		respond(http.StatusBadRequest, map[string]any{"errors": []string{"encryption key not found"}})
+	}
+}
+
+func newFakeTransit(t *testing.T) (*fakeTransit, *kms.VaultKMS) {
+	t.Helper()
+	transit := &fakeTransit{key: bytes.Repeat([]byte{7}, 32)}
+	server := httptest.NewServer(transit)
+	t.Cleanup(server.Close)
+
+	service := kms.NewVaultKMS(server.URL+"/", "s.token")
+	service.Client = server.Client()
+	return transit, service
+}
+
+func TestVaultKMS(t *testing.T) {
+	ctx := context.Background()
+	transit, service := newFakeTransit(t)
+	service.Namespace = "team"
+
+	encrypter, _ := kms.NewEncrypter(service, "mfa")
+	encrypted, err := encrypter.Encrypt(ctx, []byte("seed"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyID, _ := kms.KeyIDOf(encrypted); keyID != "mfa" {
+		t.Errorf("expected key id mfa, got %q", keyID)
+	}
+	if !bytes.Contains(encrypted, []byte("vault:v1:")) {
+		t.Errorf("expected the vault ciphertext in the envelope")
+	}
+
+	decrypted, err := encrypter.Decrypt(ctx, encrypted)
+	if err != nil || string(decrypted) != "seed" {
+		t.Errorf("expected seed, got %q, %v", decrypted, err)
+	}
+	if transit.requests != 2 || transit.namespace != "team" {
+		t.Errorf("expected two requests in namespace team, got %d in %q", transit.requests, transit.namespace)
+	}
+}
+
+func TestVaultKMSErrors(t *testing.T) {
+	ctx := context.Background()
+	_, service := newFakeTransit(t)
+
+	if _, err := service.GenerateDataKey(ctx, "unknown"); !errors.Is(err, kms.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	if _, err := service.Decrypt(ctx, "mfa", []byte("vault:v1:AAAA")); err == nil {
+		t.Errorf("expected error for an invalid ciphertext")
+	}
+
+	service.Token = "wrong"
+	var vaultErr *kms.VaultError
+	if _, err := service.GenerateDataKey(ctx, "mfa"); !errors.As(err, &vaultErr) || vaultErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected permission denied, got %v", err)
+	} else if !strings.Contains(err.Error(), "permission denied") || errors.Is(err, kms.ErrKeyNotFound) {
+		t.Errorf("unexpected error message %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := service.GenerateDataKey(canceled, "mfa"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
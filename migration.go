@@ -0,0 +1,198 @@
+package tinymfa
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Field numbers and enum values of the MigrationPayload protobuf message that
+// Google Authenticator embeds in otpauth-migration:// URLs.
+const (
+	migrationFieldOtpParameters = 1
+
+	otpFieldSecret    = 1
+	otpFieldName      = 2
+	otpFieldIssuer    = 3
+	otpFieldAlgorithm = 4
+	otpFieldDigits    = 5
+	otpFieldType      = 6
+	otpFieldCounter   = 7
+
+	migrationAlgorithmSHA1   = 1
+	migrationAlgorithmSHA256 = 2
+	migrationAlgorithmSHA512 = 3
+
+	migrationDigitsSix   = 1
+	migrationDigitsEight = 2
+
+	migrationTypeHOTP = 1
+	migrationTypeTOTP = 2
+)
+
+// protobuf wire types used by the migration payload
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncatedMigrationPayload = errors.New("truncated migration payload")
+
+// protoField is a single decoded field of a protobuf message.
+type protoField struct {
+	number   uint64
+	wireType uint64
+	varint   uint64
+	bytes    []byte
+}
+
+// readProtoFields decodes the top level fields of a protobuf message.
+// Only the wire types that occur in migration payloads are supported.
+func readProtoFields(message []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, errTruncatedMigrationPayload
+		}
+		message = message[n:]
+
+		field := protoField{number: key >> 3, wireType: key & 0x7}
+		switch field.wireType {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(message)
+			if n <= 0 {
+				return nil, errTruncatedMigrationPayload
+			}
+			message = message[n:]
+		case wireFixed64:
+			if len(message) < 8 {
+				return nil, errTruncatedMigrationPayload
+			}
+			message = message[8:]
+		case wireBytes:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return nil, errTruncatedMigrationPayload
+			}
+			field.bytes = message[n : n+int(length)]
+			message = message[n+int(length):]
+		case wireFixed32:
+			if len(message) < 4 {
+				return nil, errTruncatedMigrationPayload
+			}
+			message = message[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", field.wireType)
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// parseMigrationPayload decodes a serialized MigrationPayload message into
+// key descriptions.
+func parseMigrationPayload(raw []byte) ([]KeyDescription, error) {
+	fields, err := readProtoFields(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptions []KeyDescription
+	for _, field := range fields {
+		if field.number != migrationFieldOtpParameters || field.wireType != wireBytes {
+			continue
+		}
+		description, err := parseMigrationOtpParameters(field.bytes)
+		if err != nil {
+			return nil, err
+		}
+		descriptions = append(descriptions, description)
+	}
+
+	if len(descriptions) == 0 {
+		return nil, errors.New("migration payload does not contain any keys")
+	}
+
+	return descriptions, nil
+}
+
+// parseMigrationOtpParameters decodes a single OtpParameters message.
+func parseMigrationOtpParameters(raw []byte) (KeyDescription, error) {
+	description := KeyDescription{
+		Type:      TOTP,
+		Algorithm: SHA1,
+		Digits:    6,
+	}
+
+	fields, err := readProtoFields(raw)
+	if err != nil {
+		return description, err
+	}
+
+	var name string
+	for _, field := range fields {
+		switch field.number {
+		case otpFieldSecret:
+			description.Secret = append([]byte(nil), field.bytes...)
+		case otpFieldName:
+			name = string(field.bytes)
+		case otpFieldIssuer:
+			description.Issuer = string(field.bytes)
+		case otpFieldAlgorithm:
+			switch field.varint {
+			case 0, migrationAlgorithmSHA1:
+				description.Algorithm = SHA1
+			case migrationAlgorithmSHA256:
+				description.Algorithm = SHA256
+			case migrationAlgorithmSHA512:
+				description.Algorithm = SHA512
+			default:
+				return description, fmt.Errorf("unsupported migration algorithm: %d", field.varint)
+			}
+		case otpFieldDigits:
+			switch field.varint {
+			case 0, migrationDigitsSix:
+				description.Digits = 6
+			case migrationDigitsEight:
+				description.Digits = 8
+			default:
+				return description, fmt.Errorf("unsupported migration digit count: %d", field.varint)
+			}
+		case otpFieldType:
+			switch field.varint {
+			case 0, migrationTypeTOTP:
+				description.Type = TOTP
+			case migrationTypeHOTP:
+				description.Type = HOTP
+			default:
+				return description, fmt.Errorf("unsupported migration otp type: %d", field.varint)
+			}
+		case otpFieldCounter:
+			description.Counter = field.varint
+		}
+	}
+
+	if len(description.Secret) == 0 {
+		return description, errors.New("migration entry does not contain a secret")
+	}
+
+	// the name usually carries the "issuer:account" label of the original URL
+	description.Account = name
+	if issuer, account, found := strings.Cut(name, ":"); found {
+		if description.Issuer == "" {
+			description.Issuer = issuer
+		}
+		description.Account = account
+	}
+
+	if description.Type == TOTP {
+		description.Period = DefaultTimeStep
+	}
+
+	return description, nil
+}
@@ -0,0 +1,248 @@
+package tinymfa
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// OtpType distinguishes time-based from counter-based one-time passwords.
+type OtpType uint8
+
+const (
+	// TOTP marks a time-based one-time password key (RFC 6238).
+	TOTP OtpType = iota
+	// HOTP marks a counter-based one-time password key (RFC 4226).
+	HOTP
+)
+
+// String returns the lower case otpauth:// type name of the OtpType.
+func (otpType OtpType) String() string {
+	switch otpType {
+	case TOTP:
+		return "totp"
+	case HOTP:
+		return "hotp"
+	default:
+		return fmt.Sprintf("OtpType(%d)", uint8(otpType))
+	}
+}
+
+// MarshalText encodes the OtpType as its otpauth:// type name.
+func (otpType OtpType) MarshalText() ([]byte, error) {
+	switch otpType {
+	case TOTP, HOTP:
+		return []byte(otpType.String()), nil
+	default:
+		return nil, fmt.Errorf("invalid otp type: %d", uint8(otpType))
+	}
+}
+
+// UnmarshalText decodes an OtpType from its otpauth:// type name.
+func (otpType *OtpType) UnmarshalText(text []byte) error {
+	switch strings.ToLower(string(text)) {
+	case "totp":
+		*otpType = TOTP
+	case "hotp":
+		*otpType = HOTP
+	default:
+		return fmt.Errorf("invalid otp type: %s", text)
+	}
+	return nil
+}
+
+// String returns the otpauth:// algorithm name of the HashAlgorithm.
+func (algorithm HashAlgorithm) String() string {
+	switch algorithm {
+	case SHA1:
+		return "SHA1"
+	case SHA256:
+		return "SHA256"
+	case SHA512:
+		return "SHA512"
+	default:
+		return fmt.Sprintf("HashAlgorithm(%d)", uint8(algorithm))
+	}
+}
+
+// ParseHashAlgorithm converts an algorithm name such as "SHA256" or "sha-256"
+// into the matching HashAlgorithm.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	switch strings.ToUpper(strings.ReplaceAll(name, "-", "")) {
+	case "SHA1":
+		return SHA1, nil
+	case "SHA256":
+		return SHA256, nil
+	case "SHA512":
+		return SHA512, nil
+	default:
+		return SHA1, fmt.Errorf("unsupported hash algorithm: %s", name)
+	}
+}
+
+// KeyDescription describes an OTP key together with the parameters an
+// authenticator needs to produce tokens for it. It is the common result of
+// parsing otpauth:// URLs, otpauth-migration:// exports and decoded QR codes.
+type KeyDescription struct {
+	Type      OtpType
+	Issuer    string
+	Account   string
+	Secret    []byte
+	Algorithm HashAlgorithm
+	Digits    uint8
+	Period    int64
+	Counter   uint64
+}
+
+// ErrUnsupportedPayload is returned when a payload is neither an otpauth://
+// nor an otpauth-migration:// URL.
+var ErrUnsupportedPayload = errors.New("unsupported payload: expected otpauth:// or otpauth-migration:// URL")
+
+// ParsePayload parses an otpauth:// URL or an otpauth-migration:// export into
+// key descriptions. An otpauth:// URL always yields exactly one description,
+// while a migration payload may carry several keys. Every key has to satisfy
+// the current KeyPolicy.
+func (tinymfa *TinyMfa) ParsePayload(payload string) ([]KeyDescription, error) {
+	var descriptions []KeyDescription
+
+	payload = strings.TrimSpace(payload)
+	lower := strings.ToLower(payload)
+	switch {
+	case strings.HasPrefix(lower, "otpauth://"):
+		description, err := parseOtpAuthURL(payload)
+		if err != nil {
+			return nil, err
+		}
+		descriptions = []KeyDescription{description}
+	case strings.HasPrefix(lower, "otpauth-migration://"):
+		var err error
+		descriptions, err = parseMigrationURL(payload)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedPayload
+	}
+
+	for index, description := range descriptions {
+		if err := tinymfa.KeyPolicy.Validate(description.Secret, description.Algorithm); err != nil {
+			return nil, fmt.Errorf("key %d (%s:%s): %w", index, description.Issuer, description.Account, err)
+		}
+	}
+
+	return descriptions, nil
+}
+
+// parseOtpAuthURL parses a single otpauth:// URL as described by the
+// Key Uri Format used by Google Authenticator and compatible apps.
+func parseOtpAuthURL(payload string) (KeyDescription, error) {
+	var description KeyDescription
+
+	parsed, err := url.Parse(payload)
+	if err != nil {
+		return description, err
+	}
+
+	switch strings.ToLower(parsed.Host) {
+	case "totp":
+		description.Type = TOTP
+	case "hotp":
+		description.Type = HOTP
+	default:
+		return description, fmt.Errorf("unsupported otp type: %s", parsed.Host)
+	}
+
+	label := strings.TrimPrefix(parsed.Path, "/")
+	if index := strings.Index(label, ":"); index != -1 {
+		description.Issuer = strings.TrimSpace(label[:index])
+		description.Account = strings.TrimSpace(label[index+1:])
+	} else {
+		description.Account = strings.TrimSpace(label)
+	}
+
+	query := parsed.Query()
+	if issuer := query.Get("issuer"); issuer != "" {
+		description.Issuer = issuer
+	}
+
+	secret := query.Get("secret")
+	if secret == "" {
+		return description, errors.New("otpauth url does not contain a secret")
+	}
+	description.Secret, err = utils.DecodeBase32Secret(secret)
+	if err != nil {
+		return description, fmt.Errorf("invalid secret: %w", err)
+	}
+
+	description.Algorithm = SHA1
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		description.Algorithm, err = ParseHashAlgorithm(algorithm)
+		if err != nil {
+			return description, err
+		}
+	}
+
+	description.Digits = 6
+	if digits := query.Get("digits"); digits != "" {
+		// tokens can only be generated with 5 to 8 digits
+		value, err := strconv.ParseUint(digits, 10, 8)
+		if err != nil || value < 5 || value > 8 {
+			return description, fmt.Errorf("invalid digits: %s", digits)
+		}
+		description.Digits = uint8(value)
+	}
+
+	if description.Type == TOTP {
+		description.Period = DefaultTimeStep
+		if period := query.Get("period"); period != "" {
+			value, err := strconv.ParseInt(period, 10, 64)
+			if err != nil || value <= 0 {
+				return description, fmt.Errorf("invalid period: %s", period)
+			}
+			description.Period = value
+		}
+	}
+
+	if counter := query.Get("counter"); counter != "" {
+		description.Counter, err = strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return description, fmt.Errorf("invalid counter: %s", counter)
+		}
+	} else if description.Type == HOTP {
+		return description, errors.New("hotp url does not contain a counter")
+	}
+
+	return description, nil
+}
+
+// parseMigrationURL decodes the data parameter of an otpauth-migration:// URL
+// as exported by Google Authenticator.
+func parseMigrationURL(payload string) ([]KeyDescription, error) {
+	parsed, err := url.Parse(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	data := parsed.Query().Get("data")
+	if data == "" {
+		return nil, errors.New("otpauth-migration url does not contain data")
+	}
+
+	// the data parameter is standard base64, but '+' is frequently left
+	// unescaped and thus turned into a space by the query parser.
+	data = strings.ReplaceAll(data, " ", "+")
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		raw, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration data: %w", err)
+		}
+	}
+
+	return parseMigrationPayload(raw)
+}
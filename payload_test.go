@@ -0,0 +1,173 @@
+package tinymfa_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/url"
+	"strings"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+func TestParsePayloadOtpAuth(t *testing.T) {
+	encoded := mfautil.EncodeBase32Key(&keySHA256)
+	payload := tmfa.BuildPayload("tinymfa.test", "demo", encoded, 8, tinymfa.SHA256, 60)
+
+	descriptions, err := tmfa.ParsePayload(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descriptions) != 1 {
+		t.Fatalf("expected 1 description, got %d", len(descriptions))
+	}
+
+	description := descriptions[0]
+	if description.Type != tinymfa.TOTP {
+		t.Errorf("expected type TOTP, got %s", description.Type)
+	}
+	if description.Issuer != "tinymfa.test" {
+		t.Errorf("expected issuer tinymfa.test, got %s", description.Issuer)
+	}
+	if !bytes.Equal(description.Secret, keySHA256) {
+		t.Errorf("expected secret to round trip")
+	}
+	if description.Algorithm != tinymfa.SHA256 {
+		t.Errorf("expected algorithm SHA256, got %s", description.Algorithm)
+	}
+	if description.Digits != 8 {
+		t.Errorf("expected 8 digits, got %d", description.Digits)
+	}
+	if description.Period != 60 {
+		t.Errorf("expected period 60, got %d", description.Period)
+	}
+}
+
+func TestParsePayloadOtpAuthDefaults(t *testing.T) {
+	descriptions, err := tmfa.ParsePayload("otpauth://hotp/ACME:alice?secret=jbswy3dpehpk3pxp&counter=42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	description := descriptions[0]
+	if description.Type != tinymfa.HOTP {
+		t.Errorf("expected type HOTP, got %s", description.Type)
+	}
+	if description.Issuer != "ACME" || description.Account != "alice" {
+		t.Errorf("expected ACME/alice, got %s/%s", description.Issuer, description.Account)
+	}
+	if description.Algorithm != tinymfa.SHA1 || description.Digits != 6 {
+		t.Errorf("expected SHA1 with 6 digits, got %s with %d", description.Algorithm, description.Digits)
+	}
+	if description.Counter != 42 {
+		t.Errorf("expected counter 42, got %d", description.Counter)
+	}
+	if string(description.Secret) != "Hello!\xde\xad\xbe\xef" {
+		t.Errorf("unexpected secret %x", description.Secret)
+	}
+}
+
+func TestParsePayloadInvalid(t *testing.T) {
+	payloads := []string{
+		"https://example.com",
+		"otpauth://totp/ACME:alice",
+		"otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP&period=0",
+		"otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP&digits=4",
+		"otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP&digits=10",
+		"otpauth://hotp/ACME:alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://foo/ACME:alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth-migration://offline?data=AAAA",
+	}
+
+	for _, payload := range payloads {
+		if _, err := tmfa.ParsePayload(payload); err == nil {
+			t.Errorf("expected error for payload %s", payload)
+		}
+	}
+}
+
+// protoBytes appends a length delimited protobuf field.
+func protoBytes(buffer []byte, field uint64, value []byte) []byte {
+	buffer = binary.AppendUvarint(buffer, field<<3|2)
+	buffer = binary.AppendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+// protoVarint appends a varint protobuf field.
+func protoVarint(buffer []byte, field uint64, value uint64) []byte {
+	buffer = binary.AppendUvarint(buffer, field<<3)
+	return binary.AppendUvarint(buffer, value)
+}
+
+func TestParsePayloadMigration(t *testing.T) {
+	var first []byte
+	first = protoBytes(first, 1, keySHA1)
+	first = protoBytes(first, 2, []byte("ACME:alice"))
+	first = protoVarint(first, 4, 2) // SHA256
+	first = protoVarint(first, 5, 2) // eight digits
+	first = protoVarint(first, 6, 2) // TOTP
+
+	var second []byte
+	second = protoBytes(second, 1, keySHA256)
+	second = protoBytes(second, 2, []byte("bob"))
+	second = protoBytes(second, 3, []byte("Example"))
+	second = protoVarint(second, 6, 1) // HOTP
+	second = protoVarint(second, 7, 7)
+
+	var message []byte
+	message = protoBytes(message, 1, first)
+	message = protoBytes(message, 1, second)
+	message = protoVarint(message, 2, 1)
+
+	payload := "otpauth-migration://offline?data=" + url.QueryEscape(base64.StdEncoding.EncodeToString(message))
+	descriptions, err := tmfa.ParsePayload(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(descriptions) != 2 {
+		t.Fatalf("expected 2 descriptions, got %d", len(descriptions))
+	}
+
+	if descriptions[0].Issuer != "ACME" || descriptions[0].Account != "alice" {
+		t.Errorf("expected ACME/alice, got %s/%s", descriptions[0].Issuer, descriptions[0].Account)
+	}
+	if descriptions[0].Algorithm != tinymfa.SHA256 || descriptions[0].Digits != 8 {
+		t.Errorf("expected SHA256 with 8 digits, got %s with %d", descriptions[0].Algorithm, descriptions[0].Digits)
+	}
+	if descriptions[0].Period != tinymfa.DefaultTimeStep {
+		t.Errorf("expected default period, got %d", descriptions[0].Period)
+	}
+	if !bytes.Equal(descriptions[0].Secret, keySHA1) {
+		t.Errorf("expected secret to match")
+	}
+
+	if descriptions[1].Type != tinymfa.HOTP || descriptions[1].Counter != 7 {
+		t.Errorf("expected HOTP with counter 7, got %s with %d", descriptions[1].Type, descriptions[1].Counter)
+	}
+	if descriptions[1].Issuer != "Example" || descriptions[1].Account != "bob" {
+		t.Errorf("expected Example/bob, got %s/%s", descriptions[1].Issuer, descriptions[1].Account)
+	}
+}
+
+func TestBuildPayloadNormalizesSecret(t *testing.T) {
+	// 10 bytes encode to 16 characters; 11 bytes need four '=' of padding
+	key := []byte("12345678901")
+	padded := mfautil.EncodeBase32Key(&key)
+	if !strings.HasSuffix(*padded, "====") {
+		t.Fatalf("expected padded secret, got %s", *padded)
+	}
+
+	for _, secret := range []string{*padded, strings.ToLower(*padded), "GEZD GNBV GY3T QOJQ GE"} {
+		payload := tmfa.BuildPayload("ACME", "alice", &secret, 6, tinymfa.SHA1, 30)
+		if !strings.HasSuffix(payload, "&secret=GEZDGNBVGY3TQOJQGE") {
+			t.Errorf("expected a normalized secret for %q, got %s", secret, payload)
+		}
+
+		descriptions, err := tmfa.ParsePayload(payload)
+		if err != nil || !bytes.Equal(descriptions[0].Secret, key) {
+			t.Errorf("expected secret to round trip, got %v", err)
+		}
+	}
+}
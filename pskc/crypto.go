@@ -0,0 +1,276 @@
+package pskc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Algorithm identifiers of XML Encryption, XML Signature and PKCS #5 that
+// are used in PSKC files.
+const (
+	AlgorithmAES128CBC = "http://www.w3.org/2001/04/xmlenc#aes128-cbc"
+	AlgorithmAES192CBC = "http://www.w3.org/2001/04/xmlenc#aes192-cbc"
+	AlgorithmAES256CBC = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
+
+	AlgorithmKWAES128 = "http://www.w3.org/2001/04/xmlenc#kw-aes128"
+	AlgorithmKWAES192 = "http://www.w3.org/2001/04/xmlenc#kw-aes192"
+	AlgorithmKWAES256 = "http://www.w3.org/2001/04/xmlenc#kw-aes256"
+
+	AlgorithmKWAES128Pad = "http://www.w3.org/2009/xmlenc11#kw-aes-128-pad"
+	AlgorithmKWAES192Pad = "http://www.w3.org/2009/xmlenc11#kw-aes-192-pad"
+	AlgorithmKWAES256Pad = "http://www.w3.org/2009/xmlenc11#kw-aes-256-pad"
+
+	AlgorithmHMACSHA1   = "http://www.w3.org/2000/09/xmldsig#hmac-sha1"
+	AlgorithmHMACSHA256 = "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256"
+	AlgorithmHMACSHA384 = "http://www.w3.org/2001/04/xmldsig-more#hmac-sha384"
+	AlgorithmHMACSHA512 = "http://www.w3.org/2001/04/xmldsig-more#hmac-sha512"
+
+	AlgorithmPBKDF2 = "http://www.rsasecurity.com/rsalabs/pkcs/schemas/pkcs-5v2-0#pbkdf2"
+)
+
+const (
+	// DefaultPBKDF2Iterations is the PBKDF2 iteration count of exported files.
+	DefaultPBKDF2Iterations = 100000
+
+	// MaxPBKDF2Iterations limits the PBKDF2 iteration count that an imported
+	// file may demand, so that a crafted file cannot stall the import.
+	MaxPBKDF2Iterations = 10_000_000
+
+	// pbkdf2SaltSize is the size of the PBKDF2 salt of exported files.
+	pbkdf2SaltSize = 16
+)
+
+var (
+	// ErrKeyRequired is returned when a file with encrypted values is
+	// imported without the matching pre-shared key or password.
+	ErrKeyRequired = errors.New("pskc file is encrypted: pre-shared key or password required")
+
+	// ErrMACMismatch is returned when the MAC of an encrypted value does not
+	// match, because the file was modified or the key is wrong.
+	ErrMACMismatch = errors.New("pskc value MAC does not match")
+
+	// ErrUnsupportedAlgorithm is returned for encryption, MAC or key
+	// derivation algorithms that are not implemented.
+	ErrUnsupportedAlgorithm = errors.New("unsupported pskc algorithm")
+)
+
+// Protection holds the key material of PSKC files with encrypted secrets.
+// Either PreSharedKey or Password is used; on import, the file determines
+// which one is needed.
+type Protection struct {
+	// PreSharedKey is an AES key of 16, 24 or 32 bytes that was agreed upon
+	// with the token vendor.
+	PreSharedKey []byte
+	// KeyName names the pre-shared key in exported files.
+	KeyName string
+	// Password derives the encryption key with PBKDF2.
+	Password []byte
+	// Iterations is the PBKDF2 iteration count of exported files,
+	// DefaultPBKDF2Iterations if zero. It must not be negative or exceed
+	// MaxPBKDF2Iterations.
+	Iterations int
+}
+
+// cipherKeySize returns the key size of an encryption algorithm.
+func cipherKeySize(algorithm string) (int, error) {
+	switch algorithm {
+	case AlgorithmAES128CBC, AlgorithmKWAES128, AlgorithmKWAES128Pad:
+		return 16, nil
+	case AlgorithmAES192CBC, AlgorithmKWAES192, AlgorithmKWAES192Pad:
+		return 24, nil
+	case AlgorithmAES256CBC, AlgorithmKWAES256, AlgorithmKWAES256Pad:
+		return 32, nil
+	default:
+		return 0, fmt.Errorf("%w: encryption %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// cbcAlgorithm returns the AES-CBC algorithm for a key.
+func cbcAlgorithm(key []byte) (string, error) {
+	switch len(key) {
+	case 16:
+		return AlgorithmAES128CBC, nil
+	case 24:
+		return AlgorithmAES192CBC, nil
+	case 32:
+		return AlgorithmAES256CBC, nil
+	default:
+		return "", utils.ErrInvalidKeySize
+	}
+}
+
+// decryptValue decrypts a CipherValue with the given algorithm.
+func decryptValue(algorithm string, key, ciphertext []byte) ([]byte, error) {
+	size, err := cipherKeySize(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%w: %s needs a %d byte key", utils.ErrInvalidKeySize, algorithm, size)
+	}
+
+	switch algorithm {
+	case AlgorithmKWAES128, AlgorithmKWAES192, AlgorithmKWAES256:
+		return utils.UnwrapKey(key, ciphertext)
+	case AlgorithmKWAES128Pad, AlgorithmKWAES192Pad, AlgorithmKWAES256Pad:
+		return utils.UnwrapKeyWithPadding(key, ciphertext)
+	}
+
+	// XML Encryption AES-CBC: the IV precedes the ciphertext, and the last
+	// byte of the plaintext holds the padding length
+	if len(ciphertext) < 2*aes.BlockSize || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, utils.ErrCiphertextTooShort
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(plaintext, ciphertext[aes.BlockSize:])
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		clear(plaintext)
+		return nil, utils.ErrDecryptionFailed
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// encryptValue encrypts plaintext with AES-CBC and a random IV, padded as
+// in PKCS #7, which satisfies XML Encryption.
+func encryptValue(key, plaintext []byte) (string, []byte, error) {
+	algorithm, err := cbcAlgorithm(key)
+	if err != nil {
+		return "", nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte(nil), plaintext...), make([]byte, padding)...)
+	defer clear(padded)
+	for i := len(plaintext); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+
+	ciphertext := make([]byte, aes.BlockSize+len(padded))
+	if _, err := io.ReadFull(rand.Reader, ciphertext[:aes.BlockSize]); err != nil {
+		return "", nil, err
+	}
+	cipher.NewCBCEncrypter(block, ciphertext[:aes.BlockSize]).CryptBlocks(ciphertext[aes.BlockSize:], padded)
+
+	return algorithm, ciphertext, nil
+}
+
+// macHash returns the hash function of an HMAC algorithm.
+func macHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case AlgorithmHMACSHA1:
+		return sha1.New, nil
+	case AlgorithmHMACSHA256:
+		return sha256.New, nil
+	case AlgorithmHMACSHA384:
+		return sha512.New384, nil
+	case AlgorithmHMACSHA512:
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: mac %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+}
+
+// computeMAC returns the HMAC of ciphertext.
+func computeMAC(algorithm string, key, ciphertext []byte) ([]byte, error) {
+	newHash, err := macHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write(ciphertext)
+	return mac.Sum(nil), nil
+}
+
+// verifyMAC checks the HMAC of ciphertext in constant time.
+func verifyMAC(algorithm string, key, ciphertext, mac []byte) error {
+	expected, err := computeMAC(algorithm, key, ciphertext)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, expected) {
+		return ErrMACMismatch
+	}
+	return nil
+}
+
+// newMACKey returns a random HMAC-SHA256 key for an exported file.
+func newMACKey() ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// deriveKey derives the encryption key of a password protected file.
+func deriveKey(params *pbkdf2Params, password []byte) ([]byte, error) {
+	salt, err := decodeBase64(params.Salt.Specified)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pbkdf2 salt: %w", err)
+	}
+	if params.IterationCount <= 0 || params.IterationCount > MaxPBKDF2Iterations {
+		return nil, fmt.Errorf("invalid pbkdf2 iteration count %d", params.IterationCount)
+	}
+	keyLength := params.KeyLength
+	if keyLength == 0 {
+		keyLength = 16
+	}
+	if _, err := cbcAlgorithm(make([]byte, keyLength)); err != nil {
+		return nil, fmt.Errorf("invalid pbkdf2 key length %d", keyLength)
+	}
+
+	prf := AlgorithmHMACSHA1
+	if params.PRF != nil && params.PRF.Algorithm != "" {
+		prf = params.PRF.Algorithm
+	}
+	newHash, err := macHash(prf)
+	if err != nil {
+		return nil, err
+	}
+
+	return pbkdf2.Key(password, salt, params.IterationCount, keyLength, newHash), nil
+}
+
+// newPBKDF2Params returns the parameters of a new password protected file.
+func newPBKDF2Params(iterations int) (*pbkdf2Params, error) {
+	if iterations < 0 || iterations > MaxPBKDF2Iterations {
+		return nil, fmt.Errorf("invalid pbkdf2 iteration count %d", iterations)
+	}
+	if iterations == 0 {
+		iterations = DefaultPBKDF2Iterations
+	}
+	salt := make([]byte, pbkdf2SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	params := &pbkdf2Params{
+		IterationCount: iterations,
+		KeyLength:      32,
+		PRF:            &algorithmElement{Algorithm: AlgorithmHMACSHA256},
+	}
+	params.Salt.Specified = encodeBase64(salt)
+	return params, nil
+}
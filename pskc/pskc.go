@@ -0,0 +1,507 @@
+// Package pskc imports and exports token seeds in the Portable Symmetric Key
+// Container format of RFC 6030, which hardware token vendors use to ship the
+// secrets of their tokens. Secrets may be in plain text, or encrypted with a
+// pre-shared key or a password based key (PBKDF2).
+package pskc
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// Namespace is the XML namespace of PSKC documents.
+const Namespace = "urn:ietf:params:xml:ns:keyprov:pskc"
+
+// Algorithm identifiers of the OTP algorithms that keys are imported for.
+const (
+	AlgorithmHOTP = "urn:ietf:params:xml:ns:keyprov:pskc:hotp"
+	AlgorithmTOTP = "urn:ietf:params:xml:ns:keyprov:pskc:totp"
+)
+
+// keyContainer is the KeyContainer document element. Elements of the PSKC
+// namespace are matched by their local name only, since some vendors use
+// the namespaces of earlier drafts.
+type keyContainer struct {
+	XMLName       xml.Name       `xml:"KeyContainer"`
+	Namespace     string         `xml:"xmlns,attr,omitempty"`
+	Version       string         `xml:"Version,attr"`
+	ID            string         `xml:"Id,attr,omitempty"`
+	EncryptionKey *encryptionKey `xml:"EncryptionKey"`
+	MACMethod     *macMethod     `xml:"MACMethod"`
+	KeyPackages   []keyPackage   `xml:"KeyPackage"`
+}
+
+type encryptionKey struct {
+	KeyName    string      `xml:"http://www.w3.org/2000/09/xmldsig# KeyName,omitempty"`
+	DerivedKey *derivedKey `xml:"http://www.w3.org/2009/xmlenc11# DerivedKey"`
+}
+
+type derivedKey struct {
+	KeyDerivationMethod keyDerivationMethod `xml:"http://www.w3.org/2009/xmlenc11# KeyDerivationMethod"`
+	MasterKeyName       string              `xml:"http://www.w3.org/2009/xmlenc11# MasterKeyName,omitempty"`
+}
+
+type keyDerivationMethod struct {
+	Algorithm string        `xml:"Algorithm,attr"`
+	PBKDF2    *pbkdf2Params `xml:"http://www.rsasecurity.com/rsalabs/pkcs/schemas/pkcs-5v2-0# PBKDF2-params"`
+}
+
+type pbkdf2Params struct {
+	Salt struct {
+		Specified string `xml:"Specified"`
+	} `xml:"Salt"`
+	IterationCount int               `xml:"IterationCount"`
+	KeyLength      int               `xml:"KeyLength,omitempty"`
+	PRF            *algorithmElement `xml:"PRF"`
+}
+
+type algorithmElement struct {
+	Algorithm string `xml:"Algorithm,attr"`
+}
+
+type macMethod struct {
+	Algorithm string          `xml:"Algorithm,attr"`
+	MACKey    *encryptedValue `xml:"MACKey"`
+}
+
+type encryptedValue struct {
+	EncryptionMethod algorithmElement `xml:"http://www.w3.org/2001/04/xmlenc# EncryptionMethod"`
+	CipherData       cipherData       `xml:"http://www.w3.org/2001/04/xmlenc# CipherData"`
+}
+
+type cipherData struct {
+	CipherValue string `xml:"http://www.w3.org/2001/04/xmlenc# CipherValue"`
+}
+
+type keyPackage struct {
+	DeviceInfo *deviceInfo `xml:"DeviceInfo"`
+	Key        *keyEntry   `xml:"Key"`
+}
+
+type deviceInfo struct {
+	Manufacturer string `xml:"Manufacturer,omitempty"`
+	SerialNo     string `xml:"SerialNo,omitempty"`
+	Model        string `xml:"Model,omitempty"`
+}
+
+type keyEntry struct {
+	ID                  string               `xml:"Id,attr"`
+	Algorithm           string               `xml:"Algorithm,attr"`
+	Issuer              string               `xml:"Issuer,omitempty"`
+	AlgorithmParameters *algorithmParameters `xml:"AlgorithmParameters"`
+	FriendlyName        string               `xml:"FriendlyName,omitempty"`
+	Data                *keyData             `xml:"Data"`
+	UserID              string               `xml:"UserId,omitempty"`
+}
+
+type algorithmParameters struct {
+	Suite          string          `xml:"Suite,omitempty"`
+	ResponseFormat *responseFormat `xml:"ResponseFormat"`
+}
+
+type responseFormat struct {
+	Length   int    `xml:"Length,attr"`
+	Encoding string `xml:"Encoding,attr"`
+}
+
+type keyData struct {
+	Secret       *dataValue `xml:"Secret"`
+	Counter      *dataValue `xml:"Counter"`
+	Time         *dataValue `xml:"Time"`
+	TimeInterval *dataValue `xml:"TimeInterval"`
+}
+
+type dataValue struct {
+	PlainValue     string          `xml:"PlainValue,omitempty"`
+	EncryptedValue *encryptedValue `xml:"EncryptedValue"`
+	ValueMAC       string          `xml:"ValueMAC,omitempty"`
+}
+
+// Import parses a PSKC document into accounts. HOTP keys keep their counter,
+// TOTP keys their time step and start time. Protection is only needed for
+// documents with encrypted values and may be nil otherwise. Keys of other
+// algorithms, such as OCRA, are rejected.
+//
+// Accounts are labelled with the UserId of the key, falling back to its
+// FriendlyName, the serial number of the device and the key id. Secrets are
+// checked against tinymfa.DefaultKeyPolicy.
+func Import(data []byte, protection *Protection) ([]*tinymfa.Account, error) {
+	return ImportWithKeyPolicy(data, protection, tinymfa.DefaultKeyPolicy())
+}
+
+// ImportWithKeyPolicy works like Import, but checks the secrets against the
+// given policy. The import fails if a secret violates it.
+func ImportWithKeyPolicy(data []byte, protection *Protection, policy tinymfa.KeyPolicy) ([]*tinymfa.Account, error) {
+	var container keyContainer
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("invalid pskc document: %w", err)
+	}
+	if container.Version != "" && container.Version != "1.0" {
+		return nil, fmt.Errorf("unsupported pskc version %s", container.Version)
+	}
+
+	decrypter := &valueDecrypter{container: &container, protection: protection}
+	var accounts []*tinymfa.Account
+	for _, keyPackage := range container.KeyPackages {
+		if keyPackage.Key == nil {
+			continue
+		}
+		account, err := importKey(keyPackage, decrypter)
+		if err == nil {
+			err = policy.Validate(account.Secret, account.Algorithm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", keyPackage.Key.ID, err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	if len(accounts) == 0 {
+		return nil, errors.New("pskc document does not contain any keys")
+	}
+	return accounts, nil
+}
+
+// importKey converts a single key package.
+func importKey(keyPackage keyPackage, decrypter *valueDecrypter) (*tinymfa.Account, error) {
+	key := keyPackage.Key
+
+	account := tinymfa.NewAccount(key.Issuer, key.UserID, nil)
+	if account.Label == "" {
+		account.Label = key.FriendlyName
+	}
+	if account.Label == "" && keyPackage.DeviceInfo != nil {
+		account.Label = keyPackage.DeviceInfo.SerialNo
+	}
+	if account.Label == "" {
+		account.Label = key.ID
+	}
+
+	switch otpAlgorithm(key.Algorithm) {
+	case "hotp":
+		account.Type = tinymfa.HOTP
+	case "totp":
+		account.Type = tinymfa.TOTP
+	default:
+		return nil, fmt.Errorf("%w: key algorithm %s", ErrUnsupportedAlgorithm, key.Algorithm)
+	}
+
+	if parameters := key.AlgorithmParameters; parameters != nil {
+		if parameters.Suite != "" {
+			algorithm, err := tinymfa.ParseHashAlgorithm(strings.TrimPrefix(strings.ToUpper(parameters.Suite), "HMAC-"))
+			if err != nil {
+				return nil, err
+			}
+			account.Algorithm = algorithm
+		}
+		if format := parameters.ResponseFormat; format != nil {
+			if format.Encoding != "" && format.Encoding != "DECIMAL" {
+				return nil, fmt.Errorf("unsupported response encoding %s", format.Encoding)
+			}
+			// tokens can only be generated with 5 to 8 digits
+			if format.Length < 5 || format.Length > 8 {
+				return nil, fmt.Errorf("unsupported response length %d", format.Length)
+			}
+			account.Digits = uint8(format.Length)
+		}
+	}
+
+	if key.Data == nil || key.Data.Secret == nil {
+		return nil, errors.New("key does not contain a secret")
+	}
+	secret, err := decrypter.value(key.Data.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("key does not contain a secret")
+	}
+	account.Secret = secret
+
+	if account.Counter, err = decrypter.integer(key.Data.Counter, 0); err != nil {
+		return nil, fmt.Errorf("counter: %w", err)
+	}
+	t0, err := decrypter.integer(key.Data.Time, uint64(tinymfa.DefaultT0))
+	if err != nil {
+		return nil, fmt.Errorf("time: %w", err)
+	}
+	period, err := decrypter.integer(key.Data.TimeInterval, uint64(tinymfa.DefaultTimeStep))
+	if err != nil {
+		return nil, fmt.Errorf("time interval: %w", err)
+	}
+	if period == 0 || period > math.MaxInt32 || t0 > math.MaxInt64 {
+		return nil, errors.New("time interval or time out of range")
+	}
+	account.T0, account.Period = int64(t0), int64(period)
+
+	return account, nil
+}
+
+// otpAlgorithm returns "hotp" or "totp" for the algorithm identifiers of
+// RFC 6030 and of its drafts, such as http://www.ietf.org/keyprov/pskc#hotp.
+func otpAlgorithm(identifier string) string {
+	name := strings.ToLower(identifier)
+	if i := strings.LastIndexAny(name, ":#"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// valueDecrypter decrypts the encrypted values of a container, deriving the
+// encryption and MAC keys on first use.
+type valueDecrypter struct {
+	container  *keyContainer
+	protection *Protection
+	key        []byte
+	macKey     []byte
+}
+
+// keys returns the encryption and MAC keys of the container.
+func (decrypter *valueDecrypter) keys() ([]byte, []byte, error) {
+	if decrypter.key != nil {
+		return decrypter.key, decrypter.macKey, nil
+	}
+	protection := decrypter.protection
+	if protection == nil {
+		return nil, nil, ErrKeyRequired
+	}
+
+	switch containerKey := decrypter.container.EncryptionKey; {
+	case containerKey != nil && containerKey.DerivedKey != nil:
+		method := containerKey.DerivedKey.KeyDerivationMethod
+		if method.Algorithm != AlgorithmPBKDF2 || method.PBKDF2 == nil {
+			return nil, nil, fmt.Errorf("%w: key derivation %s", ErrUnsupportedAlgorithm, method.Algorithm)
+		}
+		if len(protection.Password) == 0 {
+			return nil, nil, ErrKeyRequired
+		}
+		key, err := deriveKey(method.PBKDF2, protection.Password)
+		if err != nil {
+			return nil, nil, err
+		}
+		decrypter.key = key
+	default:
+		if len(protection.PreSharedKey) == 0 {
+			return nil, nil, ErrKeyRequired
+		}
+		decrypter.key = protection.PreSharedKey
+	}
+
+	// without a MAC key of its own, the encryption key authenticates values
+	decrypter.macKey = decrypter.key
+	if method := decrypter.container.MACMethod; method != nil && method.MACKey != nil {
+		macKey, err := decrypter.decrypt(method.MACKey)
+		if err != nil {
+			decrypter.key = nil
+			return nil, nil, fmt.Errorf("mac key: %w", err)
+		}
+		decrypter.macKey = macKey
+	}
+
+	return decrypter.key, decrypter.macKey, nil
+}
+
+// decrypt decrypts an encrypted value with the encryption key.
+func (decrypter *valueDecrypter) decrypt(value *encryptedValue) ([]byte, error) {
+	ciphertext, err := decodeBase64(value.CipherData.CipherValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cipher value: %w", err)
+	}
+	return decryptValue(value.EncryptionMethod.Algorithm, decrypter.key, ciphertext)
+}
+
+// value returns the plain or decrypted content of a value. The MAC of an
+// encrypted value is checked before it is decrypted.
+func (decrypter *valueDecrypter) value(value *dataValue) ([]byte, error) {
+	if value.EncryptedValue == nil {
+		return decodeBase64(value.PlainValue)
+	}
+
+	_, macKey, err := decrypter.keys()
+	if err != nil {
+		return nil, err
+	}
+	algorithm := value.EncryptedValue.EncryptionMethod.Algorithm
+	if value.ValueMAC != "" || (decrypter.container.MACMethod != nil && !isKeyWrap(algorithm)) {
+		macAlgorithm := AlgorithmHMACSHA1
+		if method := decrypter.container.MACMethod; method != nil && method.Algorithm != "" {
+			macAlgorithm = method.Algorithm
+		}
+		ciphertext, err := decodeBase64(value.EncryptedValue.CipherData.CipherValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cipher value: %w", err)
+		}
+		mac, err := decodeBase64(value.ValueMAC)
+		if err != nil {
+			return nil, ErrMACMismatch
+		}
+		if err := verifyMAC(macAlgorithm, macKey, ciphertext, mac); err != nil {
+			return nil, err
+		}
+	}
+
+	return decrypter.decrypt(value.EncryptedValue)
+}
+
+// integer returns the content of an integer value, or fallback if it is absent.
+func (decrypter *valueDecrypter) integer(value *dataValue, fallback uint64) (uint64, error) {
+	if value == nil {
+		return fallback, nil
+	}
+	if value.EncryptedValue == nil {
+		return strconv.ParseUint(strings.TrimSpace(value.PlainValue), 10, 64)
+	}
+
+	// encrypted integers are big endian
+	data, err := decrypter.value(value)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > 8 {
+		return 0, fmt.Errorf("integer of %d bytes", len(data))
+	}
+	return binary.BigEndian.Uint64(append(make([]byte, 8-len(data)), data...)), nil
+}
+
+// isKeyWrap reports whether an encryption algorithm authenticates on its own.
+func isKeyWrap(algorithm string) bool {
+	switch algorithm {
+	case AlgorithmKWAES128, AlgorithmKWAES192, AlgorithmKWAES256, AlgorithmKWAES128Pad, AlgorithmKWAES192Pad, AlgorithmKWAES256Pad:
+		return true
+	default:
+		return false
+	}
+}
+
+// Export encodes accounts as a PSKC document. With a nil protection the
+// secrets are written in plain text. Otherwise they are encrypted with
+// AES-CBC under the pre-shared key or, if a password is set, a PBKDF2 key,
+// and authenticated with HMAC-SHA256 under a random MAC key.
+func Export(accounts []*tinymfa.Account, protection *Protection) ([]byte, error) {
+	container := keyContainer{
+		Namespace: Namespace,
+		Version:   "1.0",
+	}
+
+	var key, macKey []byte
+	if protection != nil {
+		var err error
+		if key, err = container.protect(protection); err != nil {
+			return nil, err
+		}
+		defer clear(key)
+
+		if macKey, err = newMACKey(); err != nil {
+			return nil, err
+		}
+		defer clear(macKey)
+
+		algorithm, ciphertext, err := encryptValue(key, macKey)
+		if err != nil {
+			return nil, err
+		}
+		container.MACMethod = &macMethod{
+			Algorithm: AlgorithmHMACSHA256,
+			MACKey:    newEncryptedValue(algorithm, ciphertext),
+		}
+	}
+
+	for i, account := range accounts {
+		if len(account.Secret) == 0 {
+			return nil, fmt.Errorf("account %s does not have a secret", account.Label)
+		}
+
+		exported := &keyEntry{
+			ID:     strconv.Itoa(i + 1),
+			Issuer: account.Issuer,
+			AlgorithmParameters: &algorithmParameters{
+				Suite:          "HMAC-" + account.Algorithm.String(),
+				ResponseFormat: &responseFormat{Length: int(account.Digits), Encoding: "DECIMAL"},
+			},
+			Data:   &keyData{Secret: &dataValue{}},
+			UserID: account.Label,
+		}
+		if account.Type == tinymfa.HOTP {
+			exported.Algorithm = AlgorithmHOTP
+			exported.Data.Counter = &dataValue{PlainValue: strconv.FormatUint(account.Counter, 10)}
+		} else {
+			exported.Algorithm = AlgorithmTOTP
+			exported.Data.Time = &dataValue{PlainValue: strconv.FormatInt(account.T0, 10)}
+			exported.Data.TimeInterval = &dataValue{PlainValue: strconv.FormatInt(account.Period, 10)}
+		}
+
+		if key == nil {
+			exported.Data.Secret.PlainValue = encodeBase64(account.Secret)
+		} else {
+			algorithm, ciphertext, err := encryptValue(key, account.Secret)
+			if err != nil {
+				return nil, err
+			}
+			mac, err := computeMAC(AlgorithmHMACSHA256, macKey, ciphertext)
+			if err != nil {
+				return nil, err
+			}
+			exported.Data.Secret.EncryptedValue = newEncryptedValue(algorithm, ciphertext)
+			exported.Data.Secret.ValueMAC = encodeBase64(mac)
+		}
+
+		container.KeyPackages = append(container.KeyPackages, keyPackage{Key: exported})
+	}
+
+	encoded, err := xml.MarshalIndent(container, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(encoded, '\n')...), nil
+}
+
+// protect sets the EncryptionKey element of an exported container and
+// returns the encryption key.
+func (container *keyContainer) protect(protection *Protection) ([]byte, error) {
+	if len(protection.Password) > 0 {
+		params, err := newPBKDF2Params(protection.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		container.EncryptionKey = &encryptionKey{DerivedKey: &derivedKey{
+			KeyDerivationMethod: keyDerivationMethod{Algorithm: AlgorithmPBKDF2, PBKDF2: params},
+		}}
+		return deriveKey(params, protection.Password)
+	}
+
+	if _, err := cbcAlgorithm(protection.PreSharedKey); err != nil {
+		return nil, err
+	}
+	container.EncryptionKey = &encryptionKey{KeyName: protection.KeyName}
+	if container.EncryptionKey.KeyName == "" {
+		container.EncryptionKey.KeyName = "Pre-shared-key"
+	}
+	return append([]byte(nil), protection.PreSharedKey...), nil
+}
+
+// newEncryptedValue returns the EncryptedValue element of a ciphertext.
+func newEncryptedValue(algorithm string, ciphertext []byte) *encryptedValue {
+	return &encryptedValue{
+		EncryptionMethod: algorithmElement{Algorithm: algorithm},
+		CipherData:       cipherData{CipherValue: encodeBase64(ciphertext)},
+	}
+}
+
+// decodeBase64 decodes base64 content, which is often wrapped in PSKC files.
+func decodeBase64(encoded string) ([]byte, error) {
+	return utils.DecodeBase64Secret(encoded)
+}
+
+// encodeBase64 encodes binary content.
+func encodeBase64(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
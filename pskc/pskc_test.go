@@ -0,0 +1,239 @@
+package pskc_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/pskc"
+)
+
+// rfcSecret is the secret of the examples of RFC 6030.
+var rfcSecret = []byte("12345678901234567890")
+
+func readExample(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
+
+func TestImportPlain(t *testing.T) {
+	accounts, err := pskc.Import(readExample(t, "figure3.pskcxml"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(accounts) != 1 {
+		t.Fatalf("expected one account, got %d", len(accounts))
+	}
+
+	account := accounts[0]
+	if !bytes.Equal(account.Secret, rfcSecret) {
+		t.Errorf("expected secret %q, got %q", rfcSecret, account.Secret)
+	}
+	if account.Type != tinymfa.HOTP || account.Digits != 8 || account.Counter != 0 || account.Algorithm != tinymfa.SHA1 {
+		t.Errorf("unexpected parameters %+v", account)
+	}
+	if account.Issuer != "Issuer" || account.Label != "UID=jsmith,DC=example-bank,DC=net" {
+		t.Errorf("unexpected issuer %q or label %q", account.Issuer, account.Label)
+	}
+
+	// RFC 4226 Appendix D: the 8 digit HOTP value of counter 0
+	if token, err := account.Generate(0); err != nil || token != 84755224 {
+		t.Errorf("expected token 84755224, got %d, %v", token, err)
+	}
+}
+
+func TestImportPreSharedKey(t *testing.T) {
+	key, _ := hex.DecodeString("12345678901234567890123456789012")
+	data := readExample(t, "figure6.pskcxml")
+
+	accounts, err := pskc.Import(data, &pskc.Protection{PreSharedKey: key})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(accounts[0].Secret, rfcSecret) || accounts[0].Label != "987654321" {
+		t.Errorf("unexpected account %+v", accounts[0])
+	}
+
+	if _, err := pskc.Import(data, nil); !errors.Is(err, pskc.ErrKeyRequired) {
+		t.Errorf("expected ErrKeyRequired, got %v", err)
+	}
+	wrongKey := bytes.Repeat([]byte{1}, 16)
+	if _, err := pskc.Import(data, &pskc.Protection{PreSharedKey: wrongKey}); err == nil {
+		t.Errorf("expected error for a wrong key")
+	}
+
+	tampered := bytes.Replace(data, []byte("Su+NvtQfmvfJzF6bmQiJqoLRExc="), []byte("Tu+NvtQfmvfJzF6bmQiJqoLRExc="), 1)
+	if _, err := pskc.Import(tampered, &pskc.Protection{PreSharedKey: key}); !errors.Is(err, pskc.ErrMACMismatch) {
+		t.Errorf("expected ErrMACMismatch, got %v", err)
+	}
+}
+
+func TestImportPBKDF2(t *testing.T) {
+	data := readExample(t, "figure7.pskcxml")
+
+	accounts, err := pskc.Import(data, &pskc.Protection{Password: []byte("qwerty")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(accounts[0].Secret, rfcSecret) || accounts[0].Issuer != "Example-Issuer" {
+		t.Errorf("unexpected account %+v", accounts[0])
+	}
+
+	if _, err := pskc.Import(data, &pskc.Protection{Password: []byte("wrong")}); err == nil {
+		t.Errorf("expected error for a wrong password")
+	}
+	if _, err := pskc.Import(data, &pskc.Protection{PreSharedKey: rfcSecret[:16]}); !errors.Is(err, pskc.ErrKeyRequired) {
+		t.Errorf("expected ErrKeyRequired without password, got %v", err)
+	}
+}
+
+func TestImportTOTP(t *testing.T) {
+	document := `<KeyContainer Version="1.0" xmlns="urn:ietf:params:xml:ns:keyprov:pskc">
+  <KeyPackage>
+    <Key Id="t1" Algorithm="urn:ietf:params:xml:ns:keyprov:pskc:totp">
+      <AlgorithmParameters>
+        <Suite>HMAC-SHA256</Suite>
+        <ResponseFormat Length="6" Encoding="DECIMAL"/>
+      </AlgorithmParameters>
+      <FriendlyName>token one</FriendlyName>
+      <Data>
+        <Secret><PlainValue>MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=</PlainValue></Secret>
+        <Time><PlainValue>0</PlainValue></Time>
+        <TimeInterval><PlainValue>60</PlainValue></TimeInterval>
+      </Data>
+    </Key>
+  </KeyPackage>
+</KeyContainer>`
+
+	accounts, err := pskc.Import([]byte(document), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	account := accounts[0]
+	if account.Type != tinymfa.TOTP || account.Algorithm != tinymfa.SHA256 || account.Period != 60 || account.Digits != 6 || account.Label != "token one" {
+		t.Errorf("unexpected parameters %+v", account)
+	}
+
+	for _, length := range []string{"4", "10"} {
+		unsupported := strings.Replace(document, `Length="6"`, `Length="`+length+`"`, 1)
+		if _, err := pskc.Import([]byte(unsupported), nil); err == nil {
+			t.Errorf("expected error for a response length of %s", length)
+		}
+	}
+
+	short := strings.Replace(document, "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=", "MTIz", 1)
+	if _, err := pskc.Import([]byte(short), nil); !errors.Is(err, tinymfa.ErrKeyTooShort) {
+		t.Errorf("expected ErrKeyTooShort, got %v", err)
+	}
+	if _, err := pskc.ImportWithKeyPolicy([]byte(short), nil, tinymfa.KeyPolicy{}); err != nil {
+		t.Errorf("expected an empty policy to accept a short secret, got %v", err)
+	}
+
+	ocra := strings.Replace(document, "pskc:totp", "pskc:ocra", 1)
+	if _, err := pskc.Import([]byte(ocra), nil); !errors.Is(err, pskc.ErrUnsupportedAlgorithm) {
+		t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+	if _, err := pskc.Import([]byte("<KeyContainer Version=\"1.0\"/>"), nil); err == nil {
+		t.Errorf("expected error for a document without keys")
+	}
+}
+
+func exportAccounts() []*tinymfa.Account {
+	hotp := tinymfa.NewAccount("ACME", "alice", rfcSecret)
+	hotp.Type = tinymfa.HOTP
+	hotp.Counter = 42
+	hotp.Digits = 8
+
+	totp := tinymfa.NewAccount("ACME", "bob", bytes.Repeat([]byte{7}, 32))
+	totp.Algorithm = tinymfa.SHA256
+	totp.Period = 60
+
+	return []*tinymfa.Account{hotp, totp}
+}
+
+func checkExport(t *testing.T, data []byte, protection *pskc.Protection) {
+	t.Helper()
+	accounts, err := pskc.Import(data, protection)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := exportAccounts()
+	if len(accounts) != len(expected) {
+		t.Fatalf("expected %d accounts, got %d", len(expected), len(accounts))
+	}
+	for i, account := range accounts {
+		want := expected[i]
+		if !bytes.Equal(account.Secret, want.Secret) || account.Label != want.Label || account.Issuer != want.Issuer ||
+			account.Type != want.Type || account.Algorithm != want.Algorithm || account.Digits != want.Digits ||
+			account.Counter != want.Counter || account.Period != want.Period || account.T0 != want.T0 {
+			t.Errorf("account %d: expected %+v, got %+v", i, want, account)
+		}
+	}
+}
+
+func TestExportPlain(t *testing.T) {
+	data, err := pskc.Export(exportAccounts(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`xmlns="urn:ietf:params:xml:ns:keyprov:pskc"`)) || !bytes.Contains(data, []byte(pskc.AlgorithmHOTP)) {
+		t.Errorf("unexpected document %s", data)
+	}
+	checkExport(t, data, nil)
+}
+
+func TestExportPreSharedKey(t *testing.T) {
+	protection := &pskc.Protection{PreSharedKey: bytes.Repeat([]byte{9}, 32), KeyName: "vendor key"}
+	data, err := pskc.Export(exportAccounts(), protection)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(data, []byte("PlainValue>MTIz")) || !bytes.Contains(data, []byte("vendor key")) || !bytes.Contains(data, []byte(pskc.AlgorithmAES256CBC)) {
+		t.Errorf("expected encrypted secrets, got %s", data)
+	}
+	checkExport(t, data, protection)
+
+	if _, err := pskc.Export(exportAccounts(), &pskc.Protection{PreSharedKey: []byte("short")}); err == nil {
+		t.Errorf("expected error for an invalid pre-shared key")
+	}
+}
+
+func TestExportPassword(t *testing.T) {
+	protection := &pskc.Protection{Password: []byte("correct horse"), Iterations: 1000}
+	data, err := pskc.Export(exportAccounts(), protection)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(data, []byte(pskc.AlgorithmPBKDF2)) {
+		t.Errorf("expected a pbkdf2 key, got %s", data)
+	}
+	checkExport(t, data, protection)
+
+	if _, err := pskc.Import(data, &pskc.Protection{Password: []byte("wrong")}); err == nil {
+		t.Errorf("expected error for a wrong password")
+	}
+
+	costly := bytes.Replace(data, []byte(">1000<"), []byte(">2147483647<"), 1)
+	if bytes.Equal(costly, data) {
+		t.Fatal("iteration count not found in export")
+	}
+	if _, err := pskc.Import(costly, protection); err == nil {
+		t.Errorf("expected error for an iteration count above MaxPBKDF2Iterations")
+	}
+
+	for _, iterations := range []int{-1, pskc.MaxPBKDF2Iterations + 1} {
+		invalid := &pskc.Protection{Password: []byte("correct horse"), Iterations: iterations}
+		if _, err := pskc.Export(exportAccounts(), invalid); err == nil {
+			t.Errorf("expected error for %d iterations", iterations)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package tinymfa
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the JPEG decoder for image.Decode
+	_ "image/png"  // register the PNG decoder for image.Decode
+	"os"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// DecodeQrCode locates and decodes the QR symbol in a PNG or JPEG image and
+// returns its text content, usually an otpauth:// or otpauth-migration:// URL.
+func (tinymfa *TinyMfa) DecodeQrCode(imageData []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return "", fmt.Errorf("could not decode image: %w", err)
+	}
+
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", err
+	}
+
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+	result, err := zxingqrcode.NewQRCodeReader().Decode(bitmap, hints)
+	if err != nil {
+		return "", fmt.Errorf("could not find a qr code in image: %w", err)
+	}
+
+	return result.GetText(), nil
+}
+
+// ReadQrCodeImage reads a PNG or JPEG image from the filesystem and decodes
+// the QR symbol it contains.
+func (tinymfa *TinyMfa) ReadQrCodeImage(filePath string) (string, error) {
+	imageData, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return tinymfa.DecodeQrCode(imageData)
+}
+
+// ImportQrCode decodes the QR symbol in a PNG or JPEG image and parses the
+// otpauth:// or otpauth-migration:// payload into key descriptions.
+func (tinymfa *TinyMfa) ImportQrCode(imageData []byte) ([]KeyDescription, error) {
+	payload, err := tinymfa.DecodeQrCode(imageData)
+	if err != nil {
+		return nil, err
+	}
+	return tinymfa.ParsePayload(payload)
+}
@@ -0,0 +1,94 @@
+package tinymfa_test
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+// qrmfa uses its own instance, as other tests change the qr code colors of tmfa
+var qrmfa = tinymfa.NewTinyMfa()
+
+func TestDecodeQrCodePNG(t *testing.T) {
+	encoded := mfautil.EncodeBase32Key(&keySHA1)
+	expected := qrmfa.BuildPayload("tinymfa.test", "demo", encoded, 6, tinymfa.SHA1, 30)
+
+	png, err := qrmfa.GenerateQrCode("tinymfa.test", "demo", encoded, 6, tinymfa.SHA1, 30)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	payload, err := qrmfa.DecodeQrCode(png)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if payload != expected {
+		t.Errorf("expected payload %s, got %s", expected, payload)
+	}
+}
+
+func TestDecodeQrCodeJPEG(t *testing.T) {
+	encoded := mfautil.EncodeBase32Key(&keySHA1)
+	png, err := qrmfa.GenerateQrCode("tinymfa.test", "demo", encoded, 6, tinymfa.SHA1, 30)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(png))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	descriptions, err := qrmfa.ImportQrCode(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(descriptions[0].Secret, keySHA1) {
+		t.Errorf("expected decoded secret to match")
+	}
+}
+
+func TestReadQrCodeImage(t *testing.T) {
+	encoded := mfautil.EncodeBase32Key(&keySHA1)
+	path := filepath.Join(t.TempDir(), "qrcode.png")
+	if err := qrmfa.WriteQrCodeImage("tinymfa.test", "demo", encoded, 6, tinymfa.SHA1, 30, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	payload, err := qrmfa.ReadQrCodeImage(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if payload != qrmfa.BuildPayload("tinymfa.test", "demo", encoded, 6, tinymfa.SHA1, 30) {
+		t.Errorf("unexpected payload %s", payload)
+	}
+
+	_, err = qrmfa.ReadQrCodeImage(filepath.Join(t.TempDir(), "missing.png"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestDecodeQrCodeInvalid(t *testing.T) {
+	if _, err := qrmfa.DecodeQrCode([]byte("not an image")); err == nil {
+		t.Error("expected error for invalid image data")
+	}
+
+	blank := image.NewGray(image.Rect(0, 0, 64, 64))
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, blank, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := qrmfa.DecodeQrCode(buffer.Bytes()); err == nil {
+		t.Error("expected error for image without qr code")
+	}
+}
@@ -0,0 +1,109 @@
+package tinymfa
+
+import (
+	"fmt"
+	"time"
+)
+
+// SecretMatch reports which secret of an account a token was generated with.
+type SecretMatch uint8
+
+const (
+	// MatchNone means the token did not match any secret.
+	MatchNone SecretMatch = iota
+	// MatchCurrent means the token matched the current secret.
+	MatchCurrent
+	// MatchPrevious means the token matched the previous secret during the
+	// grace period of a rotation.
+	MatchPrevious
+)
+
+// String returns the lower case name of the SecretMatch.
+func (match SecretMatch) String() string {
+	switch match {
+	case MatchNone:
+		return "none"
+	case MatchCurrent:
+		return "current"
+	case MatchPrevious:
+		return "previous"
+	default:
+		return fmt.Sprintf("SecretMatch(%d)", uint8(match))
+	}
+}
+
+// Rotation is the result of rotating the secret of an account. It carries
+// everything needed to re-enroll the authenticator app of the user.
+type Rotation struct {
+	Payload    string
+	QrCode     []byte
+	GraceUntil time.Time
+}
+
+// RotateSecret replaces the secret of the account with a new one generated by
+// GenerateSecretKeyForAlgorithm. The old secret stays valid for the grace
+// period or until the first successful validation with the new secret. If a
+// previous rotation is still in its grace period, its old secret is dropped.
+func (account *Account) RotateSecret(tmfa TinyMfaInterface, grace time.Duration) (*Rotation, error) {
+	secret, err := tmfa.GenerateSecretKeyForAlgorithm(account.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := *account
+	rotated.PreviousSecret = account.Secret
+	rotated.PreviousSecretExpiresAt = time.Now().Add(grace).UTC()
+	rotated.Secret = *secret
+
+	qrcode, err := tmfa.GenerateQrCodeFromPayload(rotated.BuildPayload())
+	if err != nil {
+		return nil, err
+	}
+	*account = rotated
+
+	return &Rotation{
+		Payload:    account.BuildPayload(),
+		QrCode:     qrcode,
+		GraceUntil: account.PreviousSecretExpiresAt,
+	}, nil
+}
+
+// ValidateWithMatch validates a token like Validate and reports which secret
+// it matched. The previous secret is discarded once its grace period is over
+// at the given unix timestamp, or as soon as the current secret matched.
+func (account *Account) ValidateWithMatch(token int, timestamp int64) (SecretMatch, error) {
+	if account.Status == AccountDisabled {
+		return MatchNone, ErrAccountDisabled
+	}
+
+	if len(account.PreviousSecret) > 0 && !time.Unix(timestamp, 0).Before(account.PreviousSecretExpiresAt) {
+		account.discardPreviousSecret()
+	}
+
+	counter, valid, err := account.matchTimeCounter(token, timestamp)
+	if err != nil {
+		return MatchNone, err
+	}
+	if valid {
+		account.discardPreviousSecret()
+		account.accept(counter)
+		return MatchCurrent, nil
+	}
+
+	if len(account.PreviousSecret) == 0 {
+		return MatchNone, nil
+	}
+	counter, valid, err = account.matchSecret(account.PreviousSecret, token, timestamp)
+	if err != nil || !valid {
+		return MatchNone, err
+	}
+	account.accept(counter)
+
+	return MatchPrevious, nil
+}
+
+// discardPreviousSecret ends the grace period of a rotation.
+func (account *Account) discardPreviousSecret() {
+	account.PreviousSecret = nil
+	account.PreviousSecretExpiresAt = time.Time{}
+}
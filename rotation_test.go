@@ -0,0 +1,98 @@
+package tinymfa_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+func TestRotateSecret(t *testing.T) {
+	oldSecret := append([]byte(nil), keySHA256...)
+	account := tinymfa.NewAccount("ACME", "alice", oldSecret)
+	account.Algorithm = tinymfa.SHA256
+	account.Status = tinymfa.AccountActive
+
+	rotation, err := account.RotateSecret(tinymfa.NewTinyMfa(), time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(account.Secret, oldSecret) || len(account.Secret) != int(tinymfa.KeySizeSHA256) {
+		t.Error("expected a new secret sized for SHA256")
+	}
+	if !bytes.Equal(account.PreviousSecret, oldSecret) {
+		t.Error("expected old secret to be kept as previous secret")
+	}
+	if len(rotation.QrCode) == 0 || rotation.Payload != account.BuildPayload() {
+		t.Error("expected qr code and payload of the new secret")
+	}
+
+	now := time.Now().Unix()
+	oldAccount := tinymfa.NewAccount("ACME", "alice", oldSecret)
+	oldAccount.Algorithm = tinymfa.SHA256
+	oldToken, _ := oldAccount.Generate(now)
+	newToken, _ := account.Generate(now)
+
+	match, err := account.ValidateWithMatch(oldToken, now)
+	if err != nil || match != tinymfa.MatchPrevious {
+		t.Errorf("expected old token to match previous secret, got %s, %v", match, err)
+	}
+
+	match, err = account.ValidateWithMatch(newToken, now)
+	if err != nil || match != tinymfa.MatchCurrent {
+		t.Errorf("expected new token to match current secret, got %s, %v", match, err)
+	}
+	if account.PreviousSecret != nil {
+		t.Error("expected previous secret to be discarded after the new secret was used")
+	}
+
+	if valid, _ := account.Validate(oldToken, now); valid {
+		t.Error("expected old token to be rejected after the new secret was used")
+	}
+}
+
+func TestRotateSecretGracePeriod(t *testing.T) {
+	oldSecret := append([]byte(nil), keySHA1...)
+	account := tinymfa.NewAccount("ACME", "alice", oldSecret)
+	if _, err := account.RotateSecret(tinymfa.NewTinyMfa(), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	later := time.Now().Add(2 * time.Minute).Unix()
+	oldAccount := tinymfa.NewAccount("ACME", "alice", oldSecret)
+	oldToken, _ := oldAccount.Generate(later)
+
+	match, err := account.ValidateWithMatch(oldToken, later)
+	if err != nil || match != tinymfa.MatchNone {
+		t.Errorf("expected old token to be rejected after the grace period, got %s, %v", match, err)
+	}
+	if account.PreviousSecret != nil {
+		t.Error("expected expired previous secret to be discarded")
+	}
+}
+
+func TestRotateSecretJSON(t *testing.T) {
+	account := tinymfa.NewAccount("ACME", "alice", append([]byte(nil), keySHA1...))
+	if _, err := account.RotateSecret(tinymfa.NewTinyMfa(), time.Hour); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	public, _ := json.Marshal(account)
+	if bytes.Contains(public, []byte(`"previous-secret"`)) {
+		t.Errorf("expected previous secret to be omitted, got %s", public)
+	}
+
+	private, err := account.MarshalJSONWithSecret()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded tinymfa.Account
+	if err := json.Unmarshal(private, &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(decoded.PreviousSecret, keySHA1) || !decoded.PreviousSecretExpiresAt.Equal(account.PreviousSecretExpiresAt) {
+		t.Errorf("expected rotation state to round trip, got %+v", decoded)
+	}
+}
@@ -0,0 +1,102 @@
+package tinymfa
+
+import (
+	"io"
+	"runtime"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// secretBufferKey returns the key held by a SecretBuffer in the form the
+// key based methods expect, without copying it. Callers must keep the buffer
+// alive with runtime.KeepAlive until the key is no longer used, since the
+// buffer is zeroed when it is garbage collected.
+func secretBufferKey(secret *utils.SecretBuffer) (*[]byte, error) {
+	if secret == nil || secret.Destroyed() {
+		return nil, utils.ErrSecretDestroyed
+	}
+	key := secret.Bytes()
+	return &key, nil
+}
+
+// GenerateSecretBufferForAlgorithm works like GenerateSecretKeyForAlgorithm,
+// but reads the key straight into a locked SecretBuffer. The caller must
+// Destroy the buffer once the key is no longer needed.
+func (tinymfa *TinyMfa) GenerateSecretBufferForAlgorithm(algorithm HashAlgorithm) (*utils.SecretBuffer, error) {
+	size, err := keySizeForAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.NewSecretBuffer(int(size))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(tinymfa.entropy(), secret.Bytes()); err != nil {
+		secret.Destroy()
+		return nil, err
+	}
+	if err := tinymfa.KeyPolicy.Validate(secret.Bytes(), algorithm); err != nil {
+		secret.Destroy()
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// GenerateTokenWithSecretBuffer works like GenerateToken with the key held by a SecretBuffer.
+func (tinymfa *TinyMfa) GenerateTokenWithSecretBuffer(unixTimestamp int64, secret *utils.SecretBuffer, offsetType uint8, tokenlength uint8, algorithm HashAlgorithm, timeStep int64, t0 int64) (int, error) {
+	key, err := secretBufferKey(secret)
+	if err != nil {
+		return 0, err
+	}
+	token, err := tinymfa.GenerateToken(unixTimestamp, key, offsetType, tokenlength, algorithm, timeStep, t0)
+	runtime.KeepAlive(secret)
+	return token, err
+}
+
+// ValidateTokenWithSecretBuffer works like ValidateToken with the key held by a SecretBuffer.
+func (tinymfa *TinyMfa) ValidateTokenWithSecretBuffer(token int, secret *utils.SecretBuffer, unixTimestamp int64, tokenlength uint8, algorithm HashAlgorithm, timeStep int64, t0 int64) (bool, error) {
+	key, err := secretBufferKey(secret)
+	if err != nil {
+		return false, err
+	}
+	valid, err := tinymfa.ValidateToken(token, key, unixTimestamp, tokenlength, algorithm, timeStep, t0)
+	runtime.KeepAlive(secret)
+	return valid, err
+}
+
+// GenerateCounterTokenWithSecretBuffer works like GenerateCounterToken with the key held by a SecretBuffer.
+func (tinymfa *TinyMfa) GenerateCounterTokenWithSecretBuffer(counter uint64, secret *utils.SecretBuffer, tokenlength uint8, algorithm HashAlgorithm) (int, error) {
+	key, err := secretBufferKey(secret)
+	if err != nil {
+		return 0, err
+	}
+	token, err := tinymfa.GenerateCounterToken(counter, key, tokenlength, algorithm)
+	runtime.KeepAlive(secret)
+	return token, err
+}
+
+// ValidateCounterTokenWithSecretBuffer works like ValidateCounterToken with the key held by a SecretBuffer.
+func (tinymfa *TinyMfa) ValidateCounterTokenWithSecretBuffer(token int, secret *utils.SecretBuffer, counter uint64, lookAhead uint64, tokenlength uint8, algorithm HashAlgorithm) (uint64, bool, error) {
+	key, err := secretBufferKey(secret)
+	if err != nil {
+		return counter, false, err
+	}
+	next, valid, err := tinymfa.ValidateCounterToken(token, key, counter, lookAhead, tokenlength, algorithm)
+	runtime.KeepAlive(secret)
+	return next, valid, err
+}
+
+// GenerateQrCodeWithSecretBuffer works like GenerateQrCode with the key held
+// by a SecretBuffer. Note that the payload encoded in the QR code necessarily
+// contains the secret as an ordinary string.
+func (tinymfa *TinyMfa) GenerateQrCodeWithSecretBuffer(issuer, user string, secret *utils.SecretBuffer, digits uint8, algorithm HashAlgorithm, timeStep int64) ([]byte, error) {
+	key, err := secretBufferKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	encoded := utils.EncodeBase32Secret(*key, false)
+	runtime.KeepAlive(secret)
+	return tinymfa.GenerateQrCode(issuer, user, &encoded, digits, algorithm, timeStep)
+}
@@ -0,0 +1,57 @@
+package tinymfa_test
+
+import (
+	"errors"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestSecretBufferTokens(t *testing.T) {
+	key := append([]byte(nil), keySHA1...)
+	secret, err := utils.NewSecretBufferFromBytes(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer secret.Destroy()
+
+	// RFC 6238 Appendix B, T = 59
+	token, err := tmfa.GenerateTokenWithSecretBuffer(59, secret, tinymfa.Present, 8, tinymfa.SHA1, 30, 0)
+	if err != nil || token != 94287082 {
+		t.Errorf("expected 94287082, got %d, %v", token, err)
+	}
+	if valid, err := tmfa.ValidateTokenWithSecretBuffer(94287082, secret, 59, 8, tinymfa.SHA1, 30, 0); !valid || err != nil {
+		t.Errorf("expected token to validate, got %v", err)
+	}
+
+	// RFC 4226 Appendix D, count = 1
+	if token, err := tmfa.GenerateCounterTokenWithSecretBuffer(1, secret, 6, tinymfa.SHA1); err != nil || token != 287082 {
+		t.Errorf("expected 287082, got %d, %v", token, err)
+	}
+	if next, valid, err := tmfa.ValidateCounterTokenWithSecretBuffer(287082, secret, 0, 2, 6, tinymfa.SHA1); !valid || next != 2 || err != nil {
+		t.Errorf("expected counter token to validate, got %d, %v, %v", next, valid, err)
+	}
+
+	if png, err := tmfa.GenerateQrCodeWithSecretBuffer("ACME", "alice", secret, 6, tinymfa.SHA1, 30); err != nil || len(png) == 0 {
+		t.Errorf("expected QR code, got %v", err)
+	}
+
+	secret.Destroy()
+	if _, err := tmfa.GenerateTokenWithSecretBuffer(59, secret, tinymfa.Present, 8, tinymfa.SHA1, 30, 0); !errors.Is(err, utils.ErrSecretDestroyed) {
+		t.Errorf("expected ErrSecretDestroyed, got %v", err)
+	}
+}
+
+func TestGenerateSecretBufferForAlgorithm(t *testing.T) {
+	for algorithm, size := range map[tinymfa.HashAlgorithm]int{tinymfa.SHA1: 20, tinymfa.SHA256: 32, tinymfa.SHA512: 64} {
+		secret, err := tmfa.GenerateSecretBufferForAlgorithm(algorithm)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if secret.Len() != size {
+			t.Errorf("%s: expected %d bytes, got %d", algorithm, size, secret.Len())
+		}
+		secret.Destroy()
+	}
+}
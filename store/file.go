@@ -0,0 +1,226 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+const (
+	// fileStoreVersion is the version of the JSON document written by
+	// FileStore. Documents of this version are always bound to fileStoreContext.
+	fileStoreVersion = 2
+
+	// fileStoreVersionUnbound is the version of documents written before the
+	// context was introduced. Only these may be decrypted without it.
+	fileStoreVersionUnbound = 1
+)
+
+// fileStoreContext is the associated data of the encrypted document. It keeps
+// other ciphertexts encrypted with the same key from being passed off as a store.
+var fileStoreContext = []byte("go-tiny-mfa/store/file")
+
+// fileStoreDocument is the plaintext layout of a FileStore before encryption.
+type fileStoreDocument struct {
+	Version int       `json:"version"`
+	Records []*Record `json:"records"`
+}
+
+// FileStore is a SecretStore that persists all records as a single JSON
+// document, encrypted at rest with a utils.Keyring. The whole document is
+// rewritten on every change, which makes it a good fit for small to medium
+// numbers of accounts.
+type FileStore struct {
+	mutex    sync.Mutex
+	filePath string
+	keyring  *utils.Keyring
+	memory   *MemoryStore
+}
+
+// NewFileStore opens the encrypted store at filePath, creating it on the first
+// write if it does not exist yet. The key must be a 16 or 32 byte AES key.
+func NewFileStore(filePath string, key *[]byte) (SecretStore, error) {
+	if key == nil {
+		return nil, utils.ErrInvalidKeySize
+	}
+	keyring, err := utils.NewKeyring("", *key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFileStoreWithKeyring(filePath, keyring)
+}
+
+// NewFileStoreWithKeyring opens the encrypted store at filePath. The document
+// is encrypted with the primary key of the keyring and may be decrypted with
+// any of its keys.
+func NewFileStoreWithKeyring(filePath string, keyring *utils.Keyring) (SecretStore, error) {
+	store := &FileStore{
+		filePath: filePath,
+		keyring:  keyring,
+		memory:   newMemoryStore(),
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// load reads and decrypts the document at filePath into memory.
+func (store *FileStore) load() error {
+	ciphertext, err := os.ReadFile(store.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := store.keyring.DecryptWithAssociatedData(&ciphertext, fileStoreContext)
+	bound := err == nil
+	if errors.Is(err, utils.ErrDecryptionFailed) {
+		// stores written before the context was introduced; they are
+		// bound to it on the next write
+		plaintext, err = store.keyring.Decrypt(&ciphertext)
+	}
+	if err != nil {
+		return fmt.Errorf("could not decrypt store %s: %w", store.filePath, err)
+	}
+
+	var document fileStoreDocument
+	err = json.Unmarshal(*plaintext, &document)
+	clear(*plaintext)
+	if err != nil {
+		return fmt.Errorf("could not parse store %s: %w", store.filePath, err)
+	}
+
+	switch {
+	case !bound && document.Version != fileStoreVersionUnbound:
+		return fmt.Errorf("could not decrypt store %s: %w", store.filePath, utils.ErrDecryptionFailed)
+	case document.Version != fileStoreVersion && document.Version != fileStoreVersionUnbound:
+		return fmt.Errorf("unsupported store version %d", document.Version)
+	}
+	for _, record := range document.Records {
+		store.memory.records[recordKey(record.Issuer, record.User)] = record
+	}
+
+	return nil
+}
+
+// persist encrypts all records and writes them to filePath.
+func (store *FileStore) persist() error {
+	records, err := store.memory.List()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(fileStoreDocument{Version: fileStoreVersion, Records: records})
+	if err != nil {
+		return err
+	}
+	defer clear(plaintext)
+
+	ciphertext, err := store.keyring.EncryptWithAssociatedData(&plaintext, fileStoreContext)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteFileAtomic(store.filePath, *ciphertext, 0600)
+}
+
+// Create stores a new record and persists the store.
+func (store *FileStore) Create(record *Record) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.memory.Create(record); err != nil {
+		return err
+	}
+	if err := store.persist(); err != nil {
+		store.memory.Delete(record.Issuer, record.User)
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the record for the given issuer and user.
+func (store *FileStore) Get(issuer, user string) (*Record, error) {
+	return store.memory.Get(issuer, user)
+}
+
+// Update replaces an existing record and persists the store.
+func (store *FileStore) Update(record *Record) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, err := store.memory.Get(record.Issuer, record.User)
+	if err != nil {
+		return err
+	}
+	if err := store.memory.Update(record); err != nil {
+		return err
+	}
+	if err := store.persist(); err != nil {
+		store.memory.restore(previous)
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the record for the given issuer and user and persists the store.
+func (store *FileStore) Delete(issuer, user string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, err := store.memory.Get(issuer, user)
+	if err != nil {
+		return err
+	}
+	if err := store.memory.Delete(issuer, user); err != nil {
+		return err
+	}
+	if err := store.persist(); err != nil {
+		store.memory.restore(previous)
+		return err
+	}
+
+	return nil
+}
+
+// List returns all records ordered by issuer and user.
+func (store *FileStore) List() ([]*Record, error) {
+	return store.memory.List()
+}
+
+// Reencrypt rewrites the store with the primary key of its keyring, unless it
+// already is encrypted with it and bound to the store context. It returns 1 if
+// the file was rewritten.
+func (store *FileStore) Reencrypt() (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	ciphertext, err := os.ReadFile(store.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !store.keyring.NeedsRewrap(ciphertext) {
+		if _, err := store.keyring.DecryptWithAssociatedData(&ciphertext, fileStoreContext); err == nil {
+			return 0, nil
+		}
+	}
+	if err := store.persist(); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
@@ -0,0 +1,167 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/store"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestFileStore(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.enc")
+	secretStore, err := store.NewFileStore(filePath, &storeKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	exerciseSecretStore(t, secretStore)
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(content, []byte("Example")) {
+		t.Errorf("expected store to be encrypted at rest")
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// reopening the store yields the persisted records
+	reopened, err := store.NewFileStore(filePath, &storeKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records, _ := reopened.List()
+	if len(records) != 2 {
+		t.Errorf("expected 2 records after reopening, got %d", len(records))
+	}
+	record, err := reopened.Get("Example", "bob")
+	if err != nil || string(record.Secret) != "secret" {
+		t.Errorf("expected persisted record, got %v, %v", record, err)
+	}
+}
+
+func TestFileStoreWrongKey(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.enc")
+	secretStore, _ := store.NewFileStore(filePath, &storeKey)
+	if err := secretStore.Create(store.NewRecord("ACME", "alice", []byte("secret"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wrongKey := []byte("fedcba9876543210fedcba9876543210")
+	if _, err := store.NewFileStore(filePath, &wrongKey); err == nil {
+		t.Error("expected error when opening store with the wrong key")
+	}
+
+	invalidKey := []byte("short")
+	if _, err := store.NewFileStore(filePath, &invalidKey); err == nil {
+		t.Error("expected error for invalid key size")
+	}
+}
+
+func TestFileStoreReencrypt(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.enc")
+	secretStore, _ := store.NewFileStore(filePath, &storeKey)
+	if err := secretStore.Create(store.NewRecord("ACME", "alice", []byte("secret"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	keyring, _ := utils.NewKeyring("", storeKey)
+	keyring.AddKey("2025", newKey)
+	keyring.SetPrimary("2025")
+
+	rotated, err := store.NewFileStoreWithKeyring(filePath, keyring)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rewritten, err := rotated.(store.Reencrypter).Reencrypt()
+	if err != nil || rewritten != 1 {
+		t.Fatalf("expected store to be rewritten, got %d, %v", rewritten, err)
+	}
+	if rewritten, _ := rotated.(store.Reencrypter).Reencrypt(); rewritten != 0 {
+		t.Errorf("expected nothing to rewrite, got %d", rewritten)
+	}
+
+	if _, err := store.NewFileStore(filePath, &storeKey); err == nil {
+		t.Error("expected the old key to no longer open the store")
+	}
+	newKeyring, _ := utils.NewKeyring("2025", newKey)
+	reopened, err := store.NewFileStoreWithKeyring(filePath, newKeyring)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if record, err := reopened.Get("ACME", "alice"); err != nil || string(record.Secret) != "secret" {
+		t.Errorf("expected record to survive rotation, got %v, %v", record, err)
+	}
+}
+
+func TestFileStoreUnboundDocument(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.enc")
+	document := []byte(`{"version":1,"records":[{"issuer":"ACME","user":"alice","secret":"c2VjcmV0","algorithm":0,"digits":6,"period":30}]}`)
+	ciphertext, err := utils.NewTinyMfaUtil().Encrypt(&document, &storeKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filePath, *ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secretStore, err := store.NewFileStore(filePath, &storeKey)
+	if err != nil {
+		t.Fatalf("expected document without context to load, got %v", err)
+	}
+	if record, err := secretStore.Get("ACME", "alice"); err != nil || string(record.Secret) != "secret" {
+		t.Fatalf("unexpected record %v, %v", record, err)
+	}
+
+	rewritten, err := secretStore.(store.Reencrypter).Reencrypt()
+	if err != nil || rewritten != 1 {
+		t.Errorf("expected document to be bound to the store context, got %d, %v", rewritten, err)
+	}
+	if rewritten, _ := secretStore.(store.Reencrypter).Reencrypt(); rewritten != 0 {
+		t.Errorf("expected nothing to rewrite, got %d", rewritten)
+	}
+
+	stored, _ := os.ReadFile(filePath)
+	if _, err := utils.NewTinyMfaUtil().Decrypt(&stored, &storeKey); err == nil {
+		t.Error("expected the document to require the store context")
+	}
+}
+
+func TestFileStoreRejectsUnboundCurrentDocument(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.enc")
+
+	// only documents of the legacy version may lack the store context
+	document := []byte(`{"version":2,"records":[]}`)
+	ciphertext, err := utils.NewTinyMfaUtil().Encrypt(&document, &storeKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(filePath, *ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.NewFileStore(filePath, &storeKey); !errors.Is(err, utils.ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed for an unbound document, got %v", err)
+	}
+
+	// documents encrypted with an unknown key are not retried without context
+	keyring, _ := utils.NewKeyring("2024", storeKey)
+	ciphertext, _ = keyring.EncryptWithAssociatedData(&document, []byte("go-tiny-mfa/store/file"))
+	if err := os.WriteFile(filePath, *ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := utils.NewKeyring("2025", storeKey)
+	if _, err := store.NewFileStoreWithKeyring(filePath, other); !errors.Is(err, utils.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
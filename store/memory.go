@@ -0,0 +1,131 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a SecretStore that keeps all records in memory. It is safe
+// for concurrent use and mostly useful for tests and short-lived processes.
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	records map[string]*Record
+}
+
+// NewMemoryStore returns an empty in-memory SecretStore.
+func NewMemoryStore() SecretStore {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+// Create stores a new record.
+func (store *MemoryStore) Create(record *Record) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := recordKey(record.Issuer, record.User)
+	if _, found := store.records[key]; found {
+		return ErrRecordExists
+	}
+
+	clone := record.Clone()
+	now := time.Now().UTC()
+	if clone.CreatedAt.IsZero() {
+		clone.CreatedAt = now
+	}
+	clone.UpdatedAt = now
+	store.records[key] = clone
+
+	return nil
+}
+
+// Get returns the record for the given issuer and user.
+func (store *MemoryStore) Get(issuer, user string) (*Record, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	record, found := store.records[recordKey(issuer, user)]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return record.Clone(), nil
+}
+
+// Update replaces an existing record.
+func (store *MemoryStore) Update(record *Record) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := recordKey(record.Issuer, record.User)
+	existing, found := store.records[key]
+	if !found {
+		return ErrRecordNotFound
+	}
+
+	clone := record.Clone()
+	clone.CreatedAt = existing.CreatedAt
+	clone.UpdatedAt = time.Now().UTC()
+	store.records[key] = clone
+
+	return nil
+}
+
+// Delete removes the record for the given issuer and user.
+func (store *MemoryStore) Delete(issuer, user string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := recordKey(issuer, user)
+	if _, found := store.records[key]; !found {
+		return ErrRecordNotFound
+	}
+	delete(store.records, key)
+
+	return nil
+}
+
+// List returns all records ordered by issuer and user.
+func (store *MemoryStore) List() ([]*Record, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	records := make([]*Record, 0, len(store.records))
+	for _, record := range store.records {
+		records = append(records, record.Clone())
+	}
+	sortRecords(records)
+
+	return records, nil
+}
+
+// sortRecords orders records by issuer and user.
+func sortRecords(records []*Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Issuer != records[j].Issuer {
+			return records[i].Issuer < records[j].Issuer
+		}
+		return records[i].User < records[j].User
+	})
+}
+
+// restore puts a previously read record back in place without touching its
+// timestamps. It is used to roll back changes that could not be persisted.
+func (store *MemoryStore) restore(record *Record) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.records[recordKey(record.Issuer, record.User)] = record
+}
@@ -0,0 +1,11 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/store"
+)
+
+func TestMemoryStore(t *testing.T) {
+	exerciseSecretStore(t, store.NewMemoryStore())
+}
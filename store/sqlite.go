@@ -0,0 +1,465 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/utils"
+	_ "modernc.org/sqlite" // pure Go SQLite driver
+)
+
+// ErrTokenReplayed is returned when a token is valid, but belongs to a time
+// step that has already been used for a successful validation.
+var ErrTokenReplayed = errors.New("token has already been used")
+
+// CounterStore is a SecretStore that can validate a token and advance the
+// replay counter of the record in a single atomic operation.
+type CounterStore interface {
+	SecretStore
+
+	// ValidateAndAdvance validates a token for the given account at the given
+	// unix timestamp. On success, the last used time counter of TOTP accounts,
+	// or the counter of HOTP accounts, is advanced, so that the same token
+	// cannot be used twice. A valid token for an already used time step, or
+	// the token of the last used HOTP counter value, yields ErrTokenReplayed.
+	// HOTP accounts ignore the timestamp.
+	ValidateAndAdvance(issuer, user string, token int, timestamp int64) (bool, error)
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// sqliteMigrations holds the schema migrations of the SQLite store. The
+// schema version is the index of the last applied migration plus one.
+// Migrations must never be changed once released, only appended.
+var sqliteMigrations = []string{
+	`CREATE TABLE accounts (
+		issuer       TEXT    NOT NULL,
+		user         TEXT    NOT NULL,
+		secret       BLOB    NOT NULL,
+		algorithm    INTEGER NOT NULL,
+		digits       INTEGER NOT NULL,
+		period       INTEGER NOT NULL,
+		t0           INTEGER NOT NULL,
+		counter      INTEGER NOT NULL DEFAULT 0,
+		last_counter INTEGER NOT NULL DEFAULT 0,
+		metadata     TEXT    NOT NULL DEFAULT '{}',
+		created_at   INTEGER NOT NULL,
+		updated_at   INTEGER NOT NULL,
+		PRIMARY KEY (issuer, user)
+	)`,
+	// secrets written from version 2 on are bound to their issuer and user
+	`ALTER TABLE accounts ADD COLUMN secret_bound INTEGER NOT NULL DEFAULT 0`,
+	// counter based accounts are validated against their counter
+	`ALTER TABLE accounts ADD COLUMN type INTEGER NOT NULL DEFAULT 0`,
+}
+
+// SQLiteStore is a CounterStore backed by a SQLite database. Secrets are
+// encrypted with a utils.Keyring before they are written to the database.
+type SQLiteStore struct {
+	db      *sql.DB
+	keyring *utils.Keyring
+	tmfa    tinymfa.TinyMfaInterface
+}
+
+// NewSQLiteStore opens or creates the SQLite database at filePath and applies
+// all pending schema migrations. The key must be a 16 or 32 byte AES key.
+func NewSQLiteStore(filePath string, key *[]byte) (CounterStore, error) {
+	if key == nil {
+		return nil, utils.ErrInvalidKeySize
+	}
+	keyring, err := utils.NewKeyring("", *key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSQLiteStoreWithKeyring(filePath, keyring)
+}
+
+// NewSQLiteStoreWithKeyring opens or creates the SQLite database at filePath.
+// Secrets are encrypted with the primary key of the keyring and may be
+// decrypted with any of its keys.
+func NewSQLiteStoreWithKeyring(filePath string, keyring *utils.Keyring) (CounterStore, error) {
+
+	// immediate transactions take the write lock up front, so concurrent
+	// validations of the same account are serialized instead of failing
+	// when upgrading a read lock.
+	dataSource := fmt.Sprintf("file:%s?_txlock=immediate&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", url.PathEscape(filePath))
+	db, err := sql.Open("sqlite", dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &SQLiteStore{
+		db:      db,
+		keyring: keyring,
+		tmfa:    tinymfa.NewTinyMfa(),
+	}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// migrate applies all migrations that have not been applied yet, each one in
+// its own transaction.
+func (store *SQLiteStore) migrate() error {
+	_, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	version, err := store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(sqliteMigrations))
+	}
+
+	for index := version; index < len(sqliteMigrations); index++ {
+		tx, err := store.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[index]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", index+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, index+1, time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied schema migration.
+func (store *SQLiteStore) SchemaVersion() (int, error) {
+	var version int
+	err := store.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Close closes the underlying database.
+func (store *SQLiteStore) Close() error {
+	return store.db.Close()
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const sqliteColumns = `issuer, user, secret, algorithm, digits, period, t0, counter, last_counter, metadata, created_at, updated_at, secret_bound, type`
+
+// scanRecord reads a single account row and decrypts its secret.
+func (store *SQLiteStore) scanRecord(row rowScanner) (*Record, error) {
+	var record Record
+	var ciphertext []byte
+	var counter int64
+	var metadata string
+	var createdAt, updatedAt int64
+	var bound bool
+
+	err := row.Scan(&record.Issuer, &record.User, &ciphertext, &record.Algorithm, &record.Digits,
+		&record.Period, &record.T0, &counter, &record.LastCounter, &metadata, &createdAt, &updatedAt, &bound, &record.Type)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := store.decryptSecret(record.Issuer, record.User, ciphertext, bound)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secret of %s/%s: %w", record.Issuer, record.User, err)
+	}
+	record.Secret = *secret
+	record.Counter = uint64(counter)
+	record.CreatedAt = time.Unix(0, createdAt).UTC()
+	record.UpdatedAt = time.Unix(0, updatedAt).UTC()
+	if err := json.Unmarshal([]byte(metadata), &record.Metadata); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// decryptSecret decrypts the secret of an account. Secrets written before
+// schema version 2 are not bound to their account yet; they are bound when
+// the record is updated next or when the store is re-encrypted.
+func (store *SQLiteStore) decryptSecret(issuer, user string, ciphertext []byte, bound bool) (*[]byte, error) {
+	if !bound {
+		return store.keyring.Decrypt(&ciphertext)
+	}
+	return store.keyring.DecryptWithAssociatedData(&ciphertext, secretAssociatedData(issuer, user))
+}
+
+// encodeRecord returns the encrypted secret and the serialized metadata of a
+// record. The secret is bound to the issuer and user of the record, so it
+// cannot be copied into another account.
+func (store *SQLiteStore) encodeRecord(record *Record) ([]byte, string, error) {
+	ciphertext, err := store.keyring.EncryptWithAssociatedData(&record.Secret, secretAssociatedData(record.Issuer, record.User))
+	if err != nil {
+		return nil, "", err
+	}
+
+	metadata := []byte("{}")
+	if len(record.Metadata) > 0 {
+		metadata, err = json.Marshal(record.Metadata)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return *ciphertext, string(metadata), nil
+}
+
+// Create stores a new record.
+func (store *SQLiteStore) Create(record *Record) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+
+	ciphertext, metadata, err := store.encodeRecord(record)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	createdAt := record.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+
+	result, err := store.db.Exec(`INSERT INTO accounts (`+sqliteColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?)
+		ON CONFLICT (issuer, user) DO NOTHING`,
+		record.Issuer, record.User, ciphertext, record.Algorithm, record.Digits, record.Period, record.T0,
+		int64(record.Counter), record.LastCounter, metadata, createdAt.UnixNano(), now.UnixNano(), record.Type)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, ErrRecordExists)
+}
+
+// Get returns the record for the given issuer and user.
+func (store *SQLiteStore) Get(issuer, user string) (*Record, error) {
+	row := store.db.QueryRow(`SELECT `+sqliteColumns+` FROM accounts WHERE issuer = ? AND user = ?`, issuer, user)
+	return store.scanRecord(row)
+}
+
+// Update replaces an existing record.
+func (store *SQLiteStore) Update(record *Record) error {
+	if err := record.validate(); err != nil {
+		return err
+	}
+
+	ciphertext, metadata, err := store.encodeRecord(record)
+	if err != nil {
+		return err
+	}
+
+	result, err := store.db.Exec(`UPDATE accounts SET secret = ?, secret_bound = 1, type = ?, algorithm = ?, digits = ?, period = ?, t0 = ?,
+		counter = ?, last_counter = ?, metadata = ?, updated_at = ? WHERE issuer = ? AND user = ?`,
+		ciphertext, record.Type, record.Algorithm, record.Digits, record.Period, record.T0, int64(record.Counter),
+		record.LastCounter, metadata, time.Now().UTC().UnixNano(), record.Issuer, record.User)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, ErrRecordNotFound)
+}
+
+// Delete removes the record for the given issuer and user.
+func (store *SQLiteStore) Delete(issuer, user string) error {
+	result, err := store.db.Exec(`DELETE FROM accounts WHERE issuer = ? AND user = ?`, issuer, user)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(result, ErrRecordNotFound)
+}
+
+// List returns all records ordered by issuer and user.
+func (store *SQLiteStore) List() ([]*Record, error) {
+	rows, err := store.db.Query(`SELECT ` + sqliteColumns + ` FROM accounts ORDER BY issuer, user`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := make([]*Record, 0)
+	for rows.Next() {
+		record, err := store.scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// ValidateAndAdvance validates a token and advances the last used time counter
+// or the HOTP counter of the account within a single transaction.
+func (store *SQLiteStore) ValidateAndAdvance(issuer, user string, token int, timestamp int64) (bool, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`SELECT `+sqliteColumns+` FROM accounts WHERE issuer = ? AND user = ?`, issuer, user)
+	record, err := store.scanRecord(row)
+	if err != nil {
+		return false, err
+	}
+	if record.Type == tinymfa.HOTP {
+		return store.validateAndAdvanceCounter(tx, record, token)
+	}
+
+	counter, valid, err := matchTimeCounter(store.tmfa, record, token, timestamp)
+	if err != nil || !valid {
+		return false, err
+	}
+	if counter <= record.LastCounter {
+		return false, ErrTokenReplayed
+	}
+
+	_, err = tx.Exec(`UPDATE accounts SET last_counter = ?, updated_at = ? WHERE issuer = ? AND user = ?`,
+		counter, time.Now().UTC().UnixNano(), issuer, user)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// validateAndAdvanceCounter validates the token of a HOTP account within the
+// look-ahead window and stores the next counter value in the transaction.
+func (store *SQLiteStore) validateAndAdvanceCounter(tx *sql.Tx, record *Record, token int) (bool, error) {
+	next, valid, err := store.tmfa.ValidateCounterToken(token, &record.Secret, record.Counter, tinymfa.DefaultLookAhead, record.Digits, record.Algorithm)
+	if err != nil {
+		return false, err
+	}
+	if !valid {
+		if record.Counter > 0 {
+			previous, err := store.tmfa.GenerateCounterToken(record.Counter-1, &record.Secret, record.Digits, record.Algorithm)
+			if err == nil && previous == token {
+				return false, ErrTokenReplayed
+			}
+		}
+		return false, nil
+	}
+
+	_, err = tx.Exec(`UPDATE accounts SET counter = ?, updated_at = ? WHERE issuer = ? AND user = ?`,
+		int64(next), time.Now().UTC().UnixNano(), record.Issuer, record.User)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// expectAffected returns err if the statement did not change any row.
+func expectAffected(result sql.Result, err error) error {
+	affected, resultErr := result.RowsAffected()
+	if resultErr != nil {
+		return resultErr
+	}
+	if affected == 0 {
+		return err
+	}
+	return nil
+}
+
+// Reencrypt rewraps every secret that is not encrypted with the primary key of
+// the keyring or not yet bound to its account, within a single transaction,
+// and returns how many were rewritten.
+func (store *SQLiteStore) Reencrypt() (int, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT issuer, user, secret, secret_bound FROM accounts`)
+	if err != nil {
+		return 0, err
+	}
+
+	type rewrapped struct {
+		issuer, user string
+		secret       []byte
+	}
+	var pending []rewrapped
+	for rows.Next() {
+		var entry rewrapped
+		var ciphertext []byte
+		var bound bool
+		if err := rows.Scan(&entry.issuer, &entry.user, &ciphertext, &bound); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		secret, changed, err := store.rewrapSecret(entry.issuer, entry.user, ciphertext, bound)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not rewrap secret of %s/%s: %w", entry.issuer, entry.user, err)
+		}
+		if changed {
+			entry.secret = *secret
+			pending = append(pending, entry)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, entry := range pending {
+		_, err := tx.Exec(`UPDATE accounts SET secret = ?, secret_bound = 1 WHERE issuer = ? AND user = ?`, entry.secret, entry.issuer, entry.user)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(pending), tx.Commit()
+}
+
+// rewrapSecret returns the secret of an account encrypted with the primary key
+// and bound to the account, reporting whether it had to be changed.
+func (store *SQLiteStore) rewrapSecret(issuer, user string, ciphertext []byte, bound bool) (*[]byte, bool, error) {
+	associatedData := secretAssociatedData(issuer, user)
+	if bound {
+		return store.keyring.RewrapWithAssociatedData(&ciphertext, associatedData)
+	}
+
+	secret, err := store.keyring.Decrypt(&ciphertext)
+	if err != nil {
+		return nil, false, err
+	}
+	defer clear(*secret)
+
+	rewrapped, err := store.keyring.EncryptWithAssociatedData(secret, associatedData)
+	if err != nil {
+		return nil, false, err
+	}
+	return rewrapped, true, nil
+}
@@ -0,0 +1,286 @@
+package store_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/store"
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func openSQLiteStore(t *testing.T, filePath string) store.CounterStore {
+	t.Helper()
+	counterStore, err := store.NewSQLiteStore(filePath, &storeKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { counterStore.Close() })
+	return counterStore
+}
+
+func TestSQLiteStore(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.db")
+	exerciseSecretStore(t, openSQLiteStore(t, filePath))
+
+	// secrets must never reach the database in plain text
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	var ciphertext []byte
+	if err := db.QueryRow(`SELECT secret FROM accounts WHERE issuer = 'Example' AND user = 'bob'`).Scan(&ciphertext); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte("secret")) {
+		t.Error("expected secret to be encrypted in the database")
+	}
+}
+
+func TestSQLiteStoreMigrations(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.db")
+	first := openSQLiteStore(t, filePath)
+	if err := first.Create(store.NewRecord("ACME", "alice", []byte("secret"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first.Close()
+
+	// reopening must not re-apply migrations or lose data
+	second := openSQLiteStore(t, filePath)
+	version, err := second.(*store.SQLiteStore).SchemaVersion()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != 3 {
+		t.Errorf("expected schema version 3, got %d", version)
+	}
+	if _, err := second.Get("ACME", "alice"); err != nil {
+		t.Errorf("expected record to survive reopening, got %v", err)
+	}
+
+	wrongKey := []byte("fedcba9876543210")
+	wrong, err := store.NewSQLiteStore(filePath, &wrongKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wrong.Close()
+	if _, err := wrong.Get("ACME", "alice"); err == nil {
+		t.Error("expected error when decrypting with the wrong key")
+	}
+}
+
+func TestSQLiteStoreValidateAndAdvance(t *testing.T) {
+	counterStore := openSQLiteStore(t, filepath.Join(t.TempDir(), "accounts.db"))
+	secret := []byte("12345678901234567890")
+	if err := counterStore.Create(store.NewRecord("ACME", "alice", secret)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tmfa := tinymfa.NewTinyMfa()
+	timestamp := int64(1234567890)
+	token, _ := tmfa.GenerateToken(timestamp, &secret, tinymfa.Present, 6, tinymfa.SHA1, tinymfa.DefaultTimeStep, tinymfa.DefaultT0)
+
+	valid, err := counterStore.ValidateAndAdvance("ACME", "alice", (token+1)%1000000, timestamp)
+	if err != nil || valid {
+		t.Errorf("expected wrong token to be rejected, got %v, %v", valid, err)
+	}
+
+	// concurrent logins with the same token must succeed exactly once
+	var wait sync.WaitGroup
+	var mutex sync.Mutex
+	successes, replays := 0, 0
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			valid, err := counterStore.ValidateAndAdvance("ACME", "alice", token, timestamp)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if valid {
+				successes++
+			} else if errors.Is(err, store.ErrTokenReplayed) {
+				replays++
+			} else {
+				t.Errorf("unexpected result %v, %v", valid, err)
+			}
+		}()
+	}
+	wait.Wait()
+	if successes != 1 || replays != 9 {
+		t.Errorf("expected 1 success and 9 replays, got %d and %d", successes, replays)
+	}
+
+	record, _ := counterStore.Get("ACME", "alice")
+	expected, _ := tmfa.GenerateMessage(timestamp, tinymfa.Present, tinymfa.DefaultTimeStep, tinymfa.DefaultT0)
+	if record.LastCounter != expected {
+		t.Errorf("expected last counter %d, got %d", expected, record.LastCounter)
+	}
+
+	// the token of the previous step is older than the last used step
+	past, _ := tmfa.GenerateToken(timestamp, &secret, tinymfa.Past, 6, tinymfa.SHA1, tinymfa.DefaultTimeStep, tinymfa.DefaultT0)
+	if _, err := counterStore.ValidateAndAdvance("ACME", "alice", past, timestamp); !errors.Is(err, store.ErrTokenReplayed) {
+		t.Errorf("expected ErrTokenReplayed for an older step, got %v", err)
+	}
+
+	if _, err := counterStore.ValidateAndAdvance("ACME", "bob", token, timestamp); !errors.Is(err, store.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestSQLiteStoreValidateAndAdvanceHOTP(t *testing.T) {
+	counterStore := openSQLiteStore(t, filepath.Join(t.TempDir(), "accounts.db"))
+	secret := []byte("12345678901234567890")
+	record := store.NewRecord("ACME", "alice", secret)
+	record.Type = tinymfa.HOTP
+	record.Counter = 2
+	if err := counterStore.Create(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tmfa := tinymfa.NewTinyMfa()
+	token, _ := tmfa.GenerateCounterToken(4, &secret, 6, tinymfa.SHA1)
+
+	// concurrent logins with the same token must succeed exactly once
+	var wait sync.WaitGroup
+	var mutex sync.Mutex
+	successes, failures := 0, 0
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			valid, err := counterStore.ValidateAndAdvance("ACME", "alice", token, 0)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if valid {
+				successes++
+			} else if errors.Is(err, store.ErrTokenReplayed) {
+				failures++
+			} else {
+				t.Errorf("unexpected result %v, %v", valid, err)
+			}
+		}()
+	}
+	wait.Wait()
+	if successes != 1 || failures != 9 {
+		t.Errorf("expected 1 success and 9 replays, got %d and %d", successes, failures)
+	}
+
+	stored, _ := counterStore.Get("ACME", "alice")
+	if stored.Type != tinymfa.HOTP || stored.Counter != 5 {
+		t.Errorf("expected a HOTP record with counter 5, got %s and %d", stored.Type, stored.Counter)
+	}
+
+	// tokens of skipped counter values are no longer accepted
+	skipped, _ := tmfa.GenerateCounterToken(3, &secret, 6, tinymfa.SHA1)
+	if valid, err := counterStore.ValidateAndAdvance("ACME", "alice", skipped, 0); valid || err != nil {
+		t.Errorf("expected a skipped token to be rejected, got %v, %v", valid, err)
+	}
+	next, _ := tmfa.GenerateCounterToken(5, &secret, 6, tinymfa.SHA1)
+	if valid, err := counterStore.ValidateAndAdvance("ACME", "alice", next, 0); !valid || err != nil {
+		t.Errorf("expected the next token to validate, got %v, %v", valid, err)
+	}
+}
+
+func TestSQLiteStoreReencrypt(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.db")
+	counterStore := openSQLiteStore(t, filePath)
+	for _, user := range []string{"alice", "bob"} {
+		if err := counterStore.Create(store.NewRecord("ACME", user, []byte("secret-"+user))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	counterStore.Close()
+
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	keyring, _ := utils.NewKeyring("", storeKey)
+	keyring.AddKey("2025", newKey)
+	keyring.SetPrimary("2025")
+
+	rotated, err := store.NewSQLiteStoreWithKeyring(filePath, keyring)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer rotated.Close()
+
+	rewritten, err := rotated.(store.Reencrypter).Reencrypt()
+	if err != nil || rewritten != 2 {
+		t.Fatalf("expected 2 rewritten secrets, got %d, %v", rewritten, err)
+	}
+	if rewritten, _ := rotated.(store.Reencrypter).Reencrypt(); rewritten != 0 {
+		t.Errorf("expected nothing to rewrite, got %d", rewritten)
+	}
+
+	keyring.RemoveKey("")
+	records, err := rotated.List()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected records to decrypt with the new key only, got %v", err)
+	}
+	if string(records[0].Secret) != "secret-alice" {
+		t.Errorf("unexpected secret %q", records[0].Secret)
+	}
+}
+
+func TestSQLiteStoreSecretsAreBoundToAccount(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.db")
+	counterStore := openSQLiteStore(t, filePath)
+	counterStore.Create(store.NewRecord("ACME", "alice", []byte("alice-secret")))
+	counterStore.Create(store.NewRecord("ACME", "mallory", []byte("mallory-secret")))
+
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	// copy mallory's encrypted secret into alice's record
+	_, err = db.Exec(`UPDATE accounts SET secret = (SELECT secret FROM accounts WHERE user = 'mallory') WHERE user = 'alice'`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := counterStore.Get("ACME", "alice"); err == nil {
+		t.Error("expected a secret copied from another account to fail decryption")
+	}
+}
+
+func TestSQLiteStoreUnboundSecrets(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "accounts.db")
+	counterStore := openSQLiteStore(t, filePath)
+	counterStore.Create(store.NewRecord("ACME", "alice", []byte("alice-secret")))
+
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	// a secret written before secrets were bound to their account
+	secret := []byte("legacy-secret")
+	ciphertext, _ := utils.NewTinyMfaUtil().Encrypt(&secret, &storeKey)
+	_, err = db.Exec(`UPDATE accounts SET secret = ?, secret_bound = 0 WHERE user = 'alice'`, *ciphertext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	record, err := counterStore.Get("ACME", "alice")
+	if err != nil || string(record.Secret) != "legacy-secret" {
+		t.Fatalf("expected unbound secret to decrypt, got %v", err)
+	}
+
+	rewritten, err := counterStore.(store.Reencrypter).Reencrypt()
+	if err != nil || rewritten != 1 {
+		t.Fatalf("expected the unbound secret to be rewritten, got %d, %v", rewritten, err)
+	}
+	var bound bool
+	db.QueryRow(`SELECT secret_bound FROM accounts WHERE user = 'alice'`).Scan(&bound)
+	if !bound {
+		t.Error("expected secret to be bound after re-encryption")
+	}
+	if record, err := counterStore.Get("ACME", "alice"); err != nil || string(record.Secret) != "legacy-secret" {
+		t.Errorf("expected bound secret to decrypt, got %v", err)
+	}
+}
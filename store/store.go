@@ -0,0 +1,139 @@
+package store
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"time"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+)
+
+var (
+	// ErrRecordNotFound is returned when no record exists for an issuer and user.
+	ErrRecordNotFound = errors.New("record not found")
+
+	// ErrRecordExists is returned when a record for an issuer and user already exists.
+	ErrRecordExists = errors.New("record already exists")
+
+	// ErrInvalidRecord is returned when a record lacks a user or a secret, or
+	// when its issuer or user contain a NUL character.
+	ErrInvalidRecord = errors.New("record requires a user and a secret and no NUL in issuer or user")
+)
+
+// Record holds everything that is needed to generate and validate tokens
+// for a single account, identified by its issuer and user.
+type Record struct {
+	Issuer    string                `json:"issuer"`
+	User      string                `json:"user"`
+	Type      tinymfa.OtpType       `json:"type"`
+	Secret    []byte                `json:"secret"`
+	Algorithm tinymfa.HashAlgorithm `json:"algorithm"`
+	Digits    uint8                 `json:"digits"`
+	Period    int64                 `json:"period"`
+	T0        int64                 `json:"t0"`
+
+	// Counter is the moving factor of counter based (HOTP) accounts, the
+	// counter value that the next token must use.
+	Counter uint64 `json:"counter"`
+
+	// LastCounter is the time counter of the last accepted token. It is
+	// used to reject replayed tokens of time based (TOTP) accounts.
+	LastCounter int64 `json:"last-counter"`
+
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created-at"`
+	UpdatedAt time.Time         `json:"updated-at"`
+}
+
+// NewRecord returns a record for the given issuer and user with the default
+// TOTP parameters of RFC 6238 (SHA-1, 6 digits, 30 second time step, T0 = 0).
+func NewRecord(issuer, user string, secret []byte) *Record {
+	return &Record{
+		Issuer:    issuer,
+		User:      user,
+		Secret:    secret,
+		Algorithm: tinymfa.SHA1,
+		Digits:    6,
+		Period:    tinymfa.DefaultTimeStep,
+		T0:        tinymfa.DefaultT0,
+	}
+}
+
+// Clone returns a deep copy of the record, so that callers cannot modify
+// the state held by a store.
+func (record *Record) Clone() *Record {
+	clone := *record
+	clone.Secret = append([]byte(nil), record.Secret...)
+	clone.Metadata = maps.Clone(record.Metadata)
+	return &clone
+}
+
+// validate checks that the record can be persisted.
+func (record *Record) validate() error {
+	if record == nil || record.User == "" || len(record.Secret) == 0 {
+		return ErrInvalidRecord
+	}
+	// NUL separates issuer and user in recordKey
+	if strings.Contains(record.Issuer, "\x00") || strings.Contains(record.User, "\x00") {
+		return ErrInvalidRecord
+	}
+	return nil
+}
+
+// SecretStore persists account records keyed by issuer and user.
+type SecretStore interface {
+	// Create stores a new record. It fails with ErrRecordExists if a record
+	// for the same issuer and user is already present.
+	Create(record *Record) error
+
+	// Get returns the record for the given issuer and user.
+	Get(issuer, user string) (*Record, error)
+
+	// Update replaces an existing record.
+	Update(record *Record) error
+
+	// Delete removes the record for the given issuer and user.
+	Delete(issuer, user string) error
+
+	// List returns all records ordered by issuer and user.
+	List() ([]*Record, error)
+}
+
+// Reencrypter is implemented by stores that encrypt their data with a
+// utils.Keyring. After a new primary key has been set on the keyring,
+// Reencrypt rewraps all data that is still encrypted with an older key and
+// returns how many ciphertexts were rewritten. Afterwards the old key can be
+// removed from the keyring.
+type Reencrypter interface {
+	Reencrypt() (int, error)
+}
+
+// secretAssociatedData returns the associated data that binds an encrypted
+// secret to its issuer and user.
+func secretAssociatedData(issuer, user string) []byte {
+	return []byte("go-tiny-mfa/store/secret\x00" + recordKey(issuer, user))
+}
+
+// recordKey builds the map key of a record. validate rejects issuers and
+// users that contain the NUL separator, so distinct pairs never collide.
+func recordKey(issuer, user string) string {
+	return issuer + "\x00" + user
+}
+
+// matchTimeCounter checks the token against the present, past and future time
+// steps of the record and returns the time counter of the matching step.
+func matchTimeCounter(tmfa tinymfa.TinyMfaInterface, record *Record, token int, timestamp int64) (int64, bool, error) {
+	for _, offsetType := range []uint8{tinymfa.Present, tinymfa.Past, tinymfa.Future} {
+		generated, err := tmfa.GenerateToken(timestamp, &record.Secret, offsetType, record.Digits, record.Algorithm, record.Period, record.T0)
+		if err != nil {
+			return 0, false, err
+		}
+		if generated == token {
+			counter, err := tmfa.GenerateMessage(timestamp, offsetType, record.Period, record.T0)
+			return counter, err == nil, err
+		}
+	}
+
+	return 0, false, nil
+}
@@ -0,0 +1,96 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	tinymfa "github.com/ghmer/go-tiny-mfa"
+	"github.com/ghmer/go-tiny-mfa/store"
+)
+
+var storeKey = []byte("0123456789abcdef0123456789abcdef")
+
+// exerciseSecretStore runs the behaviour every SecretStore implementation shares.
+func exerciseSecretStore(t *testing.T, secretStore store.SecretStore) {
+	t.Helper()
+
+	record := store.NewRecord("ACME", "alice", []byte("12345678901234567890"))
+	record.Metadata = map[string]string{"device": "phone"}
+	if err := secretStore.Create(record); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := secretStore.Create(record); !errors.Is(err, store.ErrRecordExists) {
+		t.Errorf("expected ErrRecordExists, got %v", err)
+	}
+	if err := secretStore.Create(store.NewRecord("ACME", "", nil)); !errors.Is(err, store.ErrInvalidRecord) {
+		t.Errorf("expected ErrInvalidRecord, got %v", err)
+	}
+	for _, invalid := range []*store.Record{
+		store.NewRecord("ACME\x00alice", "bob", record.Secret),
+		store.NewRecord("ACME", "alice\x00bob", record.Secret),
+	} {
+		if err := secretStore.Create(invalid); !errors.Is(err, store.ErrInvalidRecord) {
+			t.Errorf("expected ErrInvalidRecord for %q/%q, got %v", invalid.Issuer, invalid.User, err)
+		}
+	}
+
+	got, err := secretStore.Get("ACME", "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got.Secret, record.Secret) || got.Algorithm != tinymfa.SHA1 || got.Digits != 6 {
+		t.Errorf("unexpected record %+v", got)
+	}
+	if got.CreatedAt.IsZero() || got.Metadata["device"] != "phone" {
+		t.Errorf("expected timestamps and metadata to be kept, got %+v", got)
+	}
+
+	// modifying a returned record must not change the stored one
+	got.Secret[0] = 'X'
+	got.Metadata["device"] = "token"
+	again, _ := secretStore.Get("ACME", "alice")
+	if again.Secret[0] != '1' || again.Metadata["device"] != "phone" {
+		t.Errorf("expected store to hand out copies")
+	}
+
+	again.LastCounter = 41152263
+	again.Digits = 8
+	if err := secretStore.Update(again); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, _ := secretStore.Get("ACME", "alice")
+	if updated.LastCounter != 41152263 || updated.Digits != 8 {
+		t.Errorf("expected update to be stored, got %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(got.CreatedAt) {
+		t.Errorf("expected creation time to be kept")
+	}
+	if err := secretStore.Update(store.NewRecord("ACME", "bob", []byte("x"))); !errors.Is(err, store.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+
+	if err := secretStore.Create(store.NewRecord("Example", "bob", []byte("secret"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := secretStore.Create(store.NewRecord("ACME", "bob", []byte("secret"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records, err := secretStore.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 || records[0].User != "alice" || records[1].User != "bob" || records[2].Issuer != "Example" {
+		t.Errorf("expected records ordered by issuer and user, got %d records", len(records))
+	}
+
+	if err := secretStore.Delete("ACME", "alice"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := secretStore.Get("ACME", "alice"); !errors.Is(err, store.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+	if err := secretStore.Delete("ACME", "alice"); !errors.Is(err, store.ErrRecordNotFound) {
+		t.Errorf("expected ErrRecordNotFound, got %v", err)
+	}
+}
@@ -11,11 +11,13 @@ import (
 	"fmt"
 	"hash"
 	"image/color"
+	"io"
 	"math"
 	"strings"
 	"time"
 
 	"github.com/ghmer/go-tiny-mfa/structs"
+	"github.com/ghmer/go-tiny-mfa/utils"
 	"github.com/skip2/go-qrcode"
 )
 
@@ -81,6 +83,10 @@ type TinyMfaInterface interface {
 	// for the specified hash algorithm per RFC 6238 Section 4.
 	GenerateSecretKeyForAlgorithm(algorithm HashAlgorithm) (*[]byte, error)
 
+	// DeriveSecretKeyForAlgorithm derives a per-user secret key with the recommended size
+	// for the specified hash algorithm from a master key with HKDF (RFC 5869).
+	DeriveSecretKeyForAlgorithm(masterKey []byte, userID string, algorithm HashAlgorithm, version uint32) (*[]byte, error)
+
 	// GenerateMessageBytes takes in a int64 number and turns it to a BigEndian byte array.
 	GenerateMessageBytes(message int64) ([]byte, error)
 
@@ -96,6 +102,13 @@ type TinyMfaInterface interface {
 	// time step, and epoch offset (RFC 6238 Section 4.2).
 	GenerateToken(unixTimestamp int64, key *[]byte, offsetType uint8, tokenlength uint8, algorithm HashAlgorithm, timeStep int64, t0 int64) (int, error)
 
+	// GenerateCounterToken generates a HOTP token per RFC 4226 for the given counter value.
+	GenerateCounterToken(counter uint64, key *[]byte, tokenlength uint8, algorithm HashAlgorithm) (int, error)
+
+	// ValidateCounterToken validates a HOTP token against a look-ahead window of counter
+	// values (RFC 4226 Section 7.4) and returns the counter value for the next token.
+	ValidateCounterToken(token int, key *[]byte, counter uint64, lookAhead uint64, tokenlength uint8, algorithm HashAlgorithm) (uint64, bool, error)
+
 	// ValidateToken validates a submitted TOTP token with configurable algorithm
 	// and time parameters per RFC 6238 Section 5.2.
 	ValidateToken(token int, key *[]byte, unixTimestamp int64, tokenlength uint8, algorithm HashAlgorithm, timeStep int64, t0 int64) (bool, error)
@@ -111,6 +124,9 @@ type TinyMfaInterface interface {
 	// GenerateQrCode generates a QRCode for the provided issuer, user and secret with specified algorithm and timeStep.
 	GenerateQrCode(issuer, user string, secret *string, digits uint8, algorithm HashAlgorithm, timeStep int64) ([]byte, error)
 
+	// GenerateQrCodeFromPayload generates a QRCode for an already built otpauth:// payload.
+	GenerateQrCodeFromPayload(payload string) ([]byte, error)
+
 	// ConvertColorSetting converts the ColorSetting struct into a color.Color object.
 	ConvertColorSetting(setting structs.ColorSetting) color.Color
 
@@ -120,11 +136,53 @@ type TinyMfaInterface interface {
 	// BuildPayload builds the otpauth:// URL payload for QR code generation with specified algorithm and timeStep.
 	BuildPayload(issuer, username string, secret *string, digits uint8, algorithm HashAlgorithm, timeStep int64) string
 
+	// DecodeQrCode locates and decodes the QR symbol in a PNG or JPEG image and returns its text content.
+	DecodeQrCode(imageData []byte) (string, error)
+
+	// ReadQrCodeImage reads a PNG or JPEG image from the filesystem and decodes the QR symbol it contains.
+	ReadQrCodeImage(filePath string) (string, error)
+
+	// ImportQrCode decodes a QR code image and parses its otpauth:// or otpauth-migration:// payload.
+	ImportQrCode(imageData []byte) ([]KeyDescription, error)
+
+	// ParsePayload parses an otpauth:// URL or an otpauth-migration:// export into key descriptions.
+	ParsePayload(payload string) ([]KeyDescription, error)
+
 	// SetQRCodeConfig sets the QRCodeConfig for the QRCode.
 	SetQRCodeConfig(qrcodeConfig structs.QrCodeConfig)
 
 	// GetQRCodeConfig returns the current QRCodeConfig for the QRCode.
 	GetQRCodeConfig() structs.QrCodeConfig
+
+	// SetEntropySource sets the reader secret keys are generated from. A nil reader restores crypto/rand.
+	SetEntropySource(entropy io.Reader)
+
+	// SetKeyPolicy sets the KeyPolicy enforced when generating and importing secret keys.
+	SetKeyPolicy(policy KeyPolicy)
+
+	// GetKeyPolicy returns the current KeyPolicy.
+	GetKeyPolicy() KeyPolicy
+
+	// ValidateSecretKey checks a secret key used with the given hash algorithm against the current KeyPolicy.
+	ValidateSecretKey(key *[]byte, algorithm HashAlgorithm) error
+
+	// GenerateSecretBufferForAlgorithm generates a secret key for the algorithm into a locked utils.SecretBuffer.
+	GenerateSecretBufferForAlgorithm(algorithm HashAlgorithm) (*utils.SecretBuffer, error)
+
+	// GenerateTokenWithSecretBuffer works like GenerateToken with the key held by a utils.SecretBuffer.
+	GenerateTokenWithSecretBuffer(unixTimestamp int64, secret *utils.SecretBuffer, offsetType uint8, tokenlength uint8, algorithm HashAlgorithm, timeStep int64, t0 int64) (int, error)
+
+	// ValidateTokenWithSecretBuffer works like ValidateToken with the key held by a utils.SecretBuffer.
+	ValidateTokenWithSecretBuffer(token int, secret *utils.SecretBuffer, unixTimestamp int64, tokenlength uint8, algorithm HashAlgorithm, timeStep int64, t0 int64) (bool, error)
+
+	// GenerateCounterTokenWithSecretBuffer works like GenerateCounterToken with the key held by a utils.SecretBuffer.
+	GenerateCounterTokenWithSecretBuffer(counter uint64, secret *utils.SecretBuffer, tokenlength uint8, algorithm HashAlgorithm) (int, error)
+
+	// ValidateCounterTokenWithSecretBuffer works like ValidateCounterToken with the key held by a utils.SecretBuffer.
+	ValidateCounterTokenWithSecretBuffer(token int, secret *utils.SecretBuffer, counter uint64, lookAhead uint64, tokenlength uint8, algorithm HashAlgorithm) (uint64, bool, error)
+
+	// GenerateQrCodeWithSecretBuffer works like GenerateQrCode with the key held by a utils.SecretBuffer.
+	GenerateQrCodeWithSecretBuffer(issuer, user string, secret *utils.SecretBuffer, digits uint8, algorithm HashAlgorithm, timeStep int64) ([]byte, error)
 }
 
 // Validation is a struct used to return the result of a token validation
@@ -136,11 +194,16 @@ type Validation struct {
 
 type TinyMfa struct {
 	QRCodeConfig structs.QrCodeConfig
+	// Entropy is the source of secret keys. It defaults to crypto/rand.
+	Entropy   io.Reader
+	KeyPolicy KeyPolicy
 }
 
 func NewTinyMfa() TinyMfaInterface {
 	return &TinyMfa{
 		QRCodeConfig: structs.StandardQrCodeConfig(),
+		Entropy:      rand.Reader,
+		KeyPolicy:    DefaultKeyPolicy(),
 	}
 }
 
@@ -166,9 +229,22 @@ func (tinymfa *TinyMfa) GenerateSecretKey(size int8) (*[]byte, error) {
 		return nil, fmt.Errorf("invalid secret key size: %d (valid sizes: %d, %d, %d)", size, KeySizeSHA1, KeySizeSHA256, KeySizeSHA512)
 	}
 	key := make([]byte, size)
-	_, err := rand.Read(key)
+	if _, err := io.ReadFull(tinymfa.entropy(), key); err != nil {
+		return nil, err
+	}
+	if err := tinymfa.KeyPolicy.ValidateKey(key); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
 
-	return &key, err
+// entropy returns the configured entropy source, falling back to crypto/rand.
+func (tinymfa *TinyMfa) entropy() io.Reader {
+	if tinymfa.Entropy == nil {
+		return rand.Reader
+	}
+	return tinymfa.Entropy
 }
 
 // GenerateSecretKeyForAlgorithm generates a cryptographically random secret key with the
@@ -179,16 +255,20 @@ func (tinymfa *TinyMfa) GenerateSecretKey(size int8) (*[]byte, error) {
 //   - SHA-256: 32 bytes (256 bits)
 //   - SHA-512: 64 bytes (512 bits)
 func (tinymfa *TinyMfa) GenerateSecretKeyForAlgorithm(algorithm HashAlgorithm) (*[]byte, error) {
-	switch algorithm {
-	case SHA1:
-		return tinymfa.GenerateSecretKey(KeySizeSHA1)
-	case SHA256:
-		return tinymfa.GenerateSecretKey(KeySizeSHA256)
-	case SHA512:
-		return tinymfa.GenerateSecretKey(KeySizeSHA512)
-	default:
-		return nil, fmt.Errorf("unsupported hash algorithm: %d", algorithm)
+	size, err := keySizeForAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := tinymfa.GenerateSecretKey(size)
+	if err != nil {
+		return nil, err
+	}
+	if err := tinymfa.KeyPolicy.Validate(*key, algorithm); err != nil {
+		return nil, err
 	}
+
+	return key, nil
 }
 
 // GenerateMessageBytes takes in a int64 number and turns it to a BigEndian byte array
@@ -279,6 +359,15 @@ func (tinymfa *TinyMfa) GenerateToken(unixTimestamp int64, key *[]byte, offsetTy
 		return 0, err
 	}
 
+	return tinymfa.computeToken(message, key, tokenlength, algorithm)
+}
+
+// computeToken computes the HMAC of the 8-byte moving factor and reduces it
+// to a token of the requested length:
+//  1. Compute HMAC using the selected algorithm (RFC 2104)
+//  2. Apply dynamic truncation (RFC 4226 Section 5.3)
+//  3. Reduce to the requested number of digits (RFC 4226 Section 5.4)
+func (tinymfa *TinyMfa) computeToken(message []byte, key *[]byte, tokenlength uint8, algorithm HashAlgorithm) (int, error) {
 	rfc2104hmac, err := tinymfa.CalculateHMAC(message, key, algorithm)
 	if err != nil {
 		return 0, err
@@ -347,6 +436,37 @@ func (tinymfa *TinyMfa) ValidateToken(token int, key *[]byte, unixTimestamp int6
 	return false, nil
 }
 
+// GenerateCounterToken generates a HOTP token per RFC 4226 for the given counter
+// value. The counter is the 8-byte moving factor of RFC 4226 Section 5.2.
+// Supported token lengths are 5-8 digits. Supported algorithms are SHA1, SHA256, SHA512.
+func (tinymfa *TinyMfa) GenerateCounterToken(counter uint64, key *[]byte, tokenlength uint8, algorithm HashAlgorithm) (int, error) {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+
+	return tinymfa.computeToken(message, key, tokenlength, algorithm)
+}
+
+// ValidateCounterToken validates a submitted HOTP token against the counter values
+// counter to counter+lookAhead, as recommended by RFC 4226 Section 7.4 to
+// resynchronize with tokens that were generated without being submitted.
+// On success, it returns the counter value that the next token must use.
+// The window ends before math.MaxUint64, since no next counter value would
+// follow it.
+func (tinymfa *TinyMfa) ValidateCounterToken(token int, key *[]byte, counter uint64, lookAhead uint64, tokenlength uint8, algorithm HashAlgorithm) (uint64, bool, error) {
+	for i := uint64(0); i <= lookAhead && counter+i < math.MaxUint64; i++ {
+		current := counter + i
+		generatedToken, err := tinymfa.GenerateCounterToken(current, key, tokenlength, algorithm)
+		if err != nil {
+			return counter, false, err
+		}
+		if generatedToken == token {
+			return current + 1, true, nil
+		}
+	}
+
+	return counter, false, nil
+}
+
 // ValidateTokenCurrentTimestamp validates a submitted TOTP token against the current
 // Unix timestamp using the specified algorithm and time parameters. This is a convenience
 // wrapper around ValidateToken that captures the current system time.
@@ -375,10 +495,16 @@ func (tinymfa *TinyMfa) ValidateTokenWithTimestamp(token int, key *[]byte, times
 
 // GenerateQrCode Generates a QRCode of the totp url with specified algorithm and timeStep
 func (tinymfa *TinyMfa) GenerateQrCode(issuer, user string, secret *string, digits uint8, algorithm HashAlgorithm, timeStep int64) ([]byte, error) {
+	otpauthURL := tinymfa.BuildPayload(issuer, user, secret, digits, algorithm, timeStep)
+	return tinymfa.GenerateQrCodeFromPayload(otpauthURL)
+}
+
+// GenerateQrCodeFromPayload Generates a QRCode of an already built otpauth:// payload,
+// for example one of a HOTP account
+func (tinymfa *TinyMfa) GenerateQrCodeFromPayload(payload string) ([]byte, error) {
 	var png []byte
 
-	otpauthURL := tinymfa.BuildPayload(issuer, user, secret, digits, algorithm, timeStep)
-	code, err := qrcode.New(otpauthURL, qrcode.Medium)
+	code, err := qrcode.New(payload, qrcode.Medium)
 	if err != nil {
 		return nil, err
 	}
@@ -409,10 +535,10 @@ func (tinymfa *TinyMfa) WriteQrCodeImage(issuer, user string, secret *string, di
 
 // BuildPayload builds the otpauth:// URL payload for QR code generation with specified algorithm and timeStep.
 func (tinymfa *TinyMfa) BuildPayload(issuer, username string, secret *string, digits uint8, algorithm HashAlgorithm, timeStep int64) string {
-	index := strings.Index(*secret, "=")
-	mySecret := *secret
-	if index != -1 {
-		mySecret = strings.TrimSuffix(*secret, "=")
+	// authenticator apps expect the secret without padding, in upper case
+	mySecret, err := utils.NormalizeBase32(*secret)
+	if err != nil {
+		mySecret = strings.TrimRight(*secret, "=")
 	}
 
 	// Determine algorithm string for the URL
@@ -430,7 +556,6 @@ func (tinymfa *TinyMfa) BuildPayload(issuer, username string, secret *string, di
 
 	formatString := "otpauth://totp/%s:%s@%s?algorithm=%s&digits=%d&issuer=%s&period=%d&secret=%s"
 	otpauthURL := fmt.Sprintf(formatString, issuer, username, issuer, algoStr, digits, issuer, timeStep, mySecret)
-	mySecret = ""
 
 	return otpauthURL
 }
@@ -444,3 +569,28 @@ func (tinymfa *TinyMfa) GetQRCodeConfig() structs.QrCodeConfig {
 func (tinymfa *TinyMfa) SetQRCodeConfig(qrcodeConfig structs.QrCodeConfig) {
 	tinymfa.QRCodeConfig = qrcodeConfig
 }
+
+// SetEntropySource sets the reader secret keys are generated from, for example
+// a deterministic reader for test fixtures. A nil reader restores crypto/rand.
+func (tinymfa *TinyMfa) SetEntropySource(entropy io.Reader) {
+	tinymfa.Entropy = entropy
+}
+
+// SetKeyPolicy sets the KeyPolicy enforced when generating and importing secret keys.
+func (tinymfa *TinyMfa) SetKeyPolicy(policy KeyPolicy) {
+	tinymfa.KeyPolicy = policy
+}
+
+// GetKeyPolicy returns the current KeyPolicy.
+func (tinymfa *TinyMfa) GetKeyPolicy() KeyPolicy {
+	return tinymfa.KeyPolicy
+}
+
+// ValidateSecretKey checks a secret key used with the given hash algorithm
+// against the current KeyPolicy.
+func (tinymfa *TinyMfa) ValidateSecretKey(key *[]byte, algorithm HashAlgorithm) error {
+	if key == nil {
+		return ErrKeyTooShort
+	}
+	return tinymfa.KeyPolicy.Validate(*key, algorithm)
+}
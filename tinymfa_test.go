@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -470,3 +471,66 @@ func TestGetQRCodeConfig(t *testing.T) {
 		t.Error("expected non-zero QR code config")
 	}
 }
+
+// RFC 4226 Appendix D expected 6-digit HOTP values for counters 0-9
+var rfcExpectedHOTP = []int{755224, 287082, 359152, 969429, 338314, 254676, 287922, 162583, 399871, 520489}
+
+func TestGenerateCounterToken(t *testing.T) {
+	for counter, expected := range rfcExpectedHOTP {
+		token, err := tmfa.GenerateCounterToken(uint64(counter), &keySHA1, 6, tinymfa.SHA1)
+		if err != nil {
+			t.Fatalf("unexpected error for counter %d: %v", counter, err)
+		}
+		if token != expected {
+			t.Errorf("counter %d: expected %d, got %d", counter, expected, token)
+		}
+	}
+
+	_, err := tmfa.GenerateCounterToken(0, &keySHA1, 9, tinymfa.SHA1)
+	if err == nil {
+		t.Error("expected error for token length 9, got nil")
+	}
+}
+
+func TestValidateCounterToken(t *testing.T) {
+	next, valid, err := tmfa.ValidateCounterToken(rfcExpectedHOTP[0], &keySHA1, 0, 0, 6, tinymfa.SHA1)
+	if err != nil || !valid || next != 1 {
+		t.Errorf("expected counter 0 to validate with next counter 1, got %d, %v, %v", next, valid, err)
+	}
+
+	// a token within the look-ahead window resynchronizes the counter
+	next, valid, err = tmfa.ValidateCounterToken(rfcExpectedHOTP[5], &keySHA1, 2, 3, 6, tinymfa.SHA1)
+	if err != nil || !valid || next != 6 {
+		t.Errorf("expected counter 5 to validate with next counter 6, got %d, %v, %v", next, valid, err)
+	}
+
+	// a token beyond the look-ahead window is rejected
+	next, valid, err = tmfa.ValidateCounterToken(rfcExpectedHOTP[9], &keySHA1, 2, 3, 6, tinymfa.SHA1)
+	if err != nil || valid || next != 2 {
+		t.Errorf("expected counter 9 to be rejected, got %d, %v, %v", next, valid, err)
+	}
+}
+
+func TestValidateCounterTokenOverflow(t *testing.T) {
+	counter := uint64(math.MaxUint64 - 1)
+	token, err := tmfa.GenerateCounterToken(counter, &keySHA1, 6, tinymfa.SHA1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next, valid, err := tmfa.ValidateCounterToken(token, &keySHA1, counter, tinymfa.DefaultLookAhead, 6, tinymfa.SHA1)
+	if err != nil || !valid || next != math.MaxUint64 {
+		t.Errorf("expected the token to validate with next counter %d, got %d, %v, %v", uint64(math.MaxUint64), next, valid, err)
+	}
+
+	// the window ends before math.MaxUint64 instead of wrapping around
+	last, err := tmfa.GenerateCounterToken(math.MaxUint64, &keySHA1, 6, tinymfa.SHA1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next, valid, err := tmfa.ValidateCounterToken(last, &keySHA1, counter, math.MaxUint64, 6, tinymfa.SHA1); err != nil || valid || next != counter {
+		t.Errorf("expected the last counter value to be rejected, got %d, %v, %v", next, valid, err)
+	}
+	if _, valid, err := tmfa.ValidateCounterToken(rfcExpectedHOTP[0], &keySHA1, math.MaxUint64, tinymfa.DefaultLookAhead, 6, tinymfa.SHA1); err != nil || valid {
+		t.Errorf("expected no token to validate at the last counter value, got %v, %v", valid, err)
+	}
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EnvelopeMagic marks data encrypted by Encrypt. Data without it is treated
+// as a legacy blob that consists of the raw nonce and the GCM ciphertext.
+var EnvelopeMagic = []byte("TMFA")
+
+const (
+	// EnvelopeVersion1 is the first envelope format, which has no key derivation block.
+	EnvelopeVersion1 uint8 = 1
+
+	// EnvelopeVersion2 adds a key derivation block for passphrase-based encryption.
+	EnvelopeVersion2 uint8 = 2
+
+	// EnvelopeVersion is the envelope version written by this package.
+	EnvelopeVersion = EnvelopeVersion2
+)
+
+// CipherSuite identifies the authenticated cipher used inside an envelope.
+type CipherSuite uint8
+
+const (
+	// SuiteAESGCM selects AES-GCM with a 128 or 256 bit key and a 96 bit nonce.
+	SuiteAESGCM CipherSuite = iota + 1
+	// SuiteXChaCha20Poly1305 selects XChaCha20-Poly1305 with a 256 bit key and a
+	// 192 bit nonce. It is fast without AES hardware support, and its random
+	// nonces are large enough to never collide in practice.
+	SuiteXChaCha20Poly1305
+)
+
+// String returns the name of the CipherSuite.
+func (suite CipherSuite) String() string {
+	switch suite {
+	case SuiteAESGCM:
+		return "AES-GCM"
+	case SuiteXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("CipherSuite(%d)", uint8(suite))
+	}
+}
+
+// KeyDerivation identifies how the encryption key of an envelope is obtained.
+type KeyDerivation uint8
+
+const (
+	// KDFNone marks envelopes that were encrypted with a raw key.
+	KDFNone KeyDerivation = iota
+	// KDFArgon2id marks envelopes whose key was derived from a passphrase with Argon2id.
+	KDFArgon2id
+	// KDFKMS marks envelopes whose key is a data key that was wrapped by a key
+	// management service. The envelope carries the wrapped data key and the id
+	// of the KMS key that wrapped it.
+	KDFKMS
+)
+
+// String returns the name of the KeyDerivation.
+func (kdf KeyDerivation) String() string {
+	switch kdf {
+	case KDFNone:
+		return "none"
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFKMS:
+		return "kms"
+	default:
+		return fmt.Sprintf("KeyDerivation(%d)", uint8(kdf))
+	}
+}
+
+var (
+	// ErrNoEnvelope is returned by ParseEnvelope when data does not start with EnvelopeMagic.
+	ErrNoEnvelope = errors.New("data is not an envelope")
+
+	// ErrUnsupportedEnvelope is returned for envelopes of an unknown version or cipher suite.
+	ErrUnsupportedEnvelope = errors.New("unsupported envelope")
+
+	// ErrMalformedEnvelope is returned when an envelope header is truncated.
+	ErrMalformedEnvelope = errors.New("malformed envelope")
+
+	// ErrKMSEnvelope is returned when an envelope whose data key was wrapped
+	// by a key management service is decrypted with a raw key or a
+	// passphrase. Such envelopes are decrypted with a kms.Encrypter, which in
+	// turn rejects all other data with kms.ErrNotKMSEnvelope.
+	ErrKMSEnvelope = errors.New("envelope was encrypted with a kms data key")
+)
+
+// Envelope is the self-describing container produced by Encrypt. Its binary
+// layout is:
+//
+//	magic "TMFA" | version (1) | suite (1) | key id length (1) | key id |
+//	key derivation (1) | key derivation parameters | nonce length (1) |
+//	nonce | ciphertext
+//
+// The key derivation byte and its parameters are only present from version 2
+// on. For Argon2id the parameters are time (4), memory in KiB (4), threads (1),
+// salt length (1) and salt, with integers in big endian. For KMS data keys
+// they are KMS key id length (1), KMS key id, wrapped key length (2) and
+// wrapped key. The header up to and including the nonce is authenticated as
+// additional data, so it cannot be changed without failing decryption.
+type Envelope struct {
+	Version    uint8
+	Suite      CipherSuite
+	KeyID      string
+	KDF        KeyDerivation
+	Argon2     Argon2Params
+	Salt       []byte
+	KMSKeyID   string
+	WrappedKey []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// envelopeReader consumes an envelope header field by field.
+type envelopeReader struct {
+	data []byte
+	err  error
+}
+
+// next returns the next n bytes, or nil once the data is exhausted.
+func (reader *envelopeReader) next(n int) []byte {
+	if reader.err != nil || len(reader.data) < n {
+		reader.err = ErrMalformedEnvelope
+		return nil
+	}
+	field := reader.data[:n]
+	reader.data = reader.data[n:]
+	return field
+}
+
+// byte returns the next single byte.
+func (reader *envelopeReader) byte() byte {
+	if field := reader.next(1); field != nil {
+		return field[0]
+	}
+	return 0
+}
+
+// uint16 returns the next big endian uint16.
+func (reader *envelopeReader) uint16() uint16 {
+	if field := reader.next(2); field != nil {
+		return binary.BigEndian.Uint16(field)
+	}
+	return 0
+}
+
+// uint32 returns the next big endian uint32.
+func (reader *envelopeReader) uint32() uint32 {
+	if field := reader.next(4); field != nil {
+		return binary.BigEndian.Uint32(field)
+	}
+	return 0
+}
+
+// ParseEnvelope splits data into its envelope header and ciphertext. The
+// returned slices share memory with data.
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	if !bytes.HasPrefix(data, EnvelopeMagic) {
+		return nil, ErrNoEnvelope
+	}
+	reader := &envelopeReader{data: data[len(EnvelopeMagic):]}
+
+	envelope := &Envelope{Version: reader.byte()}
+	if reader.err == nil && envelope.Version != EnvelopeVersion1 && envelope.Version != EnvelopeVersion2 {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedEnvelope, envelope.Version)
+	}
+	envelope.Suite = CipherSuite(reader.byte())
+	envelope.KeyID = string(reader.next(int(reader.byte())))
+
+	if envelope.Version >= EnvelopeVersion2 {
+		envelope.KDF = KeyDerivation(reader.byte())
+		switch envelope.KDF {
+		case KDFNone:
+		case KDFArgon2id:
+			envelope.Argon2.Time = reader.uint32()
+			envelope.Argon2.Memory = reader.uint32()
+			envelope.Argon2.Threads = reader.byte()
+			envelope.Salt = reader.next(int(reader.byte()))
+		case KDFKMS:
+			envelope.KMSKeyID = string(reader.next(int(reader.byte())))
+			envelope.WrappedKey = reader.next(int(reader.uint16()))
+		default:
+			if reader.err == nil {
+				return nil, fmt.Errorf("%w: key derivation %s", ErrUnsupportedEnvelope, envelope.KDF)
+			}
+		}
+	}
+
+	envelope.Nonce = reader.next(int(reader.byte()))
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	envelope.Ciphertext = reader.data
+
+	return envelope, nil
+}
+
+// Header returns the encoded envelope header, which is also used as the
+// additional authenticated data of the ciphertext.
+func (envelope *Envelope) Header() []byte {
+	header := make([]byte, 0, len(EnvelopeMagic)+16+len(envelope.KeyID)+len(envelope.Salt)+len(envelope.KMSKeyID)+len(envelope.WrappedKey)+len(envelope.Nonce))
+	header = append(header, EnvelopeMagic...)
+	header = append(header, envelope.Version, byte(envelope.Suite), byte(len(envelope.KeyID)))
+	header = append(header, envelope.KeyID...)
+	if envelope.Version >= EnvelopeVersion2 {
+		header = append(header, byte(envelope.KDF))
+		switch envelope.KDF {
+		case KDFArgon2id:
+			header = binary.BigEndian.AppendUint32(header, envelope.Argon2.Time)
+			header = binary.BigEndian.AppendUint32(header, envelope.Argon2.Memory)
+			header = append(header, envelope.Argon2.Threads, byte(len(envelope.Salt)))
+			header = append(header, envelope.Salt...)
+		case KDFKMS:
+			header = append(header, byte(len(envelope.KMSKeyID)))
+			header = append(header, envelope.KMSKeyID...)
+			header = binary.BigEndian.AppendUint16(header, uint16(len(envelope.WrappedKey)))
+			header = append(header, envelope.WrappedKey...)
+		}
+	}
+	header = append(header, byte(len(envelope.Nonce)))
+	header = append(header, envelope.Nonce...)
+	return header
+}
+
+// Marshal returns the binary form of the envelope.
+func (envelope *Envelope) Marshal() []byte {
+	return append(envelope.Header(), envelope.Ciphertext...)
+}
+
+// Seal encrypts plaintext with key, which the caller obtained as described
+// by the key derivation of the envelope, and returns the marshalled
+// envelope. A fresh nonce is generated; header and associated data are
+// authenticated. Use it to build envelopes with keys from outside this
+// package, such as KMS data keys.
+func (envelope *Envelope) Seal(plaintext, key, associatedData []byte) ([]byte, error) {
+	if len(envelope.KeyID) > 255 || len(envelope.KMSKeyID) > 255 || len(envelope.WrappedKey) > 65535 {
+		return nil, fmt.Errorf("%w: header field too long", ErrMalformedEnvelope)
+	}
+	sealed, err := sealEnvelope(envelope, plaintext, key, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return *sealed, nil
+}
+
+// Open decrypts the ciphertext of a parsed envelope with key.
+func (envelope *Envelope) Open(key, associatedData []byte) ([]byte, error) {
+	return openEnvelope(envelope, key, associatedData)
+}
@@ -0,0 +1,307 @@
+package utils_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestEncryptWithKeyID(t *testing.T) {
+	encrypted, err := util.EncryptWithKeyID(&data, &passphrase, "key-2024")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	envelope, err := utils.ParseEnvelope(*encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if envelope.Version != utils.EnvelopeVersion {
+		t.Errorf("expected version %d, got %d", utils.EnvelopeVersion, envelope.Version)
+	}
+	if envelope.Suite != utils.SuiteAESGCM {
+		t.Errorf("expected suite %s, got %s", utils.SuiteAESGCM, envelope.Suite)
+	}
+	if envelope.KeyID != "key-2024" {
+		t.Errorf("expected key id key-2024, got %s", envelope.KeyID)
+	}
+	if len(envelope.Nonce) != 12 {
+		t.Errorf("expected a 12 byte nonce, got %d", len(envelope.Nonce))
+	}
+
+	decrypted, err := util.Decrypt(encrypted, &passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(*decrypted) != string(data) {
+		t.Errorf("expected decrypted data to match original data")
+	}
+}
+
+func TestDecryptRejectsModifiedHeader(t *testing.T) {
+	encrypted, err := util.EncryptWithKeyID(&data, &passphrase, "old")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	envelope, err := utils.ParseEnvelope(*encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	envelope.KeyID = "new"
+	modified := envelope.Marshal()
+
+	if _, err := util.Decrypt(&modified, &passphrase); err == nil {
+		t.Error("expected error for modified header")
+	}
+}
+
+func TestDecryptLegacy(t *testing.T) {
+	block, _ := aes.NewCipher(passphrase)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	legacy := gcm.Seal(nonce, nonce, data, nil)
+
+	decrypted, err := util.Decrypt(&legacy, &passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(*decrypted) != string(data) {
+		t.Errorf("expected decrypted data to match original data")
+	}
+
+	short := []byte{1, 2, 3}
+	if _, err := util.Decrypt(&short, &passphrase); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func TestParseEnvelopeInvalid(t *testing.T) {
+	if _, err := utils.ParseEnvelope([]byte("nope")); !errors.Is(err, utils.ErrNoEnvelope) {
+		t.Errorf("expected ErrNoEnvelope, got %v", err)
+	}
+	if _, err := utils.ParseEnvelope([]byte("TMFA\x01")); !errors.Is(err, utils.ErrMalformedEnvelope) {
+		t.Errorf("expected ErrMalformedEnvelope, got %v", err)
+	}
+	if _, err := utils.ParseEnvelope([]byte("TMFA\x01\x01\x09abc")); !errors.Is(err, utils.ErrMalformedEnvelope) {
+		t.Errorf("expected ErrMalformedEnvelope, got %v", err)
+	}
+	if _, err := utils.ParseEnvelope([]byte("TMFA\x09\x01\x00\x00")); !errors.Is(err, utils.ErrUnsupportedEnvelope) {
+		t.Errorf("expected ErrUnsupportedEnvelope, got %v", err)
+	}
+
+	unknownSuite := (&utils.Envelope{Version: utils.EnvelopeVersion, Suite: 99, Nonce: make([]byte, 12)}).Marshal()
+	if _, err := util.Decrypt(&unknownSuite, &passphrase); !errors.Is(err, utils.ErrUnsupportedEnvelope) {
+		t.Errorf("expected ErrUnsupportedEnvelope, got %v", err)
+	}
+}
+
+func TestDecryptEnvelopeVersion1(t *testing.T) {
+	block, _ := aes.NewCipher(passphrase)
+	gcm, _ := cipher.NewGCM(block)
+	envelope := &utils.Envelope{
+		Version: utils.EnvelopeVersion1,
+		Suite:   utils.SuiteAESGCM,
+		KeyID:   "v1",
+		Nonce:   make([]byte, gcm.NonceSize()),
+	}
+	rand.Read(envelope.Nonce)
+	envelope.Ciphertext = gcm.Seal(nil, envelope.Nonce, data, envelope.Header())
+	encrypted := envelope.Marshal()
+
+	parsed, err := utils.ParseEnvelope(encrypted)
+	if err != nil || parsed.KeyID != "v1" || parsed.KDF != utils.KDFNone {
+		t.Fatalf("expected version 1 envelope to parse, got %+v, %v", parsed, err)
+	}
+	decrypted, err := util.Decrypt(&encrypted, &passphrase)
+	if err != nil || string(*decrypted) != string(data) {
+		t.Errorf("expected version 1 envelope to decrypt, got %v", err)
+	}
+}
+
+func TestEncryptWithAssociatedData(t *testing.T) {
+	encrypted, err := util.EncryptWithAssociatedData(&data, &passphrase, []byte("ACME/alice"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, associatedData := range [][]byte{nil, []byte("ACME/bob")} {
+		copied := append([]byte(nil), *encrypted...)
+		if _, err := util.DecryptWithAssociatedData(&copied, &passphrase, associatedData); err == nil {
+			t.Errorf("expected error for associated data %q", associatedData)
+		}
+	}
+	copied := append([]byte(nil), *encrypted...)
+	if _, err := util.Decrypt(&copied, &passphrase); err == nil {
+		t.Error("expected error when decrypting without associated data")
+	}
+
+	decrypted, err := util.DecryptWithAssociatedData(encrypted, &passphrase, []byte("ACME/alice"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(*decrypted) != string(data) {
+		t.Errorf("expected decrypted data to match original data")
+	}
+
+	// legacy data has no associated data and must not satisfy a binding
+	block, _ := aes.NewCipher(passphrase)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	legacy := gcm.Seal(nonce, nonce, data, nil)
+	if _, err := util.DecryptWithAssociatedData(&legacy, &passphrase, []byte("ACME/alice")); err == nil {
+		t.Error("expected legacy data to be rejected when associated data is expected")
+	}
+}
+
+func TestKeyringAssociatedData(t *testing.T) {
+	keyring, _ := utils.NewKeyring("v1", keyV1)
+	encrypted, err := keyring.EncryptWithAssociatedData(&data, []byte("alice"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := keyring.DecryptWithAssociatedData(encrypted, []byte("bob")); err == nil {
+		t.Error("expected error for other associated data")
+	}
+
+	keyring.AddKey("v2", keyV2)
+	keyring.SetPrimary("v2")
+	rewrapped, changed, err := keyring.RewrapWithAssociatedData(encrypted, []byte("alice"))
+	if err != nil || !changed {
+		t.Fatalf("expected ciphertext to be rewrapped, got %v", err)
+	}
+	plaintext, err := keyring.DecryptWithAssociatedData(rewrapped, []byte("alice"))
+	if err != nil || string(*plaintext) != string(data) {
+		t.Errorf("expected rewrapped ciphertext to keep its binding, got %v", err)
+	}
+}
+
+func TestEncryptWithSuiteXChaCha20Poly1305(t *testing.T) {
+	encrypted, err := util.EncryptWithSuite(&data, &keyV1, utils.SuiteXChaCha20Poly1305)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	envelope, err := utils.ParseEnvelope(*encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if envelope.Suite != utils.SuiteXChaCha20Poly1305 {
+		t.Errorf("expected suite %s, got %s", utils.SuiteXChaCha20Poly1305, envelope.Suite)
+	}
+	if len(envelope.Nonce) != 24 {
+		t.Errorf("expected a 24 byte nonce, got %d", len(envelope.Nonce))
+	}
+
+	decrypted, err := util.Decrypt(encrypted, &keyV1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(*decrypted) != string(data) {
+		t.Errorf("expected decrypted data to match original data")
+	}
+
+	// XChaCha20-Poly1305 only supports 256 bit keys
+	if _, err := util.EncryptWithSuite(&data, &passphrase, utils.SuiteXChaCha20Poly1305); err == nil {
+		t.Error("expected error for a 128 bit key")
+	}
+	if _, err := util.EncryptWithSuite(&data, &keyV1, 99); !errors.Is(err, utils.ErrUnsupportedEnvelope) {
+		t.Errorf("expected ErrUnsupportedEnvelope, got %v", err)
+	}
+}
+
+func TestKeyringSuite(t *testing.T) {
+	keyring, _ := utils.NewKeyring("v1", keyV1)
+	aesEncrypted, _ := keyring.Encrypt(&data)
+
+	if err := keyring.SetSuite(utils.SuiteXChaCha20Poly1305); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyring.Suite() != utils.SuiteXChaCha20Poly1305 {
+		t.Errorf("expected suite %s, got %s", utils.SuiteXChaCha20Poly1305, keyring.Suite())
+	}
+
+	encrypted, err := keyring.Encrypt(&data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if envelope, _ := utils.ParseEnvelope(*encrypted); envelope.Suite != utils.SuiteXChaCha20Poly1305 {
+		t.Errorf("expected new ciphertexts to use %s, got %s", utils.SuiteXChaCha20Poly1305, envelope.Suite)
+	}
+
+	// ciphertexts of the previous suite stay readable and are converted by Rewrap
+	if plaintext, err := keyring.Decrypt(aesEncrypted); err != nil || string(*plaintext) != string(data) {
+		t.Errorf("expected AES-GCM ciphertext to decrypt, got %v", err)
+	}
+	rewrapped, changed, err := keyring.Rewrap(aesEncrypted)
+	if err != nil || !changed {
+		t.Fatalf("expected ciphertext to be rewrapped, got %v", err)
+	}
+	if envelope, _ := utils.ParseEnvelope(*rewrapped); envelope.Suite != utils.SuiteXChaCha20Poly1305 {
+		t.Errorf("expected rewrapped ciphertext to use %s, got %s", utils.SuiteXChaCha20Poly1305, envelope.Suite)
+	}
+
+	if err := keyring.SetSuite(99); err == nil {
+		t.Error("expected error for unknown suite")
+	}
+
+	// a 128 bit key cannot become the primary key of an XChaCha20-Poly1305 keyring
+	keyring.AddKey("short", passphrase)
+	if err := keyring.SetPrimary("short"); err == nil {
+		t.Error("expected error for a 128 bit primary key")
+	}
+	if keyring.Primary() != "v1" {
+		t.Errorf("expected the primary key to stay v1, got %s", keyring.Primary())
+	}
+	if err := keyring.AddKey("v1", passphrase); err == nil {
+		t.Error("expected error for replacing the primary key with a 128 bit key")
+	}
+
+	short, _ := utils.NewKeyring("short", passphrase)
+	if err := short.SetSuite(utils.SuiteXChaCha20Poly1305); err == nil {
+		t.Error("expected error for a keyring with a 128 bit primary key")
+	}
+	if _, err := short.Encrypt(&data); err != nil {
+		t.Errorf("expected the keyring to keep encrypting with AES-GCM, got %v", err)
+	}
+}
+
+func TestEnvelopeSealWithWrappedKey(t *testing.T) {
+	envelope := &utils.Envelope{
+		Version:    utils.EnvelopeVersion,
+		Suite:      utils.SuiteAESGCM,
+		KDF:        utils.KDFKMS,
+		KMSKeyID:   "transit/mfa",
+		WrappedKey: []byte("vault:v1:wrapped"),
+	}
+	sealed, err := envelope.Seal([]byte("plaintext"), passphrase, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, err := utils.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.KDF != utils.KDFKMS || parsed.KMSKeyID != "transit/mfa" || string(parsed.WrappedKey) != "vault:v1:wrapped" {
+		t.Errorf("expected kms fields to round trip, got %+v", parsed)
+	}
+	plaintext, err := parsed.Open(passphrase, nil)
+	if err != nil || string(plaintext) != "plaintext" {
+		t.Errorf("expected plaintext, got %q, %v", plaintext, err)
+	}
+
+	// the wrapped key is authenticated as part of the header
+	parsed.WrappedKey = []byte("vault:v1:Wrapped")
+	if _, err := parsed.Open(passphrase, nil); !errors.Is(err, utils.ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+	if _, err := util.Decrypt(&sealed, &passphrase); !errors.Is(err, utils.ErrKMSEnvelope) {
+		t.Errorf("expected ErrKMSEnvelope, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix && !windows
+
+package utils
+
+import "os"
+
+// lockFile is a no-op on platforms without file locking.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without file locking.
+func unlockFile(file *os.File) error {
+	return nil
+}
+
+// syncDir is a no-op on platforms where directories cannot be synced.
+func syncDir(path string) error {
+	return nil
+}
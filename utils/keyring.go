@@ -0,0 +1,430 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrKeyNotFound is returned when a keyring holds no key with the requested id.
+	ErrKeyNotFound = errors.New("key not found in keyring")
+
+	// ErrPrimaryKey is returned when the primary key of a keyring would be removed.
+	ErrPrimaryKey = errors.New("the primary key cannot be removed")
+)
+
+// Keyring holds several AES keys by id. The primary key is used for all new
+// ciphertexts, while every key of the keyring can be used for decryption. The
+// key id of the primary key is recorded in the Envelope of each ciphertext,
+// so that master keys can be rotated: add a new key, make it primary, rewrap
+// existing ciphertexts and finally remove the old key. It is safe for
+// concurrent use.
+type Keyring struct {
+	mutex   sync.RWMutex
+	keys    map[string][]byte
+	primary string
+	suite   CipherSuite
+}
+
+// NewKeyring returns a keyring with the given key as its primary key. An empty
+// key id is allowed and produces envelopes without a key id.
+func NewKeyring(primaryID string, primaryKey []byte) (*Keyring, error) {
+	keyring := &Keyring{
+		keys:  make(map[string][]byte),
+		suite: SuiteAESGCM,
+	}
+	if err := keyring.AddKey(primaryID, primaryKey); err != nil {
+		return nil, err
+	}
+	keyring.primary = primaryID
+
+	return keyring, nil
+}
+
+// AddKey adds a 16 or 32 byte AES key under the given id, replacing any key
+// with the same id. A replaced primary key has to fit the cipher suite.
+func (keyring *Keyring) AddKey(id string, key []byte) error {
+	if len(key) != 16 && len(key) != 32 {
+		return ErrInvalidKeySize
+	}
+	if len(id) > 255 {
+		return errors.New("key id must not exceed 255 bytes")
+	}
+
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+	if _, found := keyring.keys[id]; found && id == keyring.primary {
+		if _, err := newAEAD(keyring.suite, key); err != nil {
+			return err
+		}
+	}
+	keyring.keys[id] = append([]byte(nil), key...)
+
+	return nil
+}
+
+// RemoveKey removes a key that is no longer needed for decryption.
+func (keyring *Keyring) RemoveKey(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if id == keyring.primary {
+		return ErrPrimaryKey
+	}
+	key, found := keyring.keys[id]
+	if !found {
+		return ErrKeyNotFound
+	}
+	clear(key)
+	delete(keyring.keys, id)
+
+	return nil
+}
+
+// SetPrimary selects the key that is used for new ciphertexts. The key has
+// to fit the cipher suite of the keyring.
+func (keyring *Keyring) SetPrimary(id string) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	key, found := keyring.keys[id]
+	if !found {
+		return ErrKeyNotFound
+	}
+	if _, err := newAEAD(keyring.suite, key); err != nil {
+		return err
+	}
+	keyring.primary = id
+
+	return nil
+}
+
+// SetSuite selects the cipher suite of new ciphertexts. Ciphertexts of other
+// suites stay readable and are converted by Rewrap. XChaCha20-Poly1305
+// requires the primary key to be 32 bytes long.
+func (keyring *Keyring) SetSuite(suite CipherSuite) error {
+	keyring.mutex.Lock()
+	defer keyring.mutex.Unlock()
+
+	if _, err := newAEAD(suite, keyring.keys[keyring.primary]); err != nil {
+		return err
+	}
+	keyring.suite = suite
+
+	return nil
+}
+
+// Suite returns the cipher suite of new ciphertexts.
+func (keyring *Keyring) Suite() CipherSuite {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.suite
+}
+
+// Primary returns the id of the primary key.
+func (keyring *Keyring) Primary() string {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.primary
+}
+
+// KeyIDs returns the ids of all keys in the keyring, sorted.
+func (keyring *Keyring) KeyIDs() []string {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	ids := make([]string, 0, len(keyring.keys))
+	for id := range keyring.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Encrypt encrypts data with the primary key and records its id in the envelope.
+func (keyring *Keyring) Encrypt(data *[]byte) (*[]byte, error) {
+	return keyring.EncryptWithAssociatedData(data, nil)
+}
+
+// EncryptWithAssociatedData works like Encrypt, but binds the ciphertext to
+// the associated data, which has to be passed again on decryption.
+func (keyring *Keyring) EncryptWithAssociatedData(data *[]byte, associatedData []byte) (*[]byte, error) {
+	id, key, suite := keyring.primaryKey()
+	defer clear(key)
+
+	envelope := &Envelope{
+		Version: EnvelopeVersion,
+		Suite:   suite,
+		KeyID:   id,
+	}
+	return sealEnvelope(envelope, *data, key, associatedData)
+}
+
+// Decrypt decrypts data with the key named in its envelope. Legacy data and
+// envelopes without a key id are tried with every key, starting with the
+// primary key. Unlike TinyMfaUtil.Decrypt, the input is left untouched.
+func (keyring *Keyring) Decrypt(data *[]byte) (*[]byte, error) {
+	return keyring.DecryptWithAssociatedData(data, nil)
+}
+
+// DecryptWithAssociatedData decrypts data that was encrypted with
+// EncryptWithAssociatedData and the same associated data.
+func (keyring *Keyring) DecryptWithAssociatedData(data *[]byte, associatedData []byte) (*[]byte, error) {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	envelope, err := ParseEnvelope(*data)
+	if err == nil && envelope.KeyID != "" {
+		key, found := keyring.keys[envelope.KeyID]
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, envelope.KeyID)
+		}
+		plaintext, err := openData(*data, key, associatedData)
+		if err != nil {
+			return nil, err
+		}
+		return &plaintext, nil
+	}
+
+	var lastErr error
+	for _, key := range keyring.candidates() {
+		plaintext, err := openData(*data, key, associatedData)
+		if err == nil {
+			return &plaintext, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// primaryKey returns the id, a copy of the key and the cipher suite of new
+// ciphertexts. The key is copied under the lock, since RemoveKey zeroes keys
+// that may otherwise still be in use after a change of the primary key.
+func (keyring *Keyring) primaryKey() (string, []byte, CipherSuite) {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	return keyring.primary, append([]byte(nil), keyring.keys[keyring.primary]...), keyring.suite
+}
+
+// candidates returns all keys with the primary key first. The caller must
+// hold the read lock.
+func (keyring *Keyring) candidates() [][]byte {
+	keys := [][]byte{keyring.keys[keyring.primary]}
+	for id, key := range keyring.keys {
+		if id != keyring.primary {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// NeedsRewrap reports whether data is not an envelope of the primary key and
+// the cipher suite of the keyring.
+func (keyring *Keyring) NeedsRewrap(data []byte) bool {
+	envelope, err := ParseEnvelope(data)
+	if err != nil {
+		return true
+	}
+
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+	return envelope.KeyID != keyring.primary || envelope.Suite != keyring.suite
+}
+
+// Rewrap decrypts data and encrypts it again with the primary key. Data that
+// already is an envelope of the primary key is returned unchanged, which is
+// reported by the second return value being false.
+func (keyring *Keyring) Rewrap(data *[]byte) (*[]byte, bool, error) {
+	return keyring.RewrapWithAssociatedData(data, nil)
+}
+
+// RewrapWithAssociatedData works like Rewrap for data that is bound to
+// associated data. The rewrapped ciphertext is bound to the same data.
+func (keyring *Keyring) RewrapWithAssociatedData(data *[]byte, associatedData []byte) (*[]byte, bool, error) {
+	if !keyring.NeedsRewrap(*data) {
+		return data, false, nil
+	}
+
+	plaintext, err := keyring.DecryptWithAssociatedData(data, associatedData)
+	if err != nil {
+		return nil, false, err
+	}
+	defer clear(*plaintext)
+
+	ciphertext, err := keyring.EncryptWithAssociatedData(plaintext, associatedData)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return ciphertext, true, nil
+}
+
+// NewStreamWriter returns a stream writer that encrypts with the primary key
+// and the cipher suite of the keyring and records the key id in the stream
+// header. A chunkSize of zero selects DefaultChunkSize.
+func (keyring *Keyring) NewStreamWriter(destination io.Writer, chunkSize int) (io.WriteCloser, error) {
+	id, key, suite := keyring.primaryKey()
+	defer clear(key)
+
+	return NewStreamWriter(destination, key, id, suite, chunkSize)
+}
+
+// NewStreamReader reads the stream header from source and returns a reader
+// that decrypts the stream with the key named in the header. Streams without
+// a key id are tried with every key, starting with the primary key, by
+// opening their first chunk.
+func (keyring *Keyring) NewStreamReader(source io.Reader) (io.Reader, error) {
+	header, err := ReadStreamHeader(source)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	if header.KeyID != "" {
+		key, found := keyring.keys[header.KeyID]
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, header.KeyID)
+		}
+		return newStreamReader(source, header, key)
+	}
+
+	aead, err := newAEAD(header.Suite, keyring.keys[keyring.primary])
+	if err != nil {
+		return nil, err
+	}
+	chunk := make([]byte, int(header.ChunkSize)+aead.Overhead())
+	n, err := io.ReadFull(source, chunk)
+	switch {
+	case err == nil, errors.Is(err, io.ErrUnexpectedEOF):
+	case errors.Is(err, io.EOF):
+		return nil, ErrStreamTruncated
+	default:
+		return nil, err
+	}
+
+	// a full first chunk is read from the buffer alone, so source is only
+	// consumed by the reader that opens it
+	lastErr := ErrStreamCorrupted
+	for _, key := range keyring.candidates() {
+		reader, err := newStreamReader(io.MultiReader(bytes.NewReader(chunk[:n]), source), header, key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if reader.err = reader.next(); reader.err == nil {
+			return reader, nil
+		}
+	}
+
+	return nil, lastErr
+}
+
+// needsStreamRewrap reports whether a stream was not written with the primary
+// key and the cipher suite of the keyring.
+func (keyring *Keyring) needsStreamRewrap(header *StreamHeader) bool {
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+	return header.KeyID != keyring.primary || header.Suite != keyring.suite
+}
+
+// ReencryptDirectory walks dirPath and rewraps every regular file under the
+// primary key of the keyring. Encrypted streams, as written by EncryptFile,
+// are re-encrypted chunk by chunk and stay streams. Files are locked while
+// they are rewritten, replaced atomically and keep their permissions. Lock
+// files and temporary files are skipped. It returns the number of rewritten files and stops at the
+// first file that cannot be decrypted; files rewritten up to that point
+// remain readable, as long as the old keys stay in the keyring.
+func ReencryptDirectory(dirPath string, keyring *Keyring) (int, error) {
+	rewritten := 0
+	err := filepath.WalkDir(dirPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || isVaultFile(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		changed, err := keyring.rewrapFile(path, info.Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("could not rewrap %s: %w", path, err)
+		}
+		if changed {
+			rewritten++
+		}
+
+		return nil
+	})
+
+	return rewritten, err
+}
+
+// rewrapFile rewraps a single file while holding its lock and reports whether
+// it was rewritten.
+func (keyring *Keyring) rewrapFile(path string, perm os.FileMode) (bool, error) {
+	lock, err := LockFile(path)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	source := bufio.NewReader(file)
+	if magic, _ := source.Peek(len(StreamMagic)); !bytes.Equal(magic, StreamMagic) {
+		data, err := io.ReadAll(source)
+		if err != nil {
+			return false, err
+		}
+		ciphertext, changed, err := keyring.Rewrap(&data)
+		if err != nil || !changed {
+			return false, err
+		}
+		return true, writeFileAtomic(path, *ciphertext, perm)
+	}
+
+	header, err := ReadStreamHeader(source)
+	if err != nil {
+		return false, err
+	}
+	if !keyring.needsStreamRewrap(header) {
+		return false, nil
+	}
+	reader, err := keyring.NewStreamReader(io.MultiReader(bytes.NewReader(header.Marshal()), source))
+	if err != nil {
+		return false, err
+	}
+
+	return true, writeFileAtomicFunc(path, perm, func(destination io.Writer) error {
+		writer, err := keyring.NewStreamWriter(destination, int(header.ChunkSize))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(writer, reader); err != nil {
+			return err
+		}
+		return writer.Close()
+	})
+}
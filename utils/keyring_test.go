@@ -0,0 +1,201 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+var keyV1 = []byte("0123456789abcdef0123456789abcdef")
+var keyV2 = []byte("fedcba9876543210fedcba9876543210")
+
+func TestKeyringRotation(t *testing.T) {
+	keyring, err := utils.NewKeyring("v1", keyV1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	old, err := keyring.Encrypt(&data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if envelope, _ := utils.ParseEnvelope(*old); envelope.KeyID != "v1" {
+		t.Errorf("expected key id v1, got %s", envelope.KeyID)
+	}
+
+	if err := keyring.AddKey("v2", keyV2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := keyring.SetPrimary("v2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !keyring.NeedsRewrap(*old) {
+		t.Error("expected ciphertext of the old key to need a rewrap")
+	}
+
+	// the old key still decrypts
+	plaintext, err := keyring.Decrypt(old)
+	if err != nil || string(*plaintext) != string(data) {
+		t.Fatalf("expected old ciphertext to decrypt, got %v", err)
+	}
+
+	rewrapped, changed, err := keyring.Rewrap(old)
+	if err != nil || !changed {
+		t.Fatalf("expected ciphertext to be rewrapped, got %v", err)
+	}
+	if envelope, _ := utils.ParseEnvelope(*rewrapped); envelope.KeyID != "v2" {
+		t.Errorf("expected key id v2, got %s", envelope.KeyID)
+	}
+	if _, changed, _ := keyring.Rewrap(rewrapped); changed {
+		t.Error("expected ciphertext of the primary key to stay unchanged")
+	}
+
+	if err := keyring.RemoveKey("v2"); !errors.Is(err, utils.ErrPrimaryKey) {
+		t.Errorf("expected ErrPrimaryKey, got %v", err)
+	}
+	if err := keyring.RemoveKey("v1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := keyring.Decrypt(old); !errors.Is(err, utils.ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if plaintext, err := keyring.Decrypt(rewrapped); err != nil || string(*plaintext) != string(data) {
+		t.Errorf("expected rewrapped ciphertext to decrypt, got %v", err)
+	}
+	if ids := keyring.KeyIDs(); len(ids) != 1 || ids[0] != "v2" {
+		t.Errorf("expected only key v2, got %v", ids)
+	}
+}
+
+func TestKeyringConcurrentRotation(t *testing.T) {
+	for range 20 {
+		keyring, _ := utils.NewKeyring("v1", keyV1)
+		keyring.AddKey("v2", keyV2)
+
+		var wg sync.WaitGroup
+		ciphertexts := make(chan *[]byte, 4)
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				encrypted, err := keyring.Encrypt(&data)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+				ciphertexts <- encrypted
+			}()
+		}
+		keyring.SetPrimary("v2")
+		keyring.RemoveKey("v1")
+		wg.Wait()
+		close(ciphertexts)
+
+		// ciphertexts sealed with v1 while it was removed must not use a zeroed key
+		zeroed, _ := utils.NewKeyring("v1", make([]byte, 32))
+		for encrypted := range ciphertexts {
+			if _, err := zeroed.Decrypt(encrypted); err == nil {
+				t.Error("expected no ciphertext to be sealed with a zeroed key")
+			}
+		}
+	}
+}
+
+func TestKeyringDecryptWithoutKeyID(t *testing.T) {
+	keyring, _ := utils.NewKeyring("v2", keyV2)
+	keyring.AddKey("v1", keyV1)
+
+	encrypted, err := util.Encrypt(&data, &keyV1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plaintext, err := keyring.Decrypt(encrypted)
+	if err != nil || string(*plaintext) != string(data) {
+		t.Errorf("expected ciphertext without key id to decrypt, got %v", err)
+	}
+
+	if _, err := utils.NewKeyring("v1", []byte("short")); err == nil {
+		t.Error("expected error for invalid key size")
+	}
+}
+
+func TestReencryptDirectory(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "nested")
+	os.Mkdir(nested, 0700)
+
+	files := []string{filepath.Join(dir, "a.enc"), filepath.Join(nested, "b.enc")}
+	if err := util.EncryptFile(files[0], &data, &keyV1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ciphertext, _ := util.Encrypt(&data, &keyV1)
+	if err := os.WriteFile(files[1], *ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := os.Stat(files[0])
+
+	keyring, _ := utils.NewKeyring("v1", keyV1)
+	keyring.AddKey("v2", keyV2)
+	keyring.SetPrimary("v2")
+
+	rewritten, err := utils.ReencryptDirectory(dir, keyring)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rewritten != len(files) {
+		t.Errorf("expected %d rewritten files, got %d", len(files), rewritten)
+	}
+
+	keyring.RemoveKey("v1")
+	for _, file := range files {
+		plaintext, err := decryptKeyringFile(keyring, file)
+		if err != nil || string(plaintext) != string(data) {
+			t.Errorf("expected %s to decrypt with the new key, got %v", file, err)
+		}
+		info, _ := os.Stat(file)
+		if info.Mode().Perm() != before.Mode().Perm() {
+			t.Errorf("expected permissions to be kept, got %v", info.Mode().Perm())
+		}
+	}
+
+	rewritten, err = utils.ReencryptDirectory(dir, keyring)
+	if err != nil || rewritten != 0 {
+		t.Errorf("expected nothing to rewrite, got %d, %v", rewritten, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp-") {
+			t.Errorf("expected no temporary files to be left, got %s", entry.Name())
+		}
+	}
+}
+
+// decryptKeyringFile decrypts a file that holds either a stream or an envelope.
+func decryptKeyringFile(keyring *utils.Keyring, path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(content, utils.StreamMagic) {
+		plaintext, err := keyring.Decrypt(&content)
+		if err != nil {
+			return nil, err
+		}
+		return *plaintext, nil
+	}
+
+	reader, err := keyring.NewStreamReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrKeyUnwrap is returned when wrapped data fails the integrity check of
+	// the key wrap algorithm, because it was modified or the key is wrong.
+	ErrKeyUnwrap = errors.New("key unwrap integrity check failed")
+
+	// ErrKeyWrapLength is returned when data has a length that the key wrap
+	// algorithm does not support.
+	ErrKeyWrapLength = errors.New("invalid length for key wrap")
+)
+
+var (
+	// keyWrapIV is the default initial value of RFC 3394 Section 2.2.3.1.
+	keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+	// keyWrapPadIV is the constant half of the alternative initial value of RFC 5649 Section 3.
+	keyWrapPadIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+)
+
+// newKeyWrapCipher returns the AES block cipher of a 128, 192 or 256 bit
+// key encryption key.
+func newKeyWrapCipher(kek []byte) (cipher.Block, error) {
+	switch len(kek) {
+	case 16, 24, 32:
+		return aes.NewCipher(kek)
+	default:
+		return nil, ErrInvalidKeySize
+	}
+}
+
+// WrapKey wraps a key with the AES Key Wrap algorithm of RFC 3394. The key
+// must be a multiple of 8 bytes and at least 16 bytes long; use
+// WrapKeyWithPadding for other lengths. The result is 8 bytes longer than
+// the key.
+func WrapKey(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapBlocks(block, keyWrapIV, key), nil
+}
+
+// UnwrapKey unwraps a key that was wrapped with WrapKey.
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	iv, key := unwrapBlocks(block, wrapped)
+	if subtle.ConstantTimeCompare(iv, keyWrapIV) != 1 {
+		clear(key)
+		return nil, ErrKeyUnwrap
+	}
+
+	return key, nil
+}
+
+// WrapKeyWithPadding wraps a key of any length from 1 byte with the AES Key
+// Wrap with Padding algorithm of RFC 5649, which HSMs commonly accept as
+// CKM_AES_KEY_WRAP_PAD.
+func WrapKeyWithPadding(kek, key []byte) ([]byte, error) {
+	if len(key) == 0 || uint64(len(key)) > 0xffffffff {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := binary.BigEndian.AppendUint32(append([]byte(nil), keyWrapPadIV...), uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	copy(padded, key)
+	defer clear(padded)
+
+	if len(padded) == 8 {
+		wrapped := append(iv, padded...)
+		block.Encrypt(wrapped, wrapped)
+		return wrapped, nil
+	}
+
+	return wrapBlocks(block, iv, padded), nil
+}
+
+// UnwrapKeyWithPadding unwraps a key that was wrapped with WrapKeyWithPadding.
+func UnwrapKeyWithPadding(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, ErrKeyWrapLength
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv, padded []byte
+	if len(wrapped) == 16 {
+		decrypted := make([]byte, 16)
+		block.Decrypt(decrypted, wrapped)
+		iv, padded = decrypted[:8], decrypted[8:]
+	} else {
+		iv, padded = unwrapBlocks(block, wrapped)
+	}
+
+	// RFC 5649 Section 3: check the constant, the message length indicator
+	// and that the padding consists of zeros
+	size := int(binary.BigEndian.Uint32(iv[4:]))
+	valid := subtle.ConstantTimeCompare(iv[:4], keyWrapPadIV)
+	if size <= len(padded)-8 || size > len(padded) {
+		valid = 0
+	} else {
+		valid &= subtle.ConstantTimeCompare(padded[size:], make([]byte, len(padded)-size))
+	}
+	if valid != 1 {
+		clear(padded)
+		return nil, ErrKeyUnwrap
+	}
+
+	return padded[:size], nil
+}
+
+// wrapBlocks implements the wrapping process of RFC 3394 Section 2.2.1 with
+// the given initial value. The plaintext must consist of at least two 64 bit
+// blocks.
+func wrapBlocks(block cipher.Block, iv, plaintext []byte) []byte {
+	n := len(plaintext) / 8
+	wrapped := make([]byte, 8+len(plaintext))
+	copy(wrapped, iv)
+	copy(wrapped[8:], plaintext)
+
+	buffer := make([]byte, 16)
+	defer clear(buffer)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buffer, wrapped[:8])
+			copy(buffer[8:], wrapped[8*i:8*i+8])
+			block.Encrypt(buffer, buffer)
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(wrapped[:8], binary.BigEndian.Uint64(buffer[:8])^t)
+			copy(wrapped[8*i:8*i+8], buffer[8:])
+		}
+	}
+
+	return wrapped
+}
+
+// unwrapBlocks implements the unwrapping process of RFC 3394 Section 2.2.2
+// and returns the recovered initial value and plaintext, which the caller
+// has to check.
+func unwrapBlocks(block cipher.Block, wrapped []byte) ([]byte, []byte) {
+	n := len(wrapped)/8 - 1
+	iv := append([]byte(nil), wrapped[:8]...)
+	plaintext := append([]byte(nil), wrapped[8:]...)
+
+	buffer := make([]byte, 16)
+	defer clear(buffer)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buffer[:8], binary.BigEndian.Uint64(iv)^t)
+			copy(buffer[8:], plaintext[8*(i-1):8*i])
+			block.Decrypt(buffer, buffer)
+
+			copy(iv, buffer[:8])
+			copy(plaintext[8*(i-1):8*i], buffer[8:])
+		}
+	}
+
+	return iv, plaintext
+}
@@ -0,0 +1,103 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %s: %v", s, err)
+	}
+	return decoded
+}
+
+func TestWrapKeyRFC3394(t *testing.T) {
+	vectors := []struct{ kek, key, wrapped string }{
+		// RFC 3394 Section 4.1: 128 bits of key data with a 128 bit KEK
+		{"000102030405060708090A0B0C0D0E0F", "00112233445566778899AABBCCDDEEFF", "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		// Section 4.3: 128 bits of key data with a 256 bit KEK
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF", "64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7"},
+		// Section 4.6: 256 bits of key data with a 256 bit KEK
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F", "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+	}
+	for _, vector := range vectors {
+		kek, key, expected := mustHex(t, vector.kek), mustHex(t, vector.key), mustHex(t, vector.wrapped)
+		wrapped, err := utils.WrapKey(kek, key)
+		if err != nil || !bytes.Equal(wrapped, expected) {
+			t.Errorf("expected %X, got %X, %v", expected, wrapped, err)
+		}
+		unwrapped, err := utils.UnwrapKey(kek, expected)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("expected %X, got %X, %v", key, unwrapped, err)
+		}
+	}
+}
+
+func TestWrapKeyWithPaddingRFC5649(t *testing.T) {
+	kek := mustHex(t, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	vectors := []struct{ key, wrapped string }{
+		// RFC 5649 Section 6: 20 octets and 7 octets of key data
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	}
+	for _, vector := range vectors {
+		key, expected := mustHex(t, vector.key), mustHex(t, vector.wrapped)
+		wrapped, err := utils.WrapKeyWithPadding(kek, key)
+		if err != nil || !bytes.Equal(wrapped, expected) {
+			t.Errorf("expected %x, got %x, %v", expected, wrapped, err)
+		}
+		unwrapped, err := utils.UnwrapKeyWithPadding(kek, expected)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("expected %x, got %x, %v", key, unwrapped, err)
+		}
+	}
+}
+
+func TestUnwrapKeyErrors(t *testing.T) {
+	wrapped, _ := utils.WrapKeyWithPadding(keyV1, []byte("12345678901234567890"))
+
+	if _, err := utils.UnwrapKeyWithPadding(keyV2, wrapped); !errors.Is(err, utils.ErrKeyUnwrap) {
+		t.Errorf("expected ErrKeyUnwrap for a wrong key, got %v", err)
+	}
+	wrapped[5] ^= 1
+	if _, err := utils.UnwrapKeyWithPadding(keyV1, wrapped); !errors.Is(err, utils.ErrKeyUnwrap) {
+		t.Errorf("expected ErrKeyUnwrap for modified data, got %v", err)
+	}
+	if _, err := utils.UnwrapKeyWithPadding(keyV1, wrapped[:12]); !errors.Is(err, utils.ErrKeyWrapLength) {
+		t.Errorf("expected ErrKeyWrapLength, got %v", err)
+	}
+
+	// data wrapped without padding does not unwrap as padded data
+	plain, _ := utils.WrapKey(keyV1, keyV2)
+	if _, err := utils.UnwrapKeyWithPadding(keyV1, plain); !errors.Is(err, utils.ErrKeyUnwrap) {
+		t.Errorf("expected ErrKeyUnwrap, got %v", err)
+	}
+
+	if _, err := utils.WrapKey(keyV1, []byte("1234567")); !errors.Is(err, utils.ErrKeyWrapLength) {
+		t.Errorf("expected ErrKeyWrapLength, got %v", err)
+	}
+	if _, err := utils.WrapKey([]byte("short"), keyV2); !errors.Is(err, utils.ErrInvalidKeySize) {
+		t.Errorf("expected ErrInvalidKeySize, got %v", err)
+	}
+}
+
+func TestWrapKeyWithPaddingLengths(t *testing.T) {
+	for size := 1; size <= 64; size++ {
+		key := bytes.Repeat([]byte{byte(size)}, size)
+		wrapped, err := utils.WrapKeyWithPadding(keyV1, key)
+		if err != nil {
+			t.Fatalf("%d bytes: unexpected error: %v", size, err)
+		}
+		unwrapped, err := utils.UnwrapKeyWithPadding(keyV1, wrapped)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("%d bytes: expected round trip, got %v", size, err)
+		}
+	}
+}
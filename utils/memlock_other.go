@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package utils
+
+import "errors"
+
+// lockMemory reports that memory cannot be locked on this platform.
+func lockMemory(memory []byte) error {
+	return errors.New("memory locking is not supported on this platform")
+}
+
+// unlockMemory is a no-op on platforms without memory locking.
+func unlockMemory(memory []byte) error {
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package utils
+
+import "golang.org/x/sys/unix"
+
+// lockMemory locks memory against swapping with mlock.
+func lockMemory(memory []byte) error {
+	return unix.Mlock(memory)
+}
+
+// unlockMemory releases the lock taken by lockMemory.
+func unlockMemory(memory []byte) error {
+	return unix.Munlock(memory)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package utils
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockMemory locks memory into the working set with VirtualLock.
+func lockMemory(memory []byte) error {
+	return windows.VirtualLock(uintptr(unsafe.Pointer(&memory[0])), uintptr(len(memory)))
+}
+
+// unlockMemory releases the lock taken by lockMemory.
+func unlockMemory(memory []byte) error {
+	return windows.VirtualUnlock(uintptr(unsafe.Pointer(&memory[0])), uintptr(len(memory)))
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// Argon2SaltSize is the size of the random salt of passphrase-based envelopes.
+	Argon2SaltSize = 16
+
+	// MaxArgon2Memory limits the memory cost accepted on decryption (1 GiB in
+	// KiB), so that a crafted envelope cannot make a single decryption
+	// allocate more than that. It is 16 times the default.
+	MaxArgon2Memory = 1024 * 1024
+
+	// MaxArgon2Time limits the time cost accepted on decryption.
+	MaxArgon2Time = 64
+)
+
+// ErrPassphraseRequired is returned when an envelope that was encrypted with
+// a passphrase is decrypted with a raw key, or the other way round.
+var ErrPassphraseRequired = errors.New("envelope key derivation does not match")
+
+// Argon2Params are the Argon2id cost parameters of passphrase-based encryption.
+// They are stored in the envelope, so that they can be raised over time
+// without breaking existing ciphertexts.
+type Argon2Params struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+	// Memory is the memory cost in KiB.
+	Memory uint32
+	// Threads is the degree of parallelism.
+	Threads uint8
+}
+
+// DefaultArgon2Params returns the second recommended option of RFC 9106:
+// 3 passes over 64 MiB with 4 lanes.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+}
+
+// validate checks that the parameters are usable and within the limits.
+func (params Argon2Params) validate() error {
+	if params.Time == 0 || params.Threads == 0 {
+		return errors.New("argon2 time and threads must be at least 1")
+	}
+	if params.Memory < 8*uint32(params.Threads) {
+		return fmt.Errorf("argon2 memory must be at least %d KiB", 8*uint32(params.Threads))
+	}
+	if params.Time > MaxArgon2Time || params.Memory > MaxArgon2Memory {
+		return errors.New("argon2 parameters exceed the supported limits")
+	}
+	return nil
+}
+
+// deriveKey derives a 256 bit AES key from the passphrase.
+func (params Argon2Params) deriveKey(passphrase, salt []byte) []byte {
+	return argon2.IDKey(passphrase, salt, params.Time, params.Memory, params.Threads, 32)
+}
+
+// EncryptWithPassphrase derives an AES-256 key from the passphrase with
+// Argon2id and a random salt and encrypts data with it. Salt and parameters
+// are stored in the envelope.
+func (util *TinyMfaUtil) EncryptWithPassphrase(data, passphrase *[]byte, params Argon2Params) (*[]byte, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, Argon2SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key := params.deriveKey(*passphrase, salt)
+	defer clear(key)
+
+	envelope := &Envelope{
+		Version: EnvelopeVersion,
+		Suite:   SuiteAESGCM,
+		KDF:     KDFArgon2id,
+		Argon2:  params,
+		Salt:    salt,
+	}
+
+	return sealEnvelope(envelope, *data, key, nil)
+}
+
+// DecryptWithPassphrase derives the key of a passphrase-based envelope from
+// its stored salt and parameters and decrypts the data.
+func (util *TinyMfaUtil) DecryptWithPassphrase(data, passphrase *[]byte) (*[]byte, error) {
+	envelope, err := ParseEnvelope(*data)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.KDF == KDFKMS {
+		return nil, ErrKMSEnvelope
+	}
+	if envelope.KDF != KDFArgon2id {
+		return nil, ErrPassphraseRequired
+	}
+	if err := envelope.Argon2.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedEnvelope, err)
+	}
+
+	key := envelope.Argon2.deriveKey(*passphrase, envelope.Salt)
+	defer clear(key)
+
+	plaintext, err := openEnvelope(envelope, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plaintext, nil
+}
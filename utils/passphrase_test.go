@@ -0,0 +1,85 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+// fastArgon2 keeps the tests fast; real deployments use DefaultArgon2Params.
+var fastArgon2 = utils.Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestEncryptWithPassphrase(t *testing.T) {
+	password := []byte("correct horse battery staple")
+	encrypted, err := util.EncryptWithPassphrase(&data, &password, fastArgon2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	envelope, err := utils.ParseEnvelope(*encrypted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if envelope.KDF != utils.KDFArgon2id || envelope.Argon2 != fastArgon2 {
+		t.Errorf("expected argon2id parameters to be stored, got %s %+v", envelope.KDF, envelope.Argon2)
+	}
+	if len(envelope.Salt) != utils.Argon2SaltSize {
+		t.Errorf("expected a %d byte salt, got %d", utils.Argon2SaltSize, len(envelope.Salt))
+	}
+
+	again, _ := util.EncryptWithPassphrase(&data, &password, fastArgon2)
+	if second, _ := utils.ParseEnvelope(*again); string(second.Salt) == string(envelope.Salt) {
+		t.Error("expected a random salt per encryption")
+	}
+
+	decrypted, err := util.DecryptWithPassphrase(encrypted, &password)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(*decrypted) != string(data) {
+		t.Errorf("expected decrypted data to match original data")
+	}
+
+	wrong := []byte("Tr0ub4dor&3")
+	if _, err := util.DecryptWithPassphrase(encrypted, &wrong); err == nil {
+		t.Error("expected error for wrong passphrase")
+	}
+}
+
+func TestPassphraseAndKeyEnvelopesDoNotMix(t *testing.T) {
+	password := []byte("mysecretpassword")
+	encrypted, _ := util.EncryptWithPassphrase(&data, &password, fastArgon2)
+	if _, err := util.Decrypt(encrypted, &password); !errors.Is(err, utils.ErrPassphraseRequired) {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+
+	keyEncrypted, _ := util.Encrypt(&data, &passphrase)
+	if _, err := util.DecryptWithPassphrase(keyEncrypted, &passphrase); !errors.Is(err, utils.ErrPassphraseRequired) {
+		t.Errorf("expected ErrPassphraseRequired, got %v", err)
+	}
+}
+
+func TestArgon2ParamsLimits(t *testing.T) {
+	password := []byte("password")
+	invalid := []utils.Argon2Params{
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 0},
+		{Time: 1, Memory: 4, Threads: 1},
+		{Time: utils.MaxArgon2Time + 1, Memory: 64, Threads: 1},
+	}
+	for _, params := range invalid {
+		if _, err := util.EncryptWithPassphrase(&data, &password, params); err == nil {
+			t.Errorf("expected error for parameters %+v", params)
+		}
+	}
+
+	// crafted envelopes must not be able to demand unbounded memory
+	encrypted, _ := util.EncryptWithPassphrase(&data, &password, fastArgon2)
+	envelope, _ := utils.ParseEnvelope(*encrypted)
+	envelope.Argon2.Memory = utils.MaxArgon2Memory + 1
+	crafted := envelope.Marshal()
+	if _, err := util.DecryptWithPassphrase(&crafted, &password); !errors.Is(err, utils.ErrUnsupportedEnvelope) {
+		t.Errorf("expected ErrUnsupportedEnvelope, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordAlgorithm identifies the algorithm of a password hash.
+type PasswordAlgorithm uint8
+
+const (
+	// PasswordArgon2id hashes passwords with Argon2id and encodes them as PHC
+	// strings, such as $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+	PasswordArgon2id PasswordAlgorithm = iota + 1
+	// PasswordBcrypt hashes passwords with bcrypt in its own $2a$ format, as
+	// produced by BcryptHash.
+	PasswordBcrypt
+)
+
+// String returns the PHC identifier of the PasswordAlgorithm.
+func (algorithm PasswordAlgorithm) String() string {
+	switch algorithm {
+	case PasswordArgon2id:
+		return "argon2id"
+	case PasswordBcrypt:
+		return "bcrypt"
+	default:
+		return fmt.Sprintf("PasswordAlgorithm(%d)", uint8(algorithm))
+	}
+}
+
+const (
+	// PasswordSaltSize is the size of the random salt of Argon2id password hashes.
+	PasswordSaltSize = 16
+
+	// PasswordKeySize is the size of Argon2id password hashes.
+	PasswordKeySize = 32
+
+	// BcryptMaxPasswordLength is the number of bytes bcrypt takes into account.
+	BcryptMaxPasswordLength = 72
+)
+
+var (
+	// ErrPasswordMismatch is returned when a password does not match its hash.
+	ErrPasswordMismatch = errors.New("password does not match")
+
+	// ErrPasswordTooLong is returned when bcrypt would truncate a password. It
+	// is the error of bcrypt.GenerateFromPassword.
+	ErrPasswordTooLong = bcrypt.ErrPasswordTooLong
+
+	// ErrUnsupportedPasswordHash is returned for hashes that cannot be parsed.
+	ErrUnsupportedPasswordHash = errors.New("unsupported password hash")
+)
+
+// PasswordHash is a parsed password hash.
+type PasswordHash struct {
+	Algorithm PasswordAlgorithm
+	// Argon2 holds the cost parameters of Argon2id hashes.
+	Argon2 Argon2Params
+	// Cost is the cost of bcrypt hashes.
+	Cost int
+	// Salt and Key are set for Argon2id hashes. Bcrypt hashes keep their salt
+	// in Encoded.
+	Salt []byte
+	Key  []byte
+	// Encoded is the original bcrypt hash.
+	Encoded string
+}
+
+// ParsePasswordHash parses an Argon2id PHC string or a bcrypt hash.
+func ParsePasswordHash(encoded string) (*PasswordHash, error) {
+	if strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$") {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+		}
+		return &PasswordHash{Algorithm: PasswordBcrypt, Cost: cost, Encoded: encoded}, nil
+	}
+
+	// $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[1] != PasswordArgon2id.String() {
+		return nil, ErrUnsupportedPasswordHash
+	}
+	if fields[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, fmt.Errorf("%w: argon2 version %s", ErrUnsupportedPasswordHash, fields[2])
+	}
+
+	hash := &PasswordHash{Algorithm: PasswordArgon2id}
+	for _, param := range strings.Split(fields[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		parsed, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: argon2 parameter %s", ErrUnsupportedPasswordHash, param)
+		}
+		switch name {
+		case "m":
+			hash.Argon2.Memory = uint32(parsed)
+		case "t":
+			hash.Argon2.Time = uint32(parsed)
+		case "p":
+			if parsed > 255 {
+				return nil, fmt.Errorf("%w: argon2 parameter %s", ErrUnsupportedPasswordHash, param)
+			}
+			hash.Argon2.Threads = uint8(parsed)
+		default:
+			return nil, fmt.Errorf("%w: argon2 parameter %s", ErrUnsupportedPasswordHash, param)
+		}
+	}
+	if err := hash.Argon2.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedPasswordHash, err)
+	}
+
+	var err error
+	if hash.Salt, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil || len(hash.Salt) < 8 {
+		return nil, fmt.Errorf("%w: invalid salt", ErrUnsupportedPasswordHash)
+	}
+	if hash.Key, err = base64.RawStdEncoding.DecodeString(fields[5]); err != nil || len(hash.Key) < 16 || len(hash.Key) > 64 {
+		return nil, fmt.Errorf("%w: invalid hash", ErrUnsupportedPasswordHash)
+	}
+
+	return hash, nil
+}
+
+// String returns the encoded form of the hash.
+func (hash *PasswordHash) String() string {
+	if hash.Algorithm == PasswordBcrypt {
+		return hash.Encoded
+	}
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordArgon2id, argon2.Version,
+		hash.Argon2.Memory, hash.Argon2.Time, hash.Argon2.Threads,
+		base64.RawStdEncoding.EncodeToString(hash.Salt),
+		base64.RawStdEncoding.EncodeToString(hash.Key))
+}
+
+// PasswordHasher hashes and verifies passwords. New hashes use Algorithm and
+// its parameters, while hashes of either algorithm are verified. Hashes made
+// with other parameters are reported by NeedsRehash, so that they can be
+// upgraded when the user logs in successfully.
+type PasswordHasher struct {
+	Algorithm PasswordAlgorithm
+	// Argon2 holds the cost parameters of new Argon2id hashes.
+	Argon2 Argon2Params
+	// BcryptCost is the cost of new bcrypt hashes.
+	BcryptCost int
+}
+
+// NewPasswordHasher returns a hasher that creates Argon2id hashes with
+// DefaultArgon2Params, and bcrypt hashes with bcrypt.DefaultCost if
+// switched to bcrypt.
+func NewPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Algorithm:  PasswordArgon2id,
+		Argon2:     DefaultArgon2Params(),
+		BcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+// Hash hashes the password with a random salt and returns the encoded hash.
+// Bcrypt hashes fail with ErrPasswordTooLong for passwords longer than 72
+// bytes, instead of silently ignoring the rest.
+func (hasher *PasswordHasher) Hash(password []byte) (string, error) {
+	switch hasher.Algorithm {
+	case PasswordArgon2id:
+		if err := hasher.Argon2.validate(); err != nil {
+			return "", err
+		}
+		salt := make([]byte, PasswordSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return "", err
+		}
+		hash := &PasswordHash{
+			Algorithm: PasswordArgon2id,
+			Argon2:    hasher.Argon2,
+			Salt:      salt,
+			Key:       argon2.IDKey(password, salt, hasher.Argon2.Time, hasher.Argon2.Memory, hasher.Argon2.Threads, PasswordKeySize),
+		}
+		return hash.String(), nil
+	case PasswordBcrypt:
+		if len(password) > BcryptMaxPasswordLength {
+			return "", ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword(password, hasher.bcryptCost())
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("unsupported password algorithm %s", hasher.Algorithm)
+	}
+}
+
+// bcryptCost returns the cost of new bcrypt hashes. Like bcrypt itself, it
+// falls back to bcrypt.DefaultCost for costs below bcrypt.MinCost.
+func (hasher *PasswordHasher) bcryptCost() int {
+	if hasher.BcryptCost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return hasher.BcryptCost
+}
+
+// Verify checks the password against an encoded hash of either algorithm,
+// including hashes made by BcryptHash. It returns ErrPasswordMismatch if the
+// password is wrong. Like BycrptVerify, only the first 72 bytes of a password
+// are compared with a bcrypt hash, so that hashes made before Hash rejected
+// longer passwords keep working. Such passwords can only be hashed anew with
+// Argon2id, and NeedsRehash reports every bcrypt hash for that algorithm.
+func (hasher *PasswordHasher) Verify(encoded string, password []byte) error {
+	hash, err := ParsePasswordHash(encoded)
+	if err != nil {
+		return err
+	}
+
+	switch hash.Algorithm {
+	case PasswordBcrypt:
+		// bcrypt has always ignored everything after the first 72 bytes
+		if len(password) > BcryptMaxPasswordLength {
+			password = password[:BcryptMaxPasswordLength]
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	default:
+		key := argon2.IDKey(password, hash.Salt, hash.Argon2.Time, hash.Argon2.Memory, hash.Argon2.Threads, uint32(len(hash.Key)))
+		if subtle.ConstantTimeCompare(key, hash.Key) != 1 {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+}
+
+// NeedsRehash reports whether an encoded hash was not made with the
+// algorithm and parameters of the hasher, or cannot be parsed at all. Call it
+// after a successful Verify and store a new hash of the password if it
+// returns true.
+func (hasher *PasswordHasher) NeedsRehash(encoded string) bool {
+	hash, err := ParsePasswordHash(encoded)
+	if err != nil || hash.Algorithm != hasher.Algorithm {
+		return true
+	}
+
+	switch hash.Algorithm {
+	case PasswordBcrypt:
+		return hash.Cost != hasher.bcryptCost()
+	default:
+		return hash.Argon2 != hasher.Argon2 || len(hash.Salt) < PasswordSaltSize || len(hash.Key) != PasswordKeySize
+	}
+}
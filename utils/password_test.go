@@ -0,0 +1,146 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastHasher returns an Argon2id hasher with cheap parameters for tests.
+func fastHasher() *utils.PasswordHasher {
+	hasher := utils.NewPasswordHasher()
+	hasher.Argon2 = fastArgon2
+	return hasher
+}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher := fastHasher()
+	encoded, err := hasher.Hash(passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("expected a PHC string, got %s", encoded)
+	}
+
+	if err := hasher.Verify(encoded, passphrase); err != nil {
+		t.Errorf("expected password to verify, got %v", err)
+	}
+	if err := hasher.Verify(encoded, invalidpassphrase); !errors.Is(err, utils.ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+	if hasher.NeedsRehash(encoded) {
+		t.Error("expected no rehash for current parameters")
+	}
+
+	hash, err := utils.ParsePasswordHash(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hash.Argon2 != fastArgon2 || len(hash.Salt) != utils.PasswordSaltSize || hash.String() != encoded {
+		t.Errorf("unexpected parsed hash %+v", hash)
+	}
+}
+
+func TestParsePasswordHashReference(t *testing.T) {
+	// from the reference implementation: password "password", salt "somesalt"
+	encoded := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	if err := utils.NewPasswordHasher().Verify(encoded, []byte("password")); err != nil {
+		t.Errorf("expected reference hash to verify, got %v", err)
+	}
+}
+
+func TestPasswordHasherBcrypt(t *testing.T) {
+	hasher := utils.NewPasswordHasher()
+	hasher.Algorithm = utils.PasswordBcrypt
+	hasher.BcryptCost = bcrypt.MinCost
+
+	encoded, err := hasher.Hash(passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hasher.Verify(encoded, passphrase); err != nil {
+		t.Errorf("expected password to verify, got %v", err)
+	}
+	if err := hasher.Verify(encoded, invalidpassphrase); !errors.Is(err, utils.ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+
+	long := bytes.Repeat([]byte{'a'}, utils.BcryptMaxPasswordLength+1)
+	if _, err := hasher.Hash(long); !errors.Is(err, utils.ErrPasswordTooLong) {
+		t.Errorf("expected ErrPasswordTooLong, got %v", err)
+	}
+
+	// hashes of long passwords made before they were rejected cover the
+	// first 72 bytes only, and must verify as they did with BycrptVerify
+	truncated, _ := hasher.Hash(long[:utils.BcryptMaxPasswordLength])
+	if err := util.BycrptVerify([]byte(truncated), long); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hasher.Verify(truncated, long); err != nil {
+		t.Errorf("expected a legacy hash of a long password to verify, got %v", err)
+	}
+	if err := hasher.Verify(truncated, append(long[:utils.BcryptMaxPasswordLength-1:utils.BcryptMaxPasswordLength-1], 'b', 'a')); !errors.Is(err, utils.ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+	if !fastHasher().NeedsRehash(truncated) {
+		t.Error("expected a legacy hash of a long password to need a rehash to argon2id")
+	}
+}
+
+func TestPasswordHasherLegacyBcrypt(t *testing.T) {
+	legacy, err := util.BcryptHash(passphrase)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hasher := fastHasher()
+	if err := hasher.Verify(string(legacy), passphrase); err != nil {
+		t.Errorf("expected BcryptHash hashes to verify, got %v", err)
+	}
+	if !hasher.NeedsRehash(string(legacy)) {
+		t.Error("expected bcrypt hashes to need a rehash to argon2id")
+	}
+
+	hasher.Algorithm = utils.PasswordBcrypt
+	if hasher.NeedsRehash(string(legacy)) {
+		t.Error("expected no rehash for the default bcrypt cost")
+	}
+	hasher.BcryptCost = 12
+	if !hasher.NeedsRehash(string(legacy)) {
+		t.Error("expected a rehash for a higher bcrypt cost")
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	hasher := fastHasher()
+	encoded, _ := hasher.Hash(passphrase)
+
+	hasher.Argon2.Time = 2
+	if !hasher.NeedsRehash(encoded) {
+		t.Error("expected a rehash for changed argon2 parameters")
+	}
+	if !hasher.NeedsRehash("not a hash") {
+		t.Error("expected a rehash for an unparsable hash")
+	}
+}
+
+func TestParsePasswordHashInvalid(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=1,x=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=99999999,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc",
+		"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$!!",
+		"$2a$99$invalid",
+	} {
+		if _, err := utils.ParsePasswordHash(encoded); !errors.Is(err, utils.ErrUnsupportedPasswordHash) {
+			t.Errorf("%q: expected ErrUnsupportedPasswordHash, got %v", encoded, err)
+		}
+	}
+}
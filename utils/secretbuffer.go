@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"unsafe"
+)
+
+// Redacted replaces the content of a SecretBuffer in fmt, JSON and text output.
+const Redacted = "[REDACTED]"
+
+var (
+	// ErrSecretDestroyed is returned when a destroyed SecretBuffer is used.
+	ErrSecretDestroyed = errors.New("secret buffer has been destroyed")
+
+	// ErrInvalidSecretSize is returned for secret buffers without content.
+	ErrInvalidSecretSize = errors.New("secret buffer size must be positive")
+)
+
+// SecretBuffer holds a secret key in memory that is locked against swapping,
+// where the platform allows it, and zeroed by Destroy. The buffer occupies
+// whole memory pages of its own, so that locking and unlocking it does not
+// affect other data. It never prints or marshals its content, whether it is
+// used by pointer or by value.
+//
+// A SecretBuffer must not be destroyed while its bytes are in use. If it is
+// not destroyed explicitly, it is zeroed when it is garbage collected.
+type SecretBuffer struct {
+	memory []byte
+	data   []byte
+	locked bool
+}
+
+// NewSecretBuffer returns a zeroed buffer of the given size.
+func NewSecretBuffer(size int) (*SecretBuffer, error) {
+	if size <= 0 {
+		return nil, ErrInvalidSecretSize
+	}
+
+	// allocate whole pages plus one, so that the buffer can start at a page
+	// boundary and no other allocation shares its pages
+	pageSize := os.Getpagesize()
+	pages := (size + pageSize - 1) / pageSize * pageSize
+	memory := make([]byte, pages+pageSize)
+	offset := 0
+	if misalignment := int(uintptr(unsafe.Pointer(&memory[0])) % uintptr(pageSize)); misalignment != 0 {
+		offset = pageSize - misalignment
+	}
+
+	buffer := &SecretBuffer{
+		memory: memory[offset : offset+pages],
+	}
+	buffer.data = buffer.memory[:size]
+	buffer.locked = lockMemory(buffer.memory) == nil
+	runtime.SetFinalizer(buffer, (*SecretBuffer).Destroy)
+
+	return buffer, nil
+}
+
+// NewSecretBufferFromBytes copies data into a new buffer and zeroes data.
+func NewSecretBufferFromBytes(data []byte) (*SecretBuffer, error) {
+	buffer, err := NewSecretBuffer(len(data))
+	if err != nil {
+		return nil, err
+	}
+	copy(buffer.data, data)
+	clear(data)
+
+	return buffer, nil
+}
+
+// DecodeBase32SecretBuffer decodes a base32 secret like DecodeBase32Secret
+// into a new buffer. The intermediate decoded bytes are zeroed.
+func DecodeBase32SecretBuffer(encoded string) (*SecretBuffer, error) {
+	decoded, err := DecodeBase32Secret(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretBufferFromBytes(decoded)
+}
+
+// Bytes returns the secret. The slice shares memory with the buffer and must
+// not be retained after Destroy. It is nil once the buffer is destroyed.
+//
+// The slice does not keep the buffer alive: once the buffer is unreachable,
+// it is zeroed by the garbage collector even if the slice is still in use.
+// Code that only holds on to the slice must call runtime.KeepAlive on the
+// buffer after its last use of the slice.
+func (buffer *SecretBuffer) Bytes() []byte {
+	return buffer.data
+}
+
+// Len returns the size of the secret, or zero once the buffer is destroyed.
+func (buffer *SecretBuffer) Len() int {
+	return len(buffer.data)
+}
+
+// Locked reports whether the memory of the buffer is locked against
+// swapping. Locking fails without error if the platform does not support
+// it or the process exceeds its limit of locked memory.
+func (buffer *SecretBuffer) Locked() bool {
+	return buffer.locked
+}
+
+// Destroyed reports whether Destroy was called.
+func (buffer *SecretBuffer) Destroyed() bool {
+	return buffer.memory == nil
+}
+
+// Equal reports in constant time whether the secret equals other.
+func (buffer *SecretBuffer) Equal(other []byte) bool {
+	return !buffer.Destroyed() && subtle.ConstantTimeCompare(buffer.data, other) == 1
+}
+
+// Destroy zeroes the secret and unlocks its memory. It is safe to call
+// Destroy more than once.
+func (buffer *SecretBuffer) Destroy() {
+	if buffer.memory == nil {
+		return
+	}
+	clear(buffer.memory)
+	if buffer.locked {
+		unlockMemory(buffer.memory)
+	}
+
+	buffer.memory, buffer.data, buffer.locked = nil, nil, false
+	runtime.SetFinalizer(buffer, nil)
+}
+
+// String returns Redacted, so that the secret never ends up in logs.
+func (buffer SecretBuffer) String() string {
+	return Redacted
+}
+
+// GoString returns Redacted for the %#v verb.
+func (buffer SecretBuffer) GoString() string {
+	return Redacted
+}
+
+// Format writes Redacted for every verb, including %x and %v.
+func (buffer SecretBuffer) Format(state fmt.State, verb rune) {
+	state.Write([]byte(Redacted))
+}
+
+// MarshalJSON encodes the buffer as the string Redacted.
+func (buffer SecretBuffer) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + Redacted + `"`), nil
+}
+
+// MarshalText encodes the buffer as Redacted.
+func (buffer SecretBuffer) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
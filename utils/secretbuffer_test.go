@@ -0,0 +1,82 @@
+package utils_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestSecretBuffer(t *testing.T) {
+	source := []byte("12345678901234567890")
+	secret, err := utils.NewSecretBufferFromBytes(source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(source, make([]byte, len(source))) {
+		t.Error("expected the source to be zeroed")
+	}
+	if secret.Len() != 20 || !secret.Equal([]byte("12345678901234567890")) {
+		t.Errorf("unexpected secret content")
+	}
+	t.Logf("memory locked: %v", secret.Locked())
+
+	view := secret.Bytes()
+	secret.Destroy()
+	if !bytes.Equal(view, make([]byte, len(view))) {
+		t.Error("expected Destroy to zero the secret")
+	}
+	if !secret.Destroyed() || secret.Bytes() != nil || secret.Len() != 0 || secret.Equal(nil) {
+		t.Error("expected a destroyed buffer to be empty")
+	}
+	secret.Destroy()
+
+	if _, err := utils.NewSecretBuffer(0); !errors.Is(err, utils.ErrInvalidSecretSize) {
+		t.Errorf("expected ErrInvalidSecretSize, got %v", err)
+	}
+}
+
+func TestSecretBufferRedaction(t *testing.T) {
+	secret, _ := utils.NewSecretBufferFromBytes([]byte("supersecretvalue"))
+	defer secret.Destroy()
+
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%x", "%q"} {
+		if output := fmt.Sprintf(verb, secret); output != utils.Redacted {
+			t.Errorf("%s: expected %s, got %s", verb, utils.Redacted, output)
+		}
+		if output := fmt.Sprintf(verb, *secret); output != utils.Redacted {
+			t.Errorf("%s by value: expected %s, got %s", verb, utils.Redacted, output)
+		}
+	}
+
+	byValue := struct{ Secret utils.SecretBuffer }{*secret}
+	for _, verb := range []string{"%v", "%+v", "%#v"} {
+		if output := fmt.Sprintf(verb, byValue); bytes.Contains([]byte(output), []byte("supersecretvalue")) || !bytes.Contains([]byte(output), []byte(utils.Redacted)) {
+			t.Errorf("%s: expected a redacted struct field, got %s", verb, output)
+		}
+	}
+	if encoded, err := json.Marshal(byValue); err != nil || string(encoded) != `{"Secret":"[REDACTED]"}` {
+		t.Errorf("unexpected JSON %s, %v", encoded, err)
+	}
+
+	encoded, err := json.Marshal(struct {
+		Secret *utils.SecretBuffer `json:"secret"`
+	}{secret})
+	if err != nil || string(encoded) != `{"secret":"[REDACTED]"}` {
+		t.Errorf("unexpected JSON %s, %v", encoded, err)
+	}
+}
+
+func TestDecodeBase32SecretBuffer(t *testing.T) {
+	secret, err := utils.DecodeBase32SecretBuffer("jbsw y3dp fqqh o33s nrsc c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer secret.Destroy()
+	if !secret.Equal(codecKey) {
+		t.Error("unexpected secret content")
+	}
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SecretFormat identifies the text encoding of an imported secret key.
+type SecretFormat uint8
+
+const (
+	// SecretBase32 is the encoding of otpauth:// URLs and authenticator apps.
+	SecretBase32 SecretFormat = iota
+	// SecretHex is used by hardware tokens and PSKC files, among others.
+	SecretHex
+	// SecretBase64 is used by some exports; both the standard and the URL alphabet are accepted.
+	SecretBase64
+)
+
+// String returns the name of the SecretFormat.
+func (format SecretFormat) String() string {
+	switch format {
+	case SecretBase32:
+		return "base32"
+	case SecretHex:
+		return "hex"
+	case SecretBase64:
+		return "base64"
+	default:
+		return fmt.Sprintf("SecretFormat(%d)", uint8(format))
+	}
+}
+
+// Base32GroupSize is the number of characters per group of grouped base32 output.
+const Base32GroupSize = 4
+
+// ErrInvalidSecretLength is returned when a secret has a length that no key
+// encodes to, for example because a character was left out.
+var ErrInvalidSecretLength = errors.New("invalid secret length")
+
+// InvalidCharacterError reports a character that is not part of the alphabet
+// of the format, or padding in the middle of the secret. Position is the
+// 1-based character position in the original input, including whitespace.
+type InvalidCharacterError struct {
+	Format    SecretFormat
+	Position  int
+	Character rune
+}
+
+// Error implements the error interface.
+func (err *InvalidCharacterError) Error() string {
+	return fmt.Sprintf("invalid %s character %q at position %d", err.Format, err.Character, err.Position)
+}
+
+var unpaddedBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// normalizeSecret removes whitespace and trailing padding from encoded, maps
+// characters with mapChar and reports the first character that mapChar
+// rejects. Padding is only accepted at the end.
+func normalizeSecret(encoded string, format SecretFormat, mapChar func(rune) (rune, bool)) (string, error) {
+	trimmed := strings.TrimRightFunc(encoded, func(char rune) bool {
+		return char == '=' || unicode.IsSpace(char)
+	})
+
+	var normalized strings.Builder
+	position := 0
+	for _, char := range trimmed {
+		position++
+		if unicode.IsSpace(char) {
+			continue
+		}
+		mapped, valid := mapChar(char)
+		if !valid {
+			return "", &InvalidCharacterError{Format: format, Position: position, Character: char}
+		}
+		normalized.WriteRune(mapped)
+	}
+
+	return normalized.String(), nil
+}
+
+// NormalizeBase32 turns a base32 secret as typed by a user into the unpadded,
+// upper case form of otpauth:// URLs. Case, whitespace and padding are
+// ignored; invalid characters are reported as *InvalidCharacterError.
+func NormalizeBase32(encoded string) (string, error) {
+	normalized, err := normalizeSecret(encoded, SecretBase32, func(char rune) (rune, bool) {
+		char = unicode.ToUpper(char)
+		return char, (char >= 'A' && char <= 'Z') || (char >= '2' && char <= '7')
+	})
+	if err != nil {
+		return "", err
+	}
+	switch len(normalized) % 8 {
+	case 1, 3, 6:
+		return "", fmt.Errorf("%w: %d base32 characters", ErrInvalidSecretLength, len(normalized))
+	}
+
+	return normalized, nil
+}
+
+// DecodeBase32Secret decodes a base32 secret, ignoring case, whitespace and
+// padding.
+func DecodeBase32Secret(encoded string) ([]byte, error) {
+	normalized, err := NormalizeBase32(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return unpaddedBase32.DecodeString(normalized)
+}
+
+// DecodeHexSecret decodes a hex secret, ignoring case, whitespace, colons
+// and an 0x prefix.
+func DecodeHexSecret(encoded string) ([]byte, error) {
+	// blank out the prefix, so that error positions still match the input
+	if start := len(encoded) - len(strings.TrimLeftFunc(encoded, unicode.IsSpace)); strings.HasPrefix(encoded[start:], "0x") || strings.HasPrefix(encoded[start:], "0X") {
+		encoded = encoded[:start] + "  " + encoded[start+2:]
+	}
+
+	normalized, err := normalizeSecret(strings.ReplaceAll(encoded, ":", " "), SecretHex, func(char rune) (rune, bool) {
+		char = unicode.ToLower(char)
+		return char, (char >= '0' && char <= '9') || (char >= 'a' && char <= 'f')
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(normalized)%2 != 0 {
+		return nil, fmt.Errorf("%w: %d hex characters", ErrInvalidSecretLength, len(normalized))
+	}
+
+	return hex.DecodeString(normalized)
+}
+
+// DecodeBase64Secret decodes a base64 secret in the standard or the URL
+// alphabet, ignoring whitespace and padding.
+func DecodeBase64Secret(encoded string) ([]byte, error) {
+	normalized, err := normalizeSecret(encoded, SecretBase64, func(char rune) (rune, bool) {
+		switch {
+		case char == '-':
+			return '+', true
+		case char == '_':
+			return '/', true
+		}
+		return char, (char >= 'A' && char <= 'Z') || (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '+' || char == '/'
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(normalized)%4 == 1 {
+		return nil, fmt.Errorf("%w: %d base64 characters", ErrInvalidSecretLength, len(normalized))
+	}
+
+	return base64.RawStdEncoding.DecodeString(normalized)
+}
+
+// DecodeSecret decodes a secret key in the given format.
+func DecodeSecret(encoded string, format SecretFormat) ([]byte, error) {
+	switch format {
+	case SecretBase32:
+		return DecodeBase32Secret(encoded)
+	case SecretHex:
+		return DecodeHexSecret(encoded)
+	case SecretBase64:
+		return DecodeBase64Secret(encoded)
+	default:
+		return nil, fmt.Errorf("unsupported secret format %s", format)
+	}
+}
+
+// EncodeBase32Secret encodes a secret key as unpadded base32. If grouped is
+// true, the output is split into groups of four characters separated by
+// spaces, which is easier to type in manually. DecodeBase32Secret accepts
+// both forms.
+func EncodeBase32Secret(key []byte, grouped bool) string {
+	encoded := unpaddedBase32.EncodeToString(key)
+	if !grouped {
+		return encoded
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(encoded); i += Base32GroupSize {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(encoded[i:min(i+Base32GroupSize, len(encoded))])
+	}
+	return builder.String()
+}
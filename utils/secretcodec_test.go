@@ -0,0 +1,116 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+var codecKey = []byte("Hello, world!")
+
+func TestDecodeBase32Secret(t *testing.T) {
+	for _, encoded := range []string{
+		"JBSWY3DPFQQHO33SNRSCC===",
+		"JBSWY3DPFQQHO33SNRSCC",
+		"jbswy3dpfqqho33snrscc",
+		"jbsw y3dp fqqh o33s nrsc c",
+		" JBSW\tY3DP\nFQQH O33S NRSC C= ",
+	} {
+		decoded, err := utils.DecodeBase32Secret(encoded)
+		if err != nil || !bytes.Equal(decoded, codecKey) {
+			t.Errorf("%q: expected %q, got %q, %v", encoded, codecKey, decoded, err)
+		}
+	}
+}
+
+func TestDecodeBase32SecretErrors(t *testing.T) {
+	cases := []struct {
+		encoded   string
+		position  int
+		character rune
+	}{
+		{"JBSW Y3D1 FQQH", 9, '1'},
+		{"JBSWY3DP=FQQHO33S", 9, '='},
+		{"jbswy3dp-fqqh", 9, '-'},
+		{"äBSWY3DP", 1, 'ä'},
+	}
+	for _, c := range cases {
+		_, err := utils.DecodeBase32Secret(c.encoded)
+		var charErr *utils.InvalidCharacterError
+		if !errors.As(err, &charErr) {
+			t.Errorf("%q: expected an InvalidCharacterError, got %v", c.encoded, err)
+			continue
+		}
+		if charErr.Position != c.position || charErr.Character != c.character {
+			t.Errorf("%q: expected %q at position %d, got %v", c.encoded, c.character, c.position, charErr)
+		}
+	}
+
+	if _, err := utils.DecodeBase32Secret("JBSWY3DPF"); !errors.Is(err, utils.ErrInvalidSecretLength) {
+		t.Errorf("expected ErrInvalidSecretLength, got %v", err)
+	}
+}
+
+func TestEncodeBase32Secret(t *testing.T) {
+	if encoded := utils.EncodeBase32Secret(codecKey, false); encoded != "JBSWY3DPFQQHO33SNRSCC" {
+		t.Errorf("unexpected encoding %s", encoded)
+	}
+
+	grouped := utils.EncodeBase32Secret(codecKey, true)
+	if grouped != "JBSW Y3DP FQQH O33S NRSC C" {
+		t.Errorf("unexpected grouped encoding %s", grouped)
+	}
+	if decoded, err := utils.DecodeBase32Secret(grouped); err != nil || !bytes.Equal(decoded, codecKey) {
+		t.Errorf("expected grouped secret to round trip, got %v", err)
+	}
+	if utils.EncodeBase32Secret(nil, true) != "" {
+		t.Error("expected an empty secret to encode to an empty string")
+	}
+}
+
+func TestDecodeHexSecret(t *testing.T) {
+	for _, encoded := range []string{
+		"48656c6c6f2c20776f726c6421",
+		"0x48656C6C6F2C20776F726C6421",
+		"48:65:6c:6c:6f:2c:20:77:6f:72:6c:64:21",
+		"4865 6c6c 6f2c 2077 6f72 6c64 21",
+	} {
+		decoded, err := utils.DecodeSecret(encoded, utils.SecretHex)
+		if err != nil || !bytes.Equal(decoded, codecKey) {
+			t.Errorf("%q: expected %q, got %q, %v", encoded, codecKey, decoded, err)
+		}
+	}
+
+	var charErr *utils.InvalidCharacterError
+	if _, err := utils.DecodeHexSecret(" 0x4865g"); !errors.As(err, &charErr) || charErr.Position != 8 {
+		t.Errorf("expected an error at position 8, got %v", err)
+	}
+	if _, err := utils.DecodeHexSecret("486"); !errors.Is(err, utils.ErrInvalidSecretLength) {
+		t.Errorf("expected ErrInvalidSecretLength, got %v", err)
+	}
+}
+
+func TestDecodeBase64Secret(t *testing.T) {
+	for _, encoded := range []string{
+		"SGVsbG8sIHdvcmxkIQ==",
+		"SGVsbG8sIHdvcmxkIQ",
+		"SGVs bG8s IHdv cmxk IQ==",
+	} {
+		decoded, err := utils.DecodeSecret(encoded, utils.SecretBase64)
+		if err != nil || !bytes.Equal(decoded, codecKey) {
+			t.Errorf("%q: expected %q, got %q, %v", encoded, codecKey, decoded, err)
+		}
+	}
+
+	urlSafe := []byte{0xfb, 0xff}
+	if decoded, err := utils.DecodeBase64Secret("-_8"); err != nil || !bytes.Equal(decoded, urlSafe) {
+		t.Errorf("expected the URL alphabet to decode, got %v, %v", decoded, err)
+	}
+
+	var charErr *utils.InvalidCharacterError
+	if _, err := utils.DecodeBase64Secret("SGVs*G8s"); !errors.As(err, &charErr) || charErr.Position != 5 {
+		t.Errorf("expected an error at position 5, got %v", err)
+	}
+}
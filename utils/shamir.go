@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// ShareVersion is the version of the share encoding written by this package.
+	ShareVersion uint8 = 1
+
+	// shareSetIDSize is the size of the random id that all shares of a split have in common.
+	shareSetIDSize = 4
+
+	// shareChecksumSize is the size of the checksums of shares and of the secret.
+	shareChecksumSize = 4
+)
+
+var (
+	// ErrShareChecksum is returned when an encoded share is corrupted or mistyped.
+	ErrShareChecksum = errors.New("share checksum does not match")
+
+	// ErrShareMismatch is returned when shares of different splits are combined,
+	// or the same share is given twice.
+	ErrShareMismatch = errors.New("shares do not belong together")
+
+	// ErrNotEnoughShares is returned when fewer shares than the threshold are combined.
+	ErrNotEnoughShares = errors.New("not enough shares to recover the secret")
+
+	// ErrSecretIntegrity is returned when the recovered secret does not match
+	// the checksum that was split along with it.
+	ErrSecretIntegrity = errors.New("recovered secret failed the integrity check")
+)
+
+// Share is one part of a secret that was split with Shamir's secret sharing
+// scheme over GF(256). Any Threshold shares of the same split recover the
+// secret, while fewer reveal nothing about it.
+type Share struct {
+	// SetID is shared by all shares of one split.
+	SetID [shareSetIDSize]byte
+	// Threshold is the number of shares needed to recover the secret.
+	Threshold uint8
+	// Index is the x coordinate of the share, from 1 to 255.
+	Index uint8
+	// Value holds one polynomial value per byte of the secret and its checksum.
+	Value []byte
+}
+
+// SplitSecret splits secret into the given number of shares, any threshold
+// of which recover it. A checksum of the secret is split along with it, so
+// that CombineShares detects a wrong result. Threshold must be at least 2,
+// and shares at most 255.
+func SplitSecret(secret []byte, shares, threshold int) ([]*Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret must not be empty")
+	}
+	if threshold < 2 || shares < threshold || shares > 255 {
+		return nil, fmt.Errorf("invalid threshold %d of %d shares: need 2 <= threshold <= shares <= 255", threshold, shares)
+	}
+
+	var setID [shareSetIDSize]byte
+	if _, err := io.ReadFull(rand.Reader, setID[:]); err != nil {
+		return nil, err
+	}
+
+	payload := append(append([]byte(nil), secret...), secretChecksum(secret)...)
+	defer clear(payload)
+
+	result := make([]*Share, shares)
+	for i := range result {
+		result[i] = &Share{SetID: setID, Threshold: uint8(threshold), Index: uint8(i + 1), Value: make([]byte, len(payload))}
+	}
+
+	coefficients := make([]byte, threshold)
+	defer clear(coefficients)
+	for position, secretByte := range payload {
+		coefficients[0] = secretByte
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range result {
+			share.Value[position] = evaluatePolynomial(coefficients, share.Index)
+		}
+	}
+
+	return result, nil
+}
+
+// CombineShares recovers the secret from at least Threshold shares of the
+// same split.
+func CombineShares(shares []*Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrNotEnoughShares
+	}
+
+	first := shares[0]
+	if len(shares) < int(first.Threshold) {
+		return nil, fmt.Errorf("%w: have %d, need %d", ErrNotEnoughShares, len(shares), first.Threshold)
+	}
+	seen := make(map[uint8]bool)
+	for _, share := range shares {
+		if share.SetID != first.SetID || share.Threshold != first.Threshold || len(share.Value) != len(first.Value) {
+			return nil, ErrShareMismatch
+		}
+		if share.Index == 0 || seen[share.Index] {
+			return nil, ErrShareMismatch
+		}
+		seen[share.Index] = true
+	}
+	if len(first.Value) <= shareChecksumSize {
+		return nil, ErrShareMismatch
+	}
+
+	// Lagrange interpolation at x = 0 with the first threshold shares
+	shares = shares[:first.Threshold]
+	payload := make([]byte, len(first.Value))
+	defer clear(payload)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMultiply(basis, gfDivide(other.Index, other.Index^share.Index))
+			}
+		}
+		for position, value := range share.Value {
+			payload[position] ^= gfMultiply(value, basis)
+		}
+	}
+
+	size := len(payload) - shareChecksumSize
+	secret := append([]byte(nil), payload[:size]...)
+	if subtle.ConstantTimeCompare(secretChecksum(secret), payload[size:]) != 1 {
+		clear(secret)
+		return nil, ErrSecretIntegrity
+	}
+
+	return secret, nil
+}
+
+// UnlockKeyring recovers a master key from its shares and returns a keyring
+// with it as the primary key under the given id.
+func UnlockKeyring(primaryID string, shares []*Share) (*Keyring, error) {
+	key, err := CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+
+	return NewKeyring(primaryID, key)
+}
+
+// secretChecksum returns the checksum of a secret that is split along with it.
+func secretChecksum(secret []byte) []byte {
+	digest := sha256.Sum256(append([]byte("go-tiny-mfa/shamir/secret\x00"), secret...))
+	return digest[:shareChecksumSize]
+}
+
+// Encode returns the share as text for printing or QR codes: upper case
+// base32 in groups of four characters. The encoding carries a checksum that
+// detects typos when the share is parsed.
+//
+//	version (1) | set id (4) | threshold (1) | index (1) | value | checksum (4)
+func (share *Share) Encode() string {
+	encoded := []byte{ShareVersion}
+	encoded = append(encoded, share.SetID[:]...)
+	encoded = append(encoded, share.Threshold, share.Index)
+	encoded = append(encoded, share.Value...)
+	checksum := sha256.Sum256(encoded)
+	encoded = append(encoded, checksum[:shareChecksumSize]...)
+
+	return EncodeBase32Secret(encoded, true)
+}
+
+// ParseShare parses a share encoded with Encode. Case and whitespace are
+// ignored.
+func ParseShare(encoded string) (*Share, error) {
+	data, err := DecodeBase32Secret(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := 1 + shareSetIDSize + 2
+	if len(data) <= headerSize+shareChecksumSize {
+		return nil, fmt.Errorf("%w: share too short", ErrShareChecksum)
+	}
+	body, checksum := data[:len(data)-shareChecksumSize], data[len(data)-shareChecksumSize:]
+	digest := sha256.Sum256(body)
+	if !bytes.Equal(digest[:shareChecksumSize], checksum) {
+		return nil, ErrShareChecksum
+	}
+	if body[0] != ShareVersion {
+		return nil, fmt.Errorf("unsupported share version %d", body[0])
+	}
+
+	share := &Share{
+		Threshold: body[1+shareSetIDSize],
+		Index:     body[2+shareSetIDSize],
+		Value:     append([]byte(nil), body[headerSize:]...),
+	}
+	copy(share.SetID[:], body[1:])
+
+	return share, nil
+}
+
+// evaluatePolynomial evaluates the polynomial with the given coefficients,
+// lowest degree first, at x using Horner's method.
+func evaluatePolynomial(coefficients []byte, x byte) byte {
+	result := byte(0)
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMultiply(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(256) with the AES polynomial x^8+x^4+x^3+x+1.
+// It runs in constant time, without secret dependent branches or lookups.
+func gfMultiply(a, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		product ^= a & -(b & 1)
+		a = (a << 1) ^ (0x1b & -(a >> 7))
+		b >>= 1
+	}
+	return product
+}
+
+// gfDivide divides a by b in GF(256), using b^254 as the inverse of b. The
+// divisor must not be zero.
+func gfDivide(a, b byte) byte {
+	inverse := byte(1)
+	for i := 0; i < 254; i++ {
+		inverse = gfMultiply(inverse, b)
+	}
+	return gfMultiply(a, inverse)
+}
@@ -0,0 +1,136 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestSplitAndCombineShares(t *testing.T) {
+	shares, err := utils.SplitSecret(keyV1, 5, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		selected := make([]*utils.Share, 0, len(subset))
+		for _, i := range subset {
+			selected = append(selected, shares[i])
+		}
+		secret, err := utils.CombineShares(selected)
+		if err != nil || !bytes.Equal(secret, keyV1) {
+			t.Errorf("%v: expected the secret to be recovered, got %v", subset, err)
+		}
+	}
+
+	if _, err := utils.CombineShares(shares[:2]); !errors.Is(err, utils.ErrNotEnoughShares) {
+		t.Errorf("expected ErrNotEnoughShares, got %v", err)
+	}
+	if _, err := utils.CombineShares([]*utils.Share{shares[0], shares[0], shares[1]}); !errors.Is(err, utils.ErrShareMismatch) {
+		t.Errorf("expected ErrShareMismatch for a duplicated share, got %v", err)
+	}
+
+	other, _ := utils.SplitSecret(keyV2, 5, 3)
+	if _, err := utils.CombineShares([]*utils.Share{shares[0], shares[1], other[2]}); !errors.Is(err, utils.ErrShareMismatch) {
+		t.Errorf("expected ErrShareMismatch for shares of different splits, got %v", err)
+	}
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	for _, c := range []struct{ shares, threshold int }{{3, 1}, {2, 3}, {256, 3}} {
+		if _, err := utils.SplitSecret(keyV1, c.shares, c.threshold); err == nil {
+			t.Errorf("expected an error for %d of %d shares", c.threshold, c.shares)
+		}
+	}
+	if _, err := utils.SplitSecret(nil, 3, 2); err == nil {
+		t.Error("expected an error for an empty secret")
+	}
+}
+
+func TestSharesRevealNothingAlone(t *testing.T) {
+	// shares below the threshold of the same secret differ between splits
+	first, _ := utils.SplitSecret(keyV1, 3, 2)
+	second, _ := utils.SplitSecret(keyV1, 3, 2)
+	if bytes.Equal(first[0].Value, second[0].Value) {
+		t.Error("expected shares to be randomized")
+	}
+	if bytes.Contains(first[0].Value, keyV1[:8]) {
+		t.Error("expected a share not to contain the secret")
+	}
+}
+
+func TestShareEncoding(t *testing.T) {
+	shares, _ := utils.SplitSecret(keyV1, 3, 2)
+	encoded := shares[1].Encode()
+	if strings.ToUpper(encoded) != encoded || !strings.Contains(encoded, " ") {
+		t.Errorf("expected grouped upper case base32, got %s", encoded)
+	}
+
+	parsed, err := utils.ParseShare(strings.ToLower(encoded))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Index != 2 || parsed.Threshold != 2 || parsed.SetID != shares[1].SetID || !bytes.Equal(parsed.Value, shares[1].Value) {
+		t.Errorf("unexpected parsed share %+v", parsed)
+	}
+
+	// a mistyped character fails the share checksum
+	typo := []byte(encoded)
+	if typo[10] == 'A' {
+		typo[10] = 'B'
+	} else {
+		typo[10] = 'A'
+	}
+	if _, err := utils.ParseShare(string(typo)); !errors.Is(err, utils.ErrShareChecksum) {
+		t.Errorf("expected ErrShareChecksum, got %v", err)
+	}
+}
+
+func TestCombineSharesIntegrity(t *testing.T) {
+	shares, _ := utils.SplitSecret(keyV1, 3, 2)
+
+	// a share that was modified and re-encoded passes its own checksum, but
+	// the recovered secret fails its integrity check
+	shares[0].Value[3] ^= 1
+	tampered, err := utils.ParseShare(shares[0].Encode())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := utils.CombineShares([]*utils.Share{tampered, shares[1]}); !errors.Is(err, utils.ErrSecretIntegrity) {
+		t.Errorf("expected ErrSecretIntegrity, got %v", err)
+	}
+}
+
+func TestUnlockKeyring(t *testing.T) {
+	ciphertext, _ := util.EncryptWithKeyID(&data, &keyV1, "master")
+	shares, _ := utils.SplitSecret(keyV1, 5, 3)
+
+	encoded := []string{shares[4].Encode(), shares[0].Encode(), shares[2].Encode()}
+	parsed := make([]*utils.Share, 0, len(encoded))
+	for _, text := range encoded {
+		share, err := utils.ParseShare(text)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		parsed = append(parsed, share)
+	}
+
+	keyring, err := utils.UnlockKeyring("master", parsed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plaintext, err := keyring.Decrypt(ciphertext)
+	if err != nil || string(*plaintext) != string(data) {
+		t.Errorf("expected the unlocked keyring to decrypt, got %v", err)
+	}
+
+	if _, err := utils.UnlockKeyring("master", parsed[:2]); !errors.Is(err, utils.ErrNotEnoughShares) {
+		t.Errorf("expected ErrNotEnoughShares, got %v", err)
+	}
+}
@@ -0,0 +1,365 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// StreamMagic marks data encrypted by a stream writer.
+var StreamMagic = []byte("TMFS")
+
+const (
+	// StreamVersion is the version of the stream format written by this package.
+	StreamVersion uint8 = 1
+
+	// DefaultChunkSize is the plaintext size of a stream chunk.
+	DefaultChunkSize = 64 * 1024
+
+	// MaxChunkSize limits the chunk size accepted by stream readers, and thus
+	// the memory a crafted stream header can make a reader allocate.
+	MaxChunkSize = 16 * 1024 * 1024
+)
+
+var (
+	// ErrNoStream is returned when data does not start with StreamMagic.
+	ErrNoStream = errors.New("data is not an encrypted stream")
+
+	// ErrStreamTruncated is returned when an encrypted stream ends before its
+	// final chunk. It matches ErrCiphertextTooShort.
+	ErrStreamTruncated = fmt.Errorf("encrypted stream is truncated: %w", ErrCiphertextTooShort)
+
+	// ErrStreamCorrupted is returned when a chunk fails authentication, either
+	// because the stream was modified or because the key is wrong. It matches
+	// ErrDecryptionFailed.
+	ErrStreamCorrupted = fmt.Errorf("encrypted stream: %w", ErrDecryptionFailed)
+)
+
+// StreamHeader describes a chunked stream. The stream format splits the
+// plaintext into chunks of a fixed size, which are sealed individually. Its
+// layout is:
+//
+//	magic "TMFS" | version (1) | suite (1) | key id length (1) | key id |
+//	chunk size (4) | nonce prefix length (1) | nonce prefix |
+//	chunk | chunk | ... | final chunk
+//
+// The nonce of a chunk is the random nonce prefix, followed by the chunk
+// counter (4) and a final flag (1), as in the STREAM construction of Hoang,
+// Reyhanitabar, Rogaway and Vizár. The counter keeps chunks from being
+// reordered or dropped, and the final flag detects truncation: the last
+// chunk is always shorter than a full chunk and sealed with the flag set.
+// Every chunk authenticates the encoded header as additional data.
+type StreamHeader struct {
+	Version     uint8
+	Suite       CipherSuite
+	KeyID       string
+	ChunkSize   uint32
+	NoncePrefix []byte
+}
+
+// Marshal returns the binary form of the stream header.
+func (header *StreamHeader) Marshal() []byte {
+	encoded := append([]byte(nil), StreamMagic...)
+	encoded = append(encoded, header.Version, byte(header.Suite), byte(len(header.KeyID)))
+	encoded = append(encoded, header.KeyID...)
+	encoded = binary.BigEndian.AppendUint32(encoded, header.ChunkSize)
+	encoded = append(encoded, byte(len(header.NoncePrefix)))
+	return append(encoded, header.NoncePrefix...)
+}
+
+// ReadStreamHeader reads and validates the header of a chunked stream.
+func ReadStreamHeader(source io.Reader) (*StreamHeader, error) {
+	readField := func(size int) ([]byte, error) {
+		field := make([]byte, size)
+		if _, err := io.ReadFull(source, field); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrStreamTruncated, err)
+		}
+		return field, nil
+	}
+
+	fixed, err := readField(len(StreamMagic) + 3)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(fixed, StreamMagic) {
+		return nil, ErrNoStream
+	}
+	header := &StreamHeader{
+		Version: fixed[len(StreamMagic)],
+		Suite:   CipherSuite(fixed[len(StreamMagic)+1]),
+	}
+	if header.Version != StreamVersion {
+		return nil, fmt.Errorf("%w: stream version %d", ErrUnsupportedEnvelope, header.Version)
+	}
+
+	keyID, err := readField(int(fixed[len(StreamMagic)+2]))
+	if err != nil {
+		return nil, err
+	}
+	header.KeyID = string(keyID)
+
+	fields, err := readField(5)
+	if err != nil {
+		return nil, err
+	}
+	header.ChunkSize = binary.BigEndian.Uint32(fields)
+	if header.ChunkSize < 1 || header.ChunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("%w: chunk size %d", ErrUnsupportedEnvelope, header.ChunkSize)
+	}
+	if header.NoncePrefix, err = readField(int(fields[4])); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// streamNonce builds the nonce of a chunk.
+func streamNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, len(prefix), len(prefix)+5)
+	copy(nonce, prefix)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// streamWriter encrypts everything written to it as a chunked stream.
+type streamWriter struct {
+	destination io.Writer
+	aead        cipher.AEAD
+	header      []byte
+	prefix      []byte
+	buffer      []byte
+	sealed      []byte
+	counter     uint32
+	closed      bool
+	err         error
+}
+
+// NewStreamWriter returns a writer that encrypts everything written to it
+// with the given key and cipher suite and writes the stream to destination.
+// The key id is recorded in the stream header and may be empty. A chunkSize
+// of zero selects DefaultChunkSize. Memory use is bounded by the chunk size.
+// Close must be called to write the final chunk; it does not close
+// destination.
+func NewStreamWriter(destination io.Writer, key []byte, keyID string, suite CipherSuite, chunkSize int) (io.WriteCloser, error) {
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 1 || chunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("chunk size must be between 1 and %d bytes", MaxChunkSize)
+	}
+	if len(keyID) > 255 {
+		return nil, errors.New("key id must not exceed 255 bytes")
+	}
+	aead, err := newAEAD(suite, key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &StreamHeader{
+		Version:     StreamVersion,
+		Suite:       suite,
+		KeyID:       keyID,
+		ChunkSize:   uint32(chunkSize),
+		NoncePrefix: make([]byte, aead.NonceSize()-5),
+	}
+	if _, err := io.ReadFull(rand.Reader, header.NoncePrefix); err != nil {
+		return nil, err
+	}
+	encoded := header.Marshal()
+	if _, err := destination.Write(encoded); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		destination: destination,
+		aead:        aead,
+		header:      encoded,
+		prefix:      header.NoncePrefix,
+		buffer:      make([]byte, 0, chunkSize),
+		sealed:      make([]byte, 0, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Write buffers p and writes every completed chunk.
+func (writer *streamWriter) Write(p []byte) (int, error) {
+	if writer.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	if writer.err != nil {
+		return 0, writer.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), cap(writer.buffer)-len(writer.buffer))
+		writer.buffer = append(writer.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(writer.buffer) == cap(writer.buffer) {
+			if writer.err = writer.flush(false); writer.err != nil {
+				return written, writer.err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// flush seals and writes the buffered plaintext as one chunk.
+func (writer *streamWriter) flush(final bool) error {
+	if writer.counter == math.MaxUint32 {
+		return errors.New("encrypted stream exceeds the maximum number of chunks")
+	}
+
+	nonce := streamNonce(writer.prefix, writer.counter, final)
+	writer.sealed = writer.aead.Seal(writer.sealed[:0], nonce, writer.buffer, writer.header)
+	clear(writer.buffer)
+	writer.buffer = writer.buffer[:0]
+	writer.counter++
+
+	_, err := writer.destination.Write(writer.sealed)
+	return err
+}
+
+// Close writes the final chunk, which holds the remaining plaintext.
+func (writer *streamWriter) Close() error {
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+	if writer.err != nil {
+		return writer.err
+	}
+
+	return writer.flush(true)
+}
+
+// streamReader decrypts a chunked stream.
+type streamReader struct {
+	source    io.Reader
+	aead      cipher.AEAD
+	header    []byte
+	prefix    []byte
+	chunk     []byte
+	plaintext []byte
+	pending   []byte
+	counter   uint32
+	final     bool
+	err       error
+}
+
+// NewStreamReader reads the stream header from source and returns a reader
+// that yields the decrypted plaintext. Every chunk is authenticated before it
+// is returned; a stream that ends without its final chunk fails with
+// ErrStreamTruncated. If an error occurs, plaintext that was already read
+// must be discarded.
+func NewStreamReader(source io.Reader, key []byte) (io.Reader, error) {
+	header, err := ReadStreamHeader(source)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamReader(source, header, key)
+}
+
+// newStreamReader returns a reader for the chunks that follow header.
+func newStreamReader(source io.Reader, header *StreamHeader, key []byte) (*streamReader, error) {
+	aead, err := newAEAD(header.Suite, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(header.NoncePrefix) != aead.NonceSize()-5 {
+		return nil, ErrMalformedEnvelope
+	}
+
+	return &streamReader{
+		source:    source,
+		aead:      aead,
+		header:    header.Marshal(),
+		prefix:    header.NoncePrefix,
+		chunk:     make([]byte, int(header.ChunkSize)+aead.Overhead()),
+		plaintext: make([]byte, 0, header.ChunkSize),
+	}, nil
+}
+
+// Read returns decrypted plaintext, reading and opening chunks as needed.
+func (reader *streamReader) Read(p []byte) (int, error) {
+	for len(reader.pending) == 0 {
+		if reader.final {
+			return 0, io.EOF
+		}
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		reader.err = reader.next()
+	}
+
+	n := copy(p, reader.pending)
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+// next reads and opens the next chunk. A chunk that fills the whole buffer is
+// never the final one, because the writer always ends with a shorter chunk.
+func (reader *streamReader) next() error {
+	n, err := io.ReadFull(reader.source, reader.chunk)
+	final := false
+	switch {
+	case err == nil:
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case errors.Is(err, io.EOF):
+		return ErrStreamTruncated
+	default:
+		return err
+	}
+	if reader.counter == math.MaxUint32 {
+		return errors.New("encrypted stream exceeds the maximum number of chunks")
+	}
+
+	nonce := streamNonce(reader.prefix, reader.counter, final)
+	plaintext, err := reader.aead.Open(reader.plaintext[:0], nonce, reader.chunk[:n], reader.header)
+	if err != nil {
+		return ErrStreamCorrupted
+	}
+	reader.counter++
+	reader.final = final
+	reader.pending = plaintext
+
+	return nil
+}
+
+// EncryptStream encrypts everything read from source with AES-GCM and writes
+// it as a chunked stream to destination, using constant memory.
+func (util *TinyMfaUtil) EncryptStream(destination io.Writer, source io.Reader, passphrase *[]byte) error {
+	writer, err := NewStreamWriter(destination, *passphrase, "", SuiteAESGCM, DefaultChunkSize)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, source); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// DecryptStream decrypts a chunked stream read from source and writes the
+// plaintext to destination, using constant memory. If an error is returned,
+// the plaintext already written to destination must be discarded.
+func (util *TinyMfaUtil) DecryptStream(destination io.Writer, source io.Reader, passphrase *[]byte) error {
+	reader, err := NewStreamReader(source, *passphrase)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, reader)
+	return err
+}
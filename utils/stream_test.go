@@ -0,0 +1,160 @@
+package utils_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+const testChunkSize = 16
+
+// encryptStream encrypts plaintext with a small chunk size, so that a short
+// input spans several chunks.
+func encryptStream(t *testing.T, plaintext, key []byte, suite utils.CipherSuite) []byte {
+	t.Helper()
+	var ciphertext bytes.Buffer
+	writer, err := utils.NewStreamWriter(&ciphertext, key, "", suite, testChunkSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return ciphertext.Bytes()
+}
+
+// decryptStream reads a whole stream with the given key.
+func decryptStream(ciphertext, key []byte) ([]byte, error) {
+	reader, err := utils.NewStreamReader(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, 3 * testChunkSize, 3*testChunkSize + 5} {
+		plaintext := bytes.Repeat([]byte{'x'}, size)
+		for _, suite := range []utils.CipherSuite{utils.SuiteAESGCM, utils.SuiteXChaCha20Poly1305} {
+			ciphertext := encryptStream(t, plaintext, keyV1, suite)
+			decrypted, err := decryptStream(ciphertext, keyV1)
+			if err != nil {
+				t.Fatalf("%s, %d bytes: unexpected error: %v", suite, size, err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("%s, %d bytes: plaintext does not match", suite, size)
+			}
+		}
+	}
+}
+
+func TestStreamHeader(t *testing.T) {
+	var ciphertext bytes.Buffer
+	writer, _ := utils.NewStreamWriter(&ciphertext, keyV1, "v1", utils.SuiteXChaCha20Poly1305, 0)
+	writer.Close()
+
+	header, err := utils.ReadStreamHeader(&ciphertext)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header.KeyID != "v1" || header.Suite != utils.SuiteXChaCha20Poly1305 || header.ChunkSize != utils.DefaultChunkSize {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	if _, err := utils.ReadStreamHeader(bytes.NewReader(data)); !errors.Is(err, utils.ErrNoStream) {
+		t.Errorf("expected ErrNoStream, got %v", err)
+	}
+}
+
+func TestStreamTruncation(t *testing.T) {
+	plaintext := bytes.Repeat([]byte{'x'}, 3*testChunkSize+5)
+	ciphertext := encryptStream(t, plaintext, keyV1, utils.SuiteAESGCM)
+	header, _ := utils.ReadStreamHeader(bytes.NewReader(ciphertext))
+	headerSize := len(header.Marshal())
+	sealedChunk := testChunkSize + 16
+
+	// cut exactly at a chunk boundary, which makes the stream look complete
+	// unless the final flag is checked
+	for _, chunks := range []int{1, 3} {
+		truncated := ciphertext[:headerSize+chunks*sealedChunk]
+		if _, err := decryptStream(truncated, keyV1); !errors.Is(err, utils.ErrStreamTruncated) {
+			t.Errorf("expected ErrStreamTruncated after %d chunks, got %v", chunks, err)
+		}
+	}
+
+	// cut within a chunk, which is then taken for a final chunk
+	truncated := ciphertext[:headerSize+2*sealedChunk+5]
+	if _, err := decryptStream(truncated, keyV1); !errors.Is(err, utils.ErrStreamCorrupted) {
+		t.Errorf("expected ErrStreamCorrupted for a cut chunk, got %v", err)
+	}
+
+	if _, err := decryptStream(ciphertext[:headerSize-1], keyV1); !errors.Is(err, utils.ErrStreamTruncated) {
+		t.Errorf("expected ErrStreamTruncated for a truncated header, got %v", err)
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	plaintext := bytes.Repeat([]byte{'x'}, 3*testChunkSize)
+	ciphertext := encryptStream(t, plaintext, keyV1, utils.SuiteAESGCM)
+
+	modified := bytes.Clone(ciphertext)
+	modified[len(modified)-20] ^= 1
+	if _, err := decryptStream(modified, keyV1); !errors.Is(err, utils.ErrStreamCorrupted) {
+		t.Errorf("expected ErrStreamCorrupted for a modified chunk, got %v", err)
+	}
+
+	if _, err := decryptStream(ciphertext, keyV2); !errors.Is(err, utils.ErrStreamCorrupted) {
+		t.Errorf("expected ErrStreamCorrupted for a wrong key, got %v", err)
+	}
+}
+
+func TestEncryptStream(t *testing.T) {
+	var ciphertext, decrypted bytes.Buffer
+	if err := util.EncryptStream(&ciphertext, bytes.NewReader(data), &passphrase); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := util.DecryptStream(&decrypted, &ciphertext, &passphrase); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decrypted.String() != string(data) {
+		t.Errorf("expected %s, got %s", data, decrypted.String())
+	}
+}
+
+func TestDecryptFileFormats(t *testing.T) {
+	dir := t.TempDir()
+
+	streamFile := filepath.Join(dir, "stream.enc")
+	if err := util.EncryptFile(streamFile, &data, &passphrase); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, _ := os.ReadFile(streamFile)
+	if !bytes.HasPrefix(content, utils.StreamMagic) {
+		t.Error("expected EncryptFile to write a stream")
+	}
+
+	envelopeFile := filepath.Join(dir, "envelope.enc")
+	ciphertext, _ := util.Encrypt(&data, &passphrase)
+	if err := os.WriteFile(envelopeFile, *ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{streamFile, envelopeFile} {
+		decrypted, err := util.DecryptFile(file, &passphrase)
+		if err != nil || string(*decrypted) != string(data) {
+			t.Errorf("expected %s to decrypt, got %v", file, err)
+		}
+	}
+
+	if _, err := util.DecryptFile(filepath.Join(dir, "missing.enc"), &passphrase); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -1,38 +1,74 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base32"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 type TinyMfaUtilInterface interface {
 	// Encrypt takes in a byte array as data and another byte array as the passphrase,
 	// encrypts the data using the AES cipher and returns the encrypted data (also as byte array)
-	// please note that the nonce needed to encrypt the data using AES GCM is appended to the byte array
+	// please note that the data is wrapped in an Envelope that carries the nonce
 	Encrypt(data, passphrase *[]byte) (*[]byte, error)
 
+	// EncryptWithKeyID works like Encrypt, but records the given key identifier
+	// in the Envelope, so that the matching key can be looked up on decryption
+	EncryptWithKeyID(data, passphrase *[]byte, keyID string) (*[]byte, error)
+
 	// EncryptFile takes a filePath as a string and a passphrase as a byte array.
-	// The file found at filePath is then Encrypted using the Encrypt Method
-	// and then wrote back to the original filePath
+	// The data is encrypted as a chunked stream and written to filePath,
+	// which is locked against concurrent writers and replaced atomically
 	EncryptFile(filePath string, data, passphrase *[]byte) error
 
+	// EncryptStream encrypts everything read from source with AES-GCM and writes
+	// it as a chunked stream to destination, using constant memory
+	EncryptStream(destination io.Writer, source io.Reader, passphrase *[]byte) error
+
+	// DecryptStream decrypts a chunked stream read from source and writes the
+	// plaintext to destination, using constant memory. Truncated or modified
+	// streams fail with ErrStreamTruncated or ErrStreamCorrupted
+	DecryptStream(destination io.Writer, source io.Reader, passphrase *[]byte) error
+
+	// EncryptWithSuite works like Encrypt, but uses the given cipher suite, such as
+	// SuiteXChaCha20Poly1305 for systems without AES hardware support
+	EncryptWithSuite(data, passphrase *[]byte, suite CipherSuite) (*[]byte, error)
+
+	// EncryptWithAssociatedData works like Encrypt, but binds the ciphertext to the
+	// associated data, which has to be passed again on decryption
+	EncryptWithAssociatedData(data, passphrase *[]byte, associatedData []byte) (*[]byte, error)
+
+	// DecryptWithAssociatedData decrypts data that was encrypted with EncryptWithAssociatedData
+	DecryptWithAssociatedData(data, passphrase *[]byte, associatedData []byte) (*[]byte, error)
+
+	// EncryptWithPassphrase derives an AES-256 key from the passphrase with Argon2id
+	// and a random salt, and encrypts the data with it. Salt and cost parameters
+	// are stored in the Envelope
+	EncryptWithPassphrase(data, passphrase *[]byte, params Argon2Params) (*[]byte, error)
+
+	// DecryptWithPassphrase decrypts data that was encrypted with EncryptWithPassphrase
+	DecryptWithPassphrase(data, passphrase *[]byte) (*[]byte, error)
+
 	// Decrypt takes in two byte arrays. The former one is the encrypted data,
 	// the second one is the passphrase that shall be used.
 	// The method returns the decrypted data in another byte array
-	// Attention: It is assumed that a nonce is appended to the encrypted
-	// byte array!
+	// Both Envelopes and legacy data, a raw nonce followed by the ciphertext,
+	// are supported
 	Decrypt(data, passphrase *[]byte) (*[]byte, error)
 
 	// DecryptFile takes a filePath as a string and a passphrase as a byte array.
-	// The file found at filePath is then decrypted using the Decrypt Method
-	// and then wrote back to the original filePath
+	// The file found at filePath is then decrypted and returned. Chunked
+	// streams, Envelopes and legacy data are supported
 	DecryptFile(filePath string, passphrase *[]byte) (*[]byte, error)
 
 	// DecodeBase32Key Decodes a base32 encoded key to a byte array
@@ -41,7 +77,8 @@ type TinyMfaUtilInterface interface {
 	// EncodeBase32Key encodes a byte array to a base32 encoded string
 	EncodeBase32Key(key *[]byte) *string
 
-	// BcryptHash hashes a given byte array with bcrypt and a cost of 10
+	// BcryptHash hashes a given byte array with bcrypt and a cost of 10.
+	// PasswordHasher supports other costs as well as Argon2id
 	BcryptHash(tohash []byte) ([]byte, error)
 
 	// BycrptVerify compares a bcrypted comparable and its plain byte array
@@ -56,86 +93,251 @@ func NewTinyMfaUtil() TinyMfaUtilInterface {
 
 // Encrypt takes in a byte array as data and another byte array as the passphrase,
 // encrypts the data using the AES cipher and returns the encrypted data (also as byte array)
-// please note that the nonce needed to encrypt the data using AES GCM is appended to the byte array
+// please note that the data is wrapped in an Envelope that carries the nonce
 func (util *TinyMfaUtil) Encrypt(data, passphrase *[]byte) (*[]byte, error) {
-	if len(*passphrase) != 16 && len(*passphrase) != 32 {
-		return nil, errors.New("keysize not supported")
+	return util.EncryptWithKeyID(data, passphrase, "")
+}
+
+// EncryptWithKeyID works like Encrypt, but records the given key identifier
+// in the Envelope, so that the matching key can be looked up on decryption
+func (util *TinyMfaUtil) EncryptWithKeyID(data, passphrase *[]byte, keyID string) (*[]byte, error) {
+	if len(keyID) > 255 {
+		return nil, errors.New("key id must not exceed 255 bytes")
 	}
-	block, _ := aes.NewCipher(*passphrase)
-	gcm, err := cipher.NewGCM(block)
+
+	envelope := &Envelope{
+		Version: EnvelopeVersion,
+		Suite:   SuiteAESGCM,
+		KeyID:   keyID,
+	}
+	return sealEnvelope(envelope, *data, *passphrase, nil)
+}
+
+// EncryptWithSuite works like Encrypt, but uses the given cipher suite. Use
+// SuiteXChaCha20Poly1305 with a 32 byte key on systems without AES hardware
+// support
+func (util *TinyMfaUtil) EncryptWithSuite(data, passphrase *[]byte, suite CipherSuite) (*[]byte, error) {
+	envelope := &Envelope{
+		Version: EnvelopeVersion,
+		Suite:   suite,
+	}
+	return sealEnvelope(envelope, *data, *passphrase, nil)
+}
+
+// EncryptWithAssociatedData works like Encrypt, but binds the ciphertext to
+// the associated data, such as the id of the record it belongs to. The
+// associated data is not stored; decryption fails unless the same associated
+// data is passed to DecryptWithAssociatedData
+func (util *TinyMfaUtil) EncryptWithAssociatedData(data, passphrase *[]byte, associatedData []byte) (*[]byte, error) {
+	envelope := &Envelope{
+		Version: EnvelopeVersion,
+		Suite:   SuiteAESGCM,
+	}
+	return sealEnvelope(envelope, *data, *passphrase, associatedData)
+}
+
+// sealEnvelope generates a nonce for the envelope, encrypts plaintext with the
+// cipher suite of the envelope and returns the marshalled envelope. The header
+// and the associated data are both authenticated
+func sealEnvelope(envelope *Envelope, plaintext, key, associatedData []byte) (*[]byte, error) {
+	aead, err := newAEAD(envelope.Suite, key)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, envelope.Nonce); err != nil {
 		return nil, err
 	}
-	ciphertext := gcm.Seal(nonce, nonce, *data, nil)
+
+	header := envelope.Header()
+	ciphertext := append(header, aead.Seal(nil, envelope.Nonce, plaintext, additionalData(header, associatedData))...)
 	return &ciphertext, nil
 }
 
+// additionalData returns the data authenticated along with the ciphertext of an envelope
+func additionalData(header, associatedData []byte) []byte {
+	if len(associatedData) == 0 {
+		return header
+	}
+	return append(header[:len(header):len(header)], associatedData...)
+}
+
+// newAEAD returns the authenticated cipher of a cipher suite
+func newAEAD(suite CipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case SuiteAESGCM:
+		return newAESGCM(key)
+	case SuiteXChaCha20Poly1305:
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, ErrInvalidKeySize
+		}
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("%w: cipher suite %s", ErrUnsupportedEnvelope, suite)
+	}
+}
+
+// newAESGCM returns an AES-GCM cipher for a 128 or 256 bit key
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, ErrInvalidKeySize
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
 // EncryptFile takes a filePath as a string and a passphrase as a byte array.
-// The file found at filePath is then Encrypted using the Encrypt Method
-// and then wrote back to the original filePath
+// The data is encrypted as a chunked stream and written to filePath,
+// which is locked against concurrent writers and replaced atomically by a
+// synced temporary file with 0600 permissions
 func (util *TinyMfaUtil) EncryptFile(filePath string, data, passphrase *[]byte) error {
-	f, _ := os.Create(filePath)
-	defer f.Close()
+	if _, err := newAEAD(SuiteAESGCM, *passphrase); err != nil {
+		return err
+	}
 
-	cdata, err := util.Encrypt(data, passphrase)
+	lock, err := LockFile(filePath)
 	if err != nil {
 		return err
 	}
+	defer lock.Unlock()
 
-	f.Write(*cdata)
-
-	return nil
+	return writeFileAtomicFunc(filePath, 0600, func(destination io.Writer) error {
+		return util.EncryptStream(destination, bytes.NewReader(*data), passphrase)
+	})
 }
 
 // Decrypt takes in two byte arrays. The former one is the encrypted data,
 // the second one is the passphrase that shall be used.
 // The method returns the decrypted data in another byte array
-// Attention: It is assumed that a nonce is appended to the encrypted
-// byte array!
+// Both Envelopes and legacy data, a raw nonce followed by the ciphertext,
+// are supported
 func (util *TinyMfaUtil) Decrypt(data, passphrase *[]byte) (*[]byte, error) {
-	if len(*passphrase) != 16 && len(*passphrase) != 32 {
-		return nil, errors.New("keysize not supported")
+	return util.DecryptWithAssociatedData(data, passphrase, nil)
+}
+
+// DecryptWithAssociatedData decrypts data that was encrypted with
+// EncryptWithAssociatedData. It fails if the associated data differs, for
+// example because the ciphertext was copied into another record
+func (util *TinyMfaUtil) DecryptWithAssociatedData(data, passphrase *[]byte, associatedData []byte) (*[]byte, error) {
+	plaintext, err := openData(*data, *passphrase, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	dataslice := *data
+	for i := range dataslice {
+		dataslice[i] = 0 // clear the memory of the decrypted data for security reasons.
+	}
+
+	return &plaintext, nil
+}
+
+// openData dispatches on the envelope header. Legacy data is tried whenever
+// no valid envelope could be opened, since a random legacy nonce may happen
+// to start with the envelope magic. Legacy data cannot carry associated data,
+// so it is never accepted when associated data is expected.
+func openData(data, key, associatedData []byte) ([]byte, error) {
+	envelope, err := ParseEnvelope(data)
+	if errors.Is(err, ErrNoEnvelope) {
+		if len(associatedData) > 0 {
+			return nil, err
+		}
+		return openLegacy(data, key)
+	}
+	if err == nil && envelope.KDF == KDFKMS {
+		err = ErrKMSEnvelope
+	} else if err == nil && envelope.KDF != KDFNone {
+		err = ErrPassphraseRequired
+	}
+	if err == nil {
+		var plaintext []byte
+		if plaintext, err = openEnvelope(envelope, key, associatedData); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	if len(associatedData) == 0 {
+		if plaintext, legacyErr := openLegacy(data, key); legacyErr == nil {
+			return plaintext, nil
+		}
 	}
-	block, err := aes.NewCipher(*passphrase)
+	return nil, err
+}
+
+// openEnvelope decrypts the ciphertext of a parsed envelope
+func openEnvelope(envelope *Envelope, key, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(envelope.Suite, key)
 	if err != nil {
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return nil, ErrMalformedEnvelope
+	}
+	if len(envelope.Ciphertext) < aead.Overhead() {
+		return nil, ErrCiphertextTooShort
+	}
+	plaintext, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, additionalData(envelope.Header(), associatedData))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// openLegacy decrypts data that consists of a raw nonce and the GCM ciphertext
+func openLegacy(data, key []byte) ([]byte, error) {
+	gcm, err := newAESGCM(key)
 	if err != nil {
 		return nil, err
 	}
 	nonceSize := gcm.NonceSize()
-	dataslice := *data
+	if len(data) < nonceSize+gcm.Overhead() {
+		return nil, ErrCiphertextTooShort
+	}
 
-	nonce, ciphertext := dataslice[:nonceSize], dataslice[nonceSize:]
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// DecryptFile takes a filePath as a string and a passphrase as a byte array.
+// The file found at filePath is then decrypted and returned. Chunked
+// streams, Envelopes and legacy data are supported
+func (util *TinyMfaUtil) DecryptFile(filePath string, passphrase *[]byte) (*[]byte, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	for i := range dataslice {
-		dataslice[i] = 0 // clear the memory of the decrypted data for security reasons.
+	source := bufio.NewReader(file)
+	if magic, _ := source.Peek(len(StreamMagic)); !bytes.Equal(magic, StreamMagic) {
+		data, err := io.ReadAll(source)
+		if err != nil {
+			return nil, err
+		}
+		return util.Decrypt(&data, passphrase)
 	}
 
-	return &plaintext, nil
-}
+	var plaintext bytes.Buffer
+	if err := util.DecryptStream(&plaintext, source, passphrase); err != nil {
+		clear(plaintext.Bytes())
+		return nil, err
+	}
+	data := plaintext.Bytes()
 
-// DecryptFile takes a filePath as a string and a passphrase as a byte array.
-// The file found at filePath is then decrypted using the Decrypt Method
-// and then wrote back to the original filePath
-func (util *TinyMfaUtil) DecryptFile(filePath string, passphrase *[]byte) (*[]byte, error) {
-	data, _ := os.ReadFile(filePath)
-	return util.Decrypt(&data, passphrase)
+	return &data, nil
 }
 
 // DecodeBase32Key Decodes a base32 encoded key to a byte array
 func (util *TinyMfaUtil) DecodeBase32Key(encodedKey *string) (*[]byte, error) {
-	key, err := base32.StdEncoding.DecodeString(*encodedKey)
+	key, err := DecodeBase32Secret(*encodedKey)
 	return &key, err
 }
 
@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LockFileSuffix is appended to the path of an encrypted file to name its
+// lock file. Lock files are left in place, since removing them would race
+// with other writers, and are skipped by ReencryptDirectory.
+const LockFileSuffix = ".lock"
+
+var (
+	// ErrInvalidKeySize is returned when a key does not fit the cipher suite.
+	ErrInvalidKeySize = errors.New("keysize not supported")
+
+	// ErrCiphertextTooShort is returned when the input is too short to hold a
+	// nonce and an authentication tag, for example because it was truncated.
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+
+	// ErrDecryptionFailed is returned when the ciphertext fails authentication.
+	// An authenticated cipher cannot tell a corrupted ciphertext from a wrong
+	// key, so both are reported with this error.
+	ErrDecryptionFailed = errors.New("ciphertext is corrupted or the key is wrong")
+)
+
+// FileLock is an advisory lock that serializes writers of an encrypted file.
+// It is held on a separate lock file, since the encrypted file itself is
+// replaced on every write.
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile blocks until it holds the exclusive lock of the file at path.
+// Readers do not need the lock, since writes replace files atomically, but
+// every read-modify-write cycle should hold it.
+func LockFile(path string) (*FileLock, error) {
+	file, err := os.OpenFile(path+LockFileSuffix, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// Unlock releases the lock.
+func (lock *FileLock) Unlock() error {
+	err := unlockFile(lock.file)
+	if closeErr := lock.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// WriteFileAtomic writes data to path while holding its lock. The data is
+// written to a temporary file in the same directory, synced and renamed over
+// path, so readers see either the old or the new content, even after a crash.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	lock, err := LockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return writeFileAtomic(path, data, perm)
+}
+
+// UpdateFileAtomic reads the file at path and replaces it with the result
+// of update while holding its lock, keeping its permissions. update also
+// receives the FileInfo of the file that was read, for example to check its
+// permissions. If update returns nil data, the file is left untouched.
+func UpdateFileAtomic(path string, update func(data []byte, info os.FileInfo) ([]byte, error)) error {
+	lock, err := LockFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	handle, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	info, err := handle.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(handle)
+	if err != nil {
+		return err
+	}
+	updated, err := update(data, info)
+	if err != nil || updated == nil {
+		return err
+	}
+
+	return writeFileAtomic(path, updated, info.Mode().Perm())
+}
+
+// writeFileAtomic works like WriteFileAtomic, but expects the caller to hold
+// the lock.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicFunc(path, perm, func(destination io.Writer) error {
+		_, err := destination.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicFunc works like writeFileAtomic, but lets write produce the
+// content, so that it can be streamed. If write fails, path is left untouched.
+func writeFileAtomicFunc(path string, perm os.FileMode, write func(io.Writer) error) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+tempFileInfix+"*")
+	if err != nil {
+		return err
+	}
+	tempPath := temp.Name()
+
+	if err := temp.Chmod(perm); err != nil {
+		temp.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := write(temp); err != nil {
+		temp.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// tempFileInfix marks the temporary files of writeFileAtomicFunc.
+const tempFileInfix = ".tmp-"
+
+// isVaultFile reports whether name is a lock or temporary file of this
+// package, which must not be treated as encrypted data.
+func isVaultFile(name string) bool {
+	return strings.HasSuffix(name, LockFileSuffix) ||
+		(strings.HasPrefix(name, ".") && strings.Contains(name, tempFileInfix))
+}
@@ -0,0 +1,169 @@
+package utils_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghmer/go-tiny-mfa/utils"
+)
+
+func TestDecryptShortInput(t *testing.T) {
+	for size := 0; size < 28; size++ {
+		short := make([]byte, size)
+		if _, err := util.Decrypt(&short, &passphrase); !errors.Is(err, utils.ErrCiphertextTooShort) {
+			t.Errorf("%d bytes: expected ErrCiphertextTooShort, got %v", size, err)
+		}
+	}
+
+	encrypted, _ := util.Encrypt(&data, &passphrase)
+	truncated := (*encrypted)[:len(*encrypted)-len(data)-1]
+	if _, err := util.Decrypt(&truncated, &passphrase); !errors.Is(err, utils.ErrCiphertextTooShort) {
+		t.Errorf("expected ErrCiphertextTooShort for a truncated envelope, got %v", err)
+	}
+}
+
+func TestDecryptTypedErrors(t *testing.T) {
+	encrypted, _ := util.Encrypt(&data, &passphrase)
+	if _, err := util.Decrypt(encrypted, &invalidpassphrase); !errors.Is(err, utils.ErrInvalidKeySize) {
+		t.Errorf("expected ErrInvalidKeySize, got %v", err)
+	}
+
+	encrypted, _ = util.Encrypt(&data, &passphrase)
+	wrongKey := []byte("mysecretpasswore")
+	if _, err := util.Decrypt(encrypted, &wrongKey); !errors.Is(err, utils.ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed for a wrong key, got %v", err)
+	}
+
+	encrypted, _ = util.Encrypt(&data, &passphrase)
+	(*encrypted)[len(*encrypted)-1] ^= 1
+	if _, err := util.Decrypt(encrypted, &passphrase); !errors.Is(err, utils.ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed for a corrupted ciphertext, got %v", err)
+	}
+
+	if !errors.Is(utils.ErrStreamTruncated, utils.ErrCiphertextTooShort) || !errors.Is(utils.ErrStreamCorrupted, utils.ErrDecryptionFailed) {
+		t.Error("expected stream errors to match the generic decryption errors")
+	}
+}
+
+func TestEncryptFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vault.enc")
+
+	if err := util.EncryptFile(filepath.Join(dir, "missing", "vault.enc"), &data, &passphrase); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+	if err := util.EncryptFile(file, &data, &invalidpassphrase); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected no file to be written for an invalid key")
+	}
+
+	if err := util.EncryptFile(file, &data, &passphrase); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, _ := os.Stat(file)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected 0600 permissions, got %v", info.Mode().Perm())
+	}
+
+	short := filepath.Join(dir, "short.enc")
+	if err := os.WriteFile(short, []byte("TMFS"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := util.DecryptFile(short, &passphrase); !errors.Is(err, utils.ErrCiphertextTooShort) {
+		t.Errorf("expected ErrCiphertextTooShort for a truncated file, got %v", err)
+	}
+}
+
+func TestEncryptFileConcurrentWriters(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vault.enc")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := util.EncryptFile(file, &data, &passphrase); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	decrypted, err := util.DecryptFile(file, &passphrase)
+	if err != nil || string(*decrypted) != string(data) {
+		t.Errorf("expected the file to decrypt after concurrent writes, got %v", err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vault.enc")
+	lock, err := utils.LockFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	written := make(chan error)
+	go func() {
+		written <- utils.WriteFileAtomic(file, data, 0600)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("expected the writer to wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lock.Unlock()
+	if err := <-written; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != string(data) {
+		t.Errorf("expected %s, got %s", data, content)
+	}
+}
+
+func TestUpdateFileAtomic(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "counter")
+	if err := os.WriteFile(file, []byte{0}, 0400); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := utils.UpdateFileAtomic(file, func(data []byte, info os.FileInfo) ([]byte, error) {
+				if info.Mode().Perm() != 0400 {
+					t.Errorf("expected the info of the file, got %v", info.Mode())
+				}
+				return []byte{data[0] + 1}, nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	content, _ := os.ReadFile(file)
+	if len(content) != 1 || content[0] != 8 {
+		t.Errorf("expected 8 serialized updates, got %v", content)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0400 {
+		t.Errorf("expected the permissions to be kept, got %v", info.Mode().Perm())
+	}
+
+	if err := utils.UpdateFileAtomic(file, func([]byte, os.FileInfo) ([]byte, error) { return nil, nil }); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if content, _ := os.ReadFile(file); content[0] != 8 {
+		t.Error("expected the file to be untouched")
+	}
+}